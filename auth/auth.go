@@ -2,26 +2,112 @@ package auth
 
 import (
 	"context"
+	"errors"
+	"time"
+
+	"encore.app/internal/pkg/authz"
 
 	"encore.dev/beta/auth"
 	"encore.dev/beta/errs"
+	"encore.dev/rlog"
+	"encore.dev/storage/sqldb"
 )
 
+// bootstrapUID is the identity of callers using the bootstrap token.
+const bootstrapUID = "bootstrap"
+
 var secrets struct {
+	// BearerToken is an optional admin token to create the first API
+	// keys with. It can be unset once they exist.
 	BearerToken string
 }
 
+// Data is the auth data of an authenticated caller.
 type Data struct {
 	Username string
+	// KeyID is the API key the caller authenticated with. It is empty
+	// for the bootstrap token.
+	KeyID  string
+	Role   authz.Role
+	Scopes []authz.Scope
+}
+
+// CallerRole implements authz.Caller.
+func (d *Data) CallerRole() authz.Role {
+	return d.Role
+}
+
+// CallerScopes implements authz.Caller.
+func (d *Data) CallerScopes() []authz.Scope {
+	return d.Scopes
+}
+
+var errInvalidToken = &errs.Error{
+	Code:    errs.Unauthenticated,
+	Message: "invalid token",
 }
 
 //encore:authhandler
 func AuthHandler(ctx context.Context, token string) (auth.UID, *Data, error) {
-	if token != secrets.BearerToken {
+	// An unset bootstrap token must not match.
+	if secrets.BearerToken != "" && tokenMatches(token, hashToken(secrets.BearerToken)) {
+		return bootstrapUID, &Data{Username: bootstrapUID, Role: authz.RoleAdmin}, nil
+	}
+
+	id, ok := parseKeyToken(token)
+	if !ok {
+		return "", nil, errInvalidToken
+	}
+
+	var hash []byte
+	key, err := scanKey(rowWithHash{db.QueryRow(ctx, `
+		SELECT `+keyColumns+`, hash FROM api_keys
+		WHERE id = $1 AND revoked_at IS NULL
+	`, id), &hash})
+	if err != nil {
+		if errors.Is(err, sqldb.ErrNoRows) {
+			return "", nil, errInvalidToken
+		}
+		rlog.Error("could not fetch API key", "key_id", id, "error", err)
+		return "", nil, &errs.Error{
+			Code:    errs.Internal,
+			Message: "could not check token",
+		}
+	}
+
+	if !tokenMatches(token, hash) {
+		return "", nil, errInvalidToken
+	}
+
+	if key.ExpiresAt != nil && !time.Now().Before(*key.ExpiresAt) {
 		return "", nil, &errs.Error{
 			Code:    errs.Unauthenticated,
-			Message: "invalid token",
+			Message: "token expired",
 		}
 	}
-	return "user", &Data{Username: "user"}, nil
+
+	// Failing to record the last use must not lock callers out.
+	if _, err := db.Exec(ctx, `
+		UPDATE api_keys SET last_used_at = NOW()
+		WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < $2)
+	`, id, time.Now().Add(-lastUsedInterval)); err != nil {
+		rlog.Warn("could not record API key use", "key_id", id, "error", err)
+	}
+
+	return auth.UID(key.Owner), &Data{
+		Username: key.Owner,
+		KeyID:    key.ID,
+		Role:     key.Role,
+		Scopes:   key.Scopes,
+	}, nil
+}
+
+// rowWithHash scans the columns of a key followed by its hash.
+type rowWithHash struct {
+	row  *sqldb.Row
+	hash *[]byte
+}
+
+func (r rowWithHash) Scan(dest ...any) error {
+	return r.row.Scan(append(dest, r.hash)...)
 }
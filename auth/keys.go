@@ -0,0 +1,382 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"encore.app/internal/pkg/apierror"
+	"encore.app/internal/pkg/authz"
+	"encore.app/internal/pkg/idutil"
+
+	"encore.dev/beta/auth"
+	"encore.dev/beta/errs"
+	"encore.dev/rlog"
+	"encore.dev/storage/sqldb"
+)
+
+const (
+	// keyPrefix starts every API key, so leaked keys are easy to spot.
+	keyPrefix = "imk_"
+	// keySecretSize is the number of random bytes of a key secret.
+	keySecretSize = 32
+
+	defaultGracePeriod = 24 * time.Hour
+
+	// lastUsedInterval throttles the last-used updates of a key.
+	lastUsedInterval = time.Minute
+)
+
+var db = sqldb.NewDatabase("auth", sqldb.DatabaseConfig{
+	Migrations: "./migrations",
+})
+
+const keyColumns = `
+	id, name, owner, role, scopes, created_by, created_at,
+	expires_at, last_used_at, revoked_at, revoked_by
+`
+
+// CreateKey creates an API key. The token is returned once and only its
+// hash is stored, so it can't be recovered later.
+//
+//encore:api auth method=POST path=/auth/keys
+func CreateKey(ctx context.Context, in *CreateKeyInput) (*CreatedKey, error) {
+	if err := authz.Require(authz.ScopeKeys, authz.RoleAdmin); err != nil {
+		return nil, err
+	}
+
+	if err := validateKey(in); err != nil {
+		return nil, err
+	}
+
+	if err := requireCallerScopes(in.Scopes); err != nil {
+		return nil, err
+	}
+
+	created, err := insertKey(ctx, db, in, actor())
+	if err != nil {
+		return nil, apierror.E("could not create key", err, errs.Internal)
+	}
+
+	rlog.Info("API key created", "key_id", created.Key.ID, "owner", created.Key.Owner, "role", created.Key.Role, "actor", actor())
+	return created, nil
+}
+
+// ListKeys lists the API keys, most recent first.
+//
+//encore:api auth method=GET path=/auth/keys
+func ListKeys(ctx context.Context, in *ListKeysInput) (*APIKeys, error) {
+	if err := authz.Require(authz.ScopeKeys, authz.RoleAdmin); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(ctx, `
+		SELECT `+keyColumns+` FROM api_keys
+		WHERE ($1 = '' OR owner = $1) AND ($2 OR revoked_at IS NULL)
+		ORDER BY created_at DESC
+	`, in.Owner, in.IncludeRevoked)
+	if err != nil {
+		return nil, apierror.E("could not list keys", err, errs.Internal)
+	}
+	defer rows.Close()
+
+	keys := APIKeys{Keys: make([]*APIKey, 0)}
+	for rows.Next() {
+		key, err := scanKey(rows)
+		if err != nil {
+			return nil, apierror.E("could not scan key", err, errs.Internal)
+		}
+		keys.Keys = append(keys.Keys, key)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, apierror.E("could not list keys", err, errs.Internal)
+	}
+	return &keys, nil
+}
+
+// RevokeKey revokes an API key right away. Revoking a revoked key does
+// nothing.
+//
+//encore:api auth method=DELETE path=/auth/keys/:id
+func RevokeKey(ctx context.Context, id string) (*APIKey, error) {
+	if err := authz.Require(authz.ScopeKeys, authz.RoleAdmin); err != nil {
+		return nil, err
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, apierror.E("could not begin transaction", err, errs.Internal)
+	}
+	defer tx.Rollback()
+
+	old, err := scanKey(tx.QueryRow(ctx, `
+		SELECT `+keyColumns+` FROM api_keys WHERE id = $1 FOR UPDATE
+	`, id))
+	if err != nil {
+		if errors.Is(err, sqldb.ErrNoRows) {
+			return nil, &errs.Error{
+				Code:    errs.NotFound,
+				Message: "key not found",
+			}
+		}
+		return nil, apierror.E("could not fetch key", err, errs.Internal)
+	}
+
+	if err := requireCallerScopes(old.Scopes); err != nil {
+		return nil, err
+	}
+
+	key, err := scanKey(tx.QueryRow(ctx, `
+		UPDATE api_keys SET
+			revoked_at = COALESCE(revoked_at, NOW()),
+			revoked_by = COALESCE(revoked_by, $2)
+		WHERE id = $1
+		RETURNING `+keyColumns, id, actor()))
+	if err != nil {
+		return nil, apierror.E("could not revoke key", err, errs.Internal)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, apierror.E("could not revoke key", err, errs.Internal)
+	}
+
+	rlog.Info("API key revoked", "key_id", key.ID, "owner", key.Owner, "actor", actor())
+	return key, nil
+}
+
+// RotateKey replaces an API key with a new one with the same name, owner,
+// role, scopes and expiry. The old key keeps working for a grace period,
+// giving its users the time to switch.
+//
+//encore:api auth method=POST path=/auth/keys/:id/rotate
+func RotateKey(ctx context.Context, id string, in *RotateKeyInput) (*CreatedKey, error) {
+	if err := authz.Require(authz.ScopeKeys, authz.RoleAdmin); err != nil {
+		return nil, err
+	}
+
+	grace := defaultGracePeriod
+	if in.GracePeriod != "" {
+		var err error
+		if grace, err = time.ParseDuration(in.GracePeriod); err != nil || grace < 0 {
+			return nil, &errs.Error{
+				Code:    errs.InvalidArgument,
+				Message: fmt.Sprintf("invalid grace period %q", in.GracePeriod),
+			}
+		}
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, apierror.E("could not begin transaction", err, errs.Internal)
+	}
+	defer tx.Rollback()
+
+	old, err := scanKey(tx.QueryRow(ctx, `
+		SELECT `+keyColumns+` FROM api_keys
+		WHERE id = $1 AND revoked_at IS NULL
+		FOR UPDATE
+	`, id))
+	if err != nil {
+		if errors.Is(err, sqldb.ErrNoRows) {
+			return nil, &errs.Error{
+				Code:    errs.NotFound,
+				Message: "key not found",
+			}
+		}
+		return nil, apierror.E("could not fetch key", err, errs.Internal)
+	}
+
+	if err := requireCallerScopes(old.Scopes); err != nil {
+		return nil, err
+	}
+
+	// LEAST ignores NULL, so keys without an expiry get one too.
+	if _, err := tx.Exec(ctx, `
+		UPDATE api_keys SET expires_at = LEAST(expires_at, $2) WHERE id = $1
+	`, id, time.Now().Add(grace)); err != nil {
+		return nil, apierror.E("could not expire key", err, errs.Internal)
+	}
+
+	created, err := insertKey(ctx, tx, &CreateKeyInput{
+		Name:      old.Name,
+		Owner:     old.Owner,
+		Role:      old.Role,
+		Scopes:    old.Scopes,
+		ExpiresAt: old.ExpiresAt,
+	}, actor())
+	if err != nil {
+		return nil, apierror.E("could not create key", err, errs.Internal)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, apierror.E("could not rotate key", err, errs.Internal)
+	}
+
+	rlog.Info("API key rotated", "key_id", old.ID, "new_key_id", created.Key.ID, "owner", old.Owner, "grace_period", grace, "actor", actor())
+	return created, nil
+}
+
+// requireCallerScopes denies managing a key with the given scopes to
+// callers limited to fewer scopes, so a key can't hand out, rotate or
+// revoke more than it has.
+func requireCallerScopes(scopes []authz.Scope) error {
+	caller, ok := auth.Data().(*Data)
+	if !ok || coversScopes(caller.Scopes, scopes) {
+		return nil
+	}
+	return &errs.Error{
+		Code:    errs.PermissionDenied,
+		Message: "keys can only be managed within the scopes of the caller",
+	}
+}
+
+// coversScopes reports whether a caller with the given scopes has all the
+// scopes of a key. An empty list stands for all scopes.
+func coversScopes(caller, scopes []authz.Scope) bool {
+	if len(caller) == 0 {
+		return true
+	}
+	if len(scopes) == 0 {
+		return false
+	}
+	return !slices.ContainsFunc(scopes, func(s authz.Scope) bool {
+		return !slices.Contains(caller, s)
+	})
+}
+
+func validateKey(in *CreateKeyInput) error {
+	invalid := func(msg string) error {
+		return &errs.Error{Code: errs.InvalidArgument, Message: msg}
+	}
+
+	if strings.TrimSpace(in.Name) == "" {
+		return invalid("name is required")
+	}
+	if strings.TrimSpace(in.Owner) == "" {
+		return invalid("owner is required")
+	}
+	if !in.Role.Valid() {
+		return invalid(fmt.Sprintf("invalid role %q", in.Role))
+	}
+	for _, s := range in.Scopes {
+		if !s.Valid() {
+			return invalid(fmt.Sprintf("invalid scope %q", s))
+		}
+	}
+	if in.ExpiresAt != nil && !in.ExpiresAt.After(time.Now()) {
+		return invalid("expiresAt must be in the future")
+	}
+	return nil
+}
+
+// querier is implemented by the database and its transactions.
+type querier interface {
+	QueryRow(ctx context.Context, query string, args ...any) *sqldb.Row
+}
+
+func insertKey(ctx context.Context, q querier, in *CreateKeyInput, createdBy string) (*CreatedKey, error) {
+	id, err := idutil.NewID()
+	if err != nil {
+		return nil, fmt.Errorf("could not generate key ID: %w", err)
+	}
+
+	token, hash, err := newKeyToken(id)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := scanKey(q.QueryRow(ctx, `
+		INSERT INTO api_keys (id, name, owner, role, scopes, hash, created_by, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING `+keyColumns,
+		id, in.Name, in.Owner, in.Role, scopeStrings(in.Scopes), hash, createdBy, in.ExpiresAt,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("could not insert key: %w", err)
+	}
+	return &CreatedKey{Key: key, Token: token}, nil
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanKey(row scanner) (*APIKey, error) {
+	var (
+		key    APIKey
+		scopes []string
+	)
+	if err := row.Scan(
+		&key.ID, &key.Name, &key.Owner, &key.Role, &scopes, &key.CreatedBy, &key.CreatedAt,
+		&key.ExpiresAt, &key.LastUsedAt, &key.RevokedAt, &key.RevokedBy,
+	); err != nil {
+		return nil, err
+	}
+
+	for _, s := range scopes {
+		key.Scopes = append(key.Scopes, authz.Scope(s))
+	}
+	return &key, nil
+}
+
+func scopeStrings(scopes []authz.Scope) []string {
+	s := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		s = append(s, string(scope))
+	}
+	return s
+}
+
+// newKeyToken returns a new token for the key ID and the hash to store.
+// The ID is part of the token, so the key is found without comparing the
+// hash against every stored key.
+func newKeyToken(id string) (token string, hash []byte, err error) {
+	secret := make([]byte, keySecretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return "", nil, fmt.Errorf("could not generate key secret: %w", err)
+	}
+
+	token = keyPrefix + id + "_" + hex.EncodeToString(secret)
+	return token, hashToken(token), nil
+}
+
+// parseKeyToken returns the key ID of a token, or false if the token is
+// not an API key.
+func parseKeyToken(token string) (string, bool) {
+	rest, ok := strings.CutPrefix(token, keyPrefix)
+	if !ok {
+		return "", false
+	}
+
+	id, secret, ok := strings.Cut(rest, "_")
+	if !ok || id == "" || len(secret) != hex.EncodedLen(keySecretSize) {
+		return "", false
+	}
+	return id, true
+}
+
+// hashToken hashes a token for storage. Tokens are random enough for a
+// single SHA-256 to be safe, unlike passwords.
+func hashToken(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
+
+// tokenMatches compares a token with a stored hash in constant time.
+func tokenMatches(token string, hash []byte) bool {
+	return subtle.ConstantTimeCompare(hashToken(token), hash) == 1
+}
+
+// actor is the caller, as it shows up in the logs.
+func actor() string {
+	uid, _ := auth.UserID()
+	return string(uid)
+}
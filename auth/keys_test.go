@@ -0,0 +1,125 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"encore.app/internal/pkg/authz"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyToken(t *testing.T) {
+	t.Parallel()
+
+	t.Run("parses the key ID back from new tokens", func(t *testing.T) {
+		t.Parallel()
+
+		token, hash, err := newKeyToken("01JFKQ3Z8V6Y2T5N4M3K2J1H0G")
+		require.NoError(t, err)
+
+		assert.True(t, strings.HasPrefix(token, keyPrefix))
+
+		id, ok := parseKeyToken(token)
+		require.True(t, ok)
+		assert.Equal(t, "01JFKQ3Z8V6Y2T5N4M3K2J1H0G", id)
+
+		assert.True(t, tokenMatches(token, hash))
+		assert.False(t, tokenMatches(token+"0", hash))
+	})
+
+	t.Run("new tokens are unique", func(t *testing.T) {
+		t.Parallel()
+
+		a, _, err := newKeyToken("id")
+		require.NoError(t, err)
+		b, _, err := newKeyToken("id")
+		require.NoError(t, err)
+		assert.NotEqual(t, a, b)
+	})
+
+	t.Run("rejects tokens that are not API keys", func(t *testing.T) {
+		t.Parallel()
+
+		secret := strings.Repeat("ab", keySecretSize)
+
+		for _, token := range []string{
+			"",
+			"some-password",
+			"imk_",
+			"imk_01JFKQ3Z8V6Y2T5N4M3K2J1H0G",
+			"imk__" + secret,
+			"imk_01JFKQ3Z8V6Y2T5N4M3K2J1H0G_abcd",
+			"xyz_01JFKQ3Z8V6Y2T5N4M3K2J1H0G_" + secret,
+		} {
+			_, ok := parseKeyToken(token)
+			assert.False(t, ok, token)
+		}
+	})
+}
+
+func TestValidateKey(t *testing.T) {
+	t.Parallel()
+
+	valid := func() *CreateKeyInput {
+		return &CreateKeyInput{
+			Name:   "CRM integration",
+			Owner:  "maria",
+			Role:   authz.RoleAgent,
+			Scopes: []authz.Scope{authz.ScopeLeads},
+		}
+	}
+
+	past := time.Now().Add(-time.Hour)
+
+	tests := map[string]func(in *CreateKeyInput){
+		"missing name":  func(in *CreateKeyInput) { in.Name = " " },
+		"missing owner": func(in *CreateKeyInput) { in.Owner = "" },
+		"unknown role":  func(in *CreateKeyInput) { in.Role = "owner" },
+		"unknown scope": func(in *CreateKeyInput) { in.Scopes = append(in.Scopes, "billing") },
+		"past expiry":   func(in *CreateKeyInput) { in.ExpiresAt = &past },
+	}
+
+	require.NoError(t, validateKey(valid()))
+
+	for name, change := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			in := valid()
+			change(in)
+			assert.Error(t, validateKey(in))
+		})
+	}
+}
+
+func TestCoversScopes(t *testing.T) {
+	t.Parallel()
+
+	all := []authz.Scope(nil)
+	leads := []authz.Scope{authz.ScopeLeads}
+	leadsAndKeys := []authz.Scope{authz.ScopeLeads, authz.ScopeKeys}
+
+	tests := map[string]struct {
+		caller, scopes []authz.Scope
+		want           bool
+	}{
+		"unlimited caller, unlimited key": {all, all, true},
+		"unlimited caller, limited key":   {all, leads, true},
+		"limited caller, unlimited key":   {leadsAndKeys, all, false},
+		"limited caller, same scopes":     {leadsAndKeys, leadsAndKeys, true},
+		"limited caller, fewer scopes":    {leadsAndKeys, leads, true},
+		"limited caller, more scopes":     {leads, leadsAndKeys, false},
+		"limited caller, other scope":     {leads, []authz.Scope{authz.ScopeProperties}, false},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.want, coversScopes(tc.caller, tc.scopes))
+		})
+	}
+}
@@ -0,0 +1,16 @@
+CREATE TABLE api_keys (
+    id VARCHAR(255) PRIMARY KEY,
+    name TEXT NOT NULL,
+    owner VARCHAR(255) NOT NULL,
+    role VARCHAR(32) NOT NULL,
+    scopes TEXT[] NOT NULL DEFAULT '{}',
+    hash BYTEA NOT NULL,
+    created_by VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP WITH TIME ZONE,
+    last_used_at TIMESTAMP WITH TIME ZONE,
+    revoked_at TIMESTAMP WITH TIME ZONE,
+    revoked_by VARCHAR(255)
+);
+
+CREATE INDEX idx_api_keys_owner ON api_keys (owner, created_at);
@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"time"
+
+	"encore.app/internal/pkg/authz"
+)
+
+// APIKey is an API key, without its secret.
+type APIKey struct {
+	ID    string     `json:"id"`
+	Name  string     `json:"name"`
+	Owner string     `json:"owner"`
+	Role  authz.Role `json:"role"`
+	// Scopes limits the key to some areas of the API.
+	// A key without scopes has access to all of them.
+	Scopes     []authz.Scope `json:"scopes,omitempty"`
+	CreatedBy  string        `json:"createdBy"`
+	CreatedAt  time.Time     `json:"createdAt"`
+	ExpiresAt  *time.Time    `json:"expiresAt,omitempty"`
+	LastUsedAt *time.Time    `json:"lastUsedAt,omitempty"`
+	RevokedAt  *time.Time    `json:"revokedAt,omitempty"`
+	RevokedBy  *string       `json:"revokedBy,omitempty"`
+}
+
+// CreatedKey is a new API key. Token is only ever returned here.
+type CreatedKey struct {
+	Key   *APIKey `json:"key"`
+	Token string  `json:"token"`
+}
+
+// CreateKeyInput holds the fields of a key to create.
+type CreateKeyInput struct {
+	Name string `json:"name"`
+	// Owner is the user the key identifies, who shows up in the logs.
+	Owner  string        `json:"owner"`
+	Role   authz.Role    `json:"role"`
+	Scopes []authz.Scope `json:"scopes,omitempty"`
+	// ExpiresAt is optional. Keys without it never expire.
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+// ListKeysInput filters the keys returned by ListKeys.
+type ListKeysInput struct {
+	Owner          string `query:"owner"`
+	IncludeRevoked bool   `query:"includeRevoked"`
+}
+
+// APIKeys is a list of API keys.
+type APIKeys struct {
+	Keys []*APIKey `json:"keys"`
+}
+
+// RotateKeyInput holds the options of a key rotation.
+type RotateKeyInput struct {
+	// GracePeriod is how long the old key keeps working, such as "1h".
+	// It defaults to 24 hours; "0s" expires it right away.
+	GracePeriod string `json:"gracePeriod,omitempty"`
+}
@@ -8,6 +8,9 @@ require (
 	github.com/oklog/ulid/v2 v2.1.0
 	github.com/stretchr/testify v1.10.0
 	go.mau.fi/whatsmeow v0.0.0-20241121132808-ae900cb6bee4
+	golang.org/x/image v0.18.0
+	google.golang.org/protobuf v1.35.2
+	rsc.io/qr v0.2.0
 )
 
 require (
@@ -34,7 +37,5 @@ require (
 	golang.org/x/sys v0.27.0 // indirect
 	golang.org/x/term v0.26.0 // indirect
 	golang.org/x/text v0.20.0 // indirect
-	google.golang.org/protobuf v1.35.2 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
-	rsc.io/qr v0.2.0 // indirect
 )
@@ -0,0 +1,234 @@
+package imolink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"encore.app/internal/pkg/apierror"
+	"encore.app/internal/pkg/authz"
+	"encore.dev/beta/errs"
+	"encore.dev/cron"
+	"encore.dev/rlog"
+)
+
+// gcGracePeriod protects resources that were just created and may not be
+// recorded in the database yet.
+const gcGracePeriod = time.Hour
+
+const (
+	resourceAssistant   = "assistant"
+	resourceVectorStore = "vector_store"
+	resourceFile        = "file"
+)
+
+var _ = cron.NewJob("imolink-gc", cron.JobConfig{
+	Title:    "Remove orphaned OpenAI files, vector stores and assistants",
+	Every:    6 * cron.Hour,
+	Endpoint: CollectGarbage,
+})
+
+// OrphanedResource is an OpenAI resource tagged as ours but not in use.
+type OrphanedResource struct {
+	Kind      string    `json:"kind"`
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// GCReport lists the orphaned resources found by a garbage collection run.
+type GCReport struct {
+	DryRun  bool                `json:"dryRun"`
+	Orphans []*OrphanedResource `json:"orphans"`
+	Deleted int                 `json:"deleted"`
+	Failed  int                 `json:"failed"`
+}
+
+// CollectGarbage deletes every tagged resource that is not the one currently in use.
+//
+//encore:api private method=POST path=/imolink/gc
+func (s *Service) CollectGarbage(ctx context.Context) (*GCReport, error) {
+	return s.collectGarbage(ctx, false)
+}
+
+// GarbageReport shows what CollectGarbage would delete, without deleting anything.
+//
+//encore:api auth method=GET path=/imolink/gc/report
+func (s *Service) GarbageReport(ctx context.Context) (*GCReport, error) {
+	if err := authz.Require(authz.ScopeAssistant, authz.RoleAdmin); err != nil {
+		return nil, err
+	}
+
+	return s.collectGarbage(ctx, true)
+}
+
+func (s *Service) collectGarbage(ctx context.Context, dryRun bool) (*GCReport, error) {
+	// Holding the lock keeps knowledge syncs from creating
+	// resources while we decide what is orphaned.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	inUse, err := s.resourcesInUse(ctx)
+	if err != nil {
+		return nil, apierror.E("could not determine resources in use", err, errs.Internal)
+	}
+
+	orphans, err := s.findOrphans(ctx, inUse)
+	if err != nil {
+		return nil, apierror.E("could not list OpenAI resources", err, errs.Internal)
+	}
+
+	report := GCReport{
+		DryRun:  dryRun,
+		Orphans: orphans,
+	}
+
+	if dryRun {
+		return &report, nil
+	}
+
+	// Assistants go first since they reference vector stores and files.
+	for _, kind := range []string{resourceAssistant, resourceVectorStore, resourceFile} {
+		for _, o := range orphans {
+			if o.Kind != kind {
+				continue
+			}
+			if err := s.deleteResource(ctx, o); err != nil {
+				rlog.Error("could not delete orphaned resource", "kind", o.Kind, "id", o.ID, "error", err)
+				report.Failed++
+				continue
+			}
+			report.Deleted++
+		}
+	}
+
+	rlog.Info("garbage collection done", "deleted", report.Deleted, "failed", report.Failed)
+	return &report, nil
+}
+
+// resourcesInUse returns the IDs referenced by the persisted assistant state.
+// The caller must hold s.mu.
+func (s *Service) resourcesInUse(ctx context.Context) (map[string]bool, error) {
+	inUse := make(map[string]bool)
+
+	state, err := loadAssistantState(ctx)
+	if err != nil && !errors.Is(err, errStateNotFound) {
+		return nil, err
+	}
+
+	if state != nil {
+		inUse[state.AssistantID] = true
+		inUse[state.VectorStoreID] = true
+		inUse[state.CatalogueFileID] = true
+	}
+
+	files, err := loadKnowledgeFiles(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, fileID := range files {
+		inUse[fileID] = true
+	}
+	return inUse, nil
+}
+
+func (s *Service) findOrphans(ctx context.Context, inUse map[string]bool) ([]*OrphanedResource, error) {
+	var (
+		orphans []*OrphanedResource
+		cutoff  = time.Now().Add(-gcGracePeriod)
+	)
+
+	isOrphan := func(id string, createdAt time.Time) bool {
+		return !inUse[id] && createdAt.Before(cutoff)
+	}
+
+	var after string
+	for {
+		page, err := s.client.ListAssistants(ctx, after)
+		if err != nil {
+			return nil, fmt.Errorf("could not list assistants: %w", err)
+		}
+
+		for _, a := range page.Data {
+			createdAt := time.Unix(a.CreatedAt, 0)
+			if isTagged(a.Metadata) && isOrphan(a.ID, createdAt) {
+				orphans = append(orphans, &OrphanedResource{
+					Kind:      resourceAssistant,
+					ID:        a.ID,
+					Name:      a.Name,
+					CreatedAt: createdAt,
+				})
+			}
+		}
+
+		if !page.HasMore {
+			break
+		}
+		after = page.LastID
+	}
+
+	after = ""
+	for {
+		page, err := s.client.ListVectorStores(ctx, after)
+		if err != nil {
+			return nil, fmt.Errorf("could not list vector stores: %w", err)
+		}
+
+		for _, vs := range page.Data {
+			createdAt := time.Unix(vs.CreatedAt, 0)
+			if isTagged(vs.Metadata) && isOrphan(vs.ID, createdAt) {
+				orphans = append(orphans, &OrphanedResource{
+					Kind:      resourceVectorStore,
+					ID:        vs.ID,
+					Name:      vs.Name,
+					CreatedAt: createdAt,
+				})
+			}
+		}
+
+		if !page.HasMore {
+			break
+		}
+		after = page.LastID
+	}
+
+	files, err := s.client.ListFiles(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not list files: %w", err)
+	}
+
+	prefix := resourceFilePrefix()
+	for _, f := range files.Data {
+		createdAt := time.Unix(f.CreatedAt, 0)
+		if strings.HasPrefix(f.Filename, prefix) && isOrphan(f.ID, createdAt) {
+			orphans = append(orphans, &OrphanedResource{
+				Kind:      resourceFile,
+				ID:        f.ID,
+				Name:      f.Filename,
+				CreatedAt: createdAt,
+			})
+		}
+	}
+	return orphans, nil
+}
+
+func (s *Service) deleteResource(ctx context.Context, o *OrphanedResource) error {
+	switch o.Kind {
+	case resourceAssistant:
+		return s.client.DeleteAssistant(ctx, o.ID)
+	case resourceVectorStore:
+		return s.client.DeleteVectorStore(ctx, o.ID)
+	case resourceFile:
+		return s.client.DeleteFile(ctx, o.ID)
+	}
+	return fmt.Errorf("unknown resource kind %q", o.Kind)
+}
+
+// isTagged reports whether the metadata marks the resource as
+// created by this app in the current environment.
+func isTagged(meta map[string]any) bool {
+	return meta[metadataAppKey] == metadataApp && meta[metadataEnvKey] == environment()
+}
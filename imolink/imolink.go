@@ -3,6 +3,7 @@ package imolink
 import (
 	"context"
 	"embed"
+	"errors"
 	"fmt"
 	"io"
 	"strings"
@@ -11,17 +12,33 @@ import (
 
 	"encore.app/imolink/formatter"
 	"encore.app/internal/pkg/apierror"
+	"encore.app/internal/pkg/authz"
 	"encore.app/internal/pkg/httpclient"
 	"encore.app/internal/pkg/openaicli"
 	"encore.app/properties"
+	"encore.dev"
 	"encore.dev/beta/errs"
+	"encore.dev/rlog"
+	"encore.dev/storage/sqldb"
 )
 
-const defaultTimeout = time.Minute
+const (
+	defaultTimeout = time.Minute
+
+	// Every OpenAI resource we create is tagged so that the garbage
+	// collector can tell ours apart from anything else in the account.
+	metadataAppKey = "app"
+	metadataApp    = "imolink"
+	metadataEnvKey = "env"
+)
 
 var (
 	Assistant *openaicli.Assistant
 
+	db = sqldb.NewDatabase("imolink", sqldb.DatabaseConfig{
+		Migrations: "./migrations",
+	})
+
 	//go:embed assets/*
 	assetsFS embed.FS
 
@@ -32,17 +49,42 @@ var (
 
 type (
 	openAIClient interface {
-		UploadFile(ctx context.Context, data io.Reader, purpose string) (*openaicli.FileUploadResponse, error)
+		UploadNamedFile(ctx context.Context, filename string, data io.Reader, purpose string) (*openaicli.FileUploadResponse, error)
+		ListFiles(ctx context.Context) (*openaicli.FileList, error)
+		ListVectorStores(ctx context.Context, after string) (*openaicli.VectorStoreList, error)
+		DeleteVectorStore(ctx context.Context, vectorStoreID string) error
+		ListAssistants(ctx context.Context, after string) (*openaicli.AssistantList, error)
+		DeleteAssistant(ctx context.Context, assistantID string) error
 		CreateVectorStore(ctx context.Context, in *openaicli.CreateVectorStoreInput) (*openaicli.VectorStore, error)
 		WaitForVectorStoreCompletion(ctx context.Context, vectorStoreID string, timeout, maxDelay time.Duration) error
 		CreateAssistant(ctx context.Context, cfg *openaicli.CreateAssistantInput) (*openaicli.Assistant, error)
+		GetAssistant(ctx context.Context, assistantID string) (*openaicli.Assistant, error)
+		ModifyAssistant(ctx context.Context, assistantID string, in *openaicli.ModifyAssistantInput) (*openaicli.Assistant, error)
+		AddVectorStoreFile(ctx context.Context, vectorStoreID, fileID string) (*openaicli.VectorStoreFile, error)
+		RemoveVectorStoreFile(ctx context.Context, vectorStoreID, fileID string) error
+		DeleteFile(ctx context.Context, fileID string) error
+	}
+
+	// knowledge tracks the OpenAI resources backing the assistant.
+	knowledge struct {
+		vectorStoreID   string
+		catalogueFileID string            // full catalogue used by the code interpreter
+		files           map[string]string // property ID -> vector store file ID
 	}
 )
 
 //encore:service
 type Service struct {
-	client openAIClient
-	mu     sync.RWMutex // to protect assistant updates
+	client    openAIClient
+	mu        sync.RWMutex // to protect assistant updates
+	knowledge knowledge
+
+	// catalogueDirty is set when property changes are not in the
+	// catalogue yet, which catalogueTimer refreshes unless the service
+	// is stopping. All three are guarded by mu.
+	catalogueDirty bool
+	catalogueTimer *time.Timer
+	stopping       bool
 }
 
 func initService() (*Service, error) {
@@ -56,53 +98,152 @@ func initService() (*Service, error) {
 	}, nil
 }
 
-//encore:api public method=POST path=/imolink/init-assistant
+// InitAssistant lets an admin reload the assistant, for instance after
+// changing its instructions.
+//
+//encore:api auth method=POST path=/imolink/init-assistant
+func (s *Service) InitAssistant(ctx context.Context) error {
+	if err := authz.Require(authz.ScopeAssistant, authz.RoleAdmin); err != nil {
+		return err
+	}
+	return s.InitializeAssistant(ctx)
+}
+
+// InitializeAssistant restores the stored assistant, or creates one.
+// It is called by the whatsapp service when it starts.
+//
+//encore:api private method=POST path=/imolink/assistant/initialize
 func (s *Service) InitializeAssistant(ctx context.Context) error {
-	assistant, err := s.initializeAssistantWithProperties(ctx)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	restored, err := s.restoreAssistant(ctx)
 	if err != nil {
-		return apierror.E("failed to initialize assistant", err, errs.Internal)
+		return apierror.E("failed to restore assistant", err, errs.Internal)
 	}
 
-	s.mu.Lock()
-	Assistant = assistant
-	s.mu.Unlock()
+	if restored {
+		return nil
+	}
+
+	if err := s.initializeAssistantWithProperties(ctx); err != nil {
+		return apierror.E("failed to initialize assistant", err, errs.Internal)
+	}
 	return nil
 }
 
-func (s *Service) initializeAssistantWithProperties(ctx context.Context) (*openaicli.Assistant, error) {
+// restoreAssistant reuses the assistant stored in the database, updating it
+// in place when its configuration changed. It reports false when there is
+// no usable stored assistant. The caller must hold s.mu.
+func (s *Service) restoreAssistant(ctx context.Context) (bool, error) {
+	state, err := loadAssistantState(ctx)
+	if err != nil {
+		if errors.Is(err, errStateNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	assist, err := s.client.GetAssistant(ctx, state.AssistantID)
+	if err != nil {
+		if errors.Is(err, openaicli.ErrNotFound) {
+			rlog.Warn("stored assistant no longer exists, creating a new one", "assistant_id", state.AssistantID)
+			return false, nil
+		}
+		return false, fmt.Errorf("could not get assistant: %w", err)
+	}
+
+	files, err := loadKnowledgeFiles(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	cfg := assistantCfg(state.CatalogueFileID, state.VectorStoreID)
+	hash, err := configHash(cfg)
+	if err != nil {
+		return false, err
+	}
+
+	if hash != state.ConfigHash {
+		assist, err = s.client.ModifyAssistant(ctx, assist.ID, &openaicli.ModifyAssistantInput{
+			Metadata:      cfg.Metadata,
+			Name:          cfg.Name,
+			Description:   cfg.Description,
+			Model:         cfg.Model,
+			Instructions:  cfg.Instructions,
+			Tools:         cfg.Tools,
+			ToolResources: &cfg.ToolResources,
+		})
+		if err != nil {
+			return false, fmt.Errorf("could not update assistant config: %w", err)
+		}
+
+		state.ConfigHash = hash
+		if err := saveAssistantState(ctx, state); err != nil {
+			return false, err
+		}
+		rlog.Info("updated assistant config", "assistant_id", assist.ID)
+	}
+
+	Assistant = assist
+	s.knowledge = knowledge{
+		vectorStoreID:   state.VectorStoreID,
+		catalogueFileID: state.CatalogueFileID,
+		files:           files,
+	}
+
+	// Changes synced before a restart may not have reached the catalogue.
+	if state.CatalogueChangedAt != nil {
+		s.scheduleCatalogueRefresh()
+	}
+	return true, nil
+}
+
+// initializeAssistantWithProperties creates the assistant and its knowledge
+// from scratch. The caller must hold s.mu.
+func (s *Service) initializeAssistantWithProperties(ctx context.Context) error {
 	// We fetch the properties from the db and  upload the data
 	// to openai so that we can use it with the code interpreter tool.
 
-	props, err := properties.List(ctx, properties.ListInput{})
+	props, err := listAllProperties(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("could not list properties: %w", err)
+		return fmt.Errorf("could not list properties: %w", err)
 	}
 
-	if len(props.Properties) == 0 {
-		return nil, fmt.Errorf("no properties available in the database")
+	if len(props) == 0 {
+		return fmt.Errorf("no properties available in the database")
 	}
 
-	uploadedFile, err := s.client.UploadFile(
-		ctx,
-		strings.NewReader(
-			formatter.FormatProperties(props.Properties),
-		),
-		"assistants",
-	)
+	catalogueFile, err := s.uploadCatalogue(ctx, props)
 	if err != nil {
-		return nil, fmt.Errorf("could not upload properties data: %w", err)
+		return err
 	}
 
-	// Once we have the file uploaded, we create a vector store.
+	// Each property gets its own file in the vector store so that
+	// changes to one property only replace that property's file.
+
+	files := make(map[string]string, len(props))
+	fileIDs := make([]string, 0, len(props))
+	for _, p := range props {
+		fileID, err := s.uploadProperty(ctx, p)
+		if err != nil {
+			return err
+		}
+		files[p.ID] = fileID
+		fileIDs = append(fileIDs, fileID)
+	}
+
+	// Once we have the files uploaded, we create a vector store.
 
 	vectorStore, err := s.client.CreateVectorStore(ctx,
 		&openaicli.CreateVectorStoreInput{
-			Name:    "properties",
-			FileIDs: []string{uploadedFile.ID},
+			Metadata: resourceMetadata(),
+			Name:     "properties",
+			FileIDs:  fileIDs,
 		},
 	)
 	if err != nil {
-		return nil, fmt.Errorf("could not create vector store file: %w", err)
+		return fmt.Errorf("could not create vector store file: %w", err)
 	}
 
 	if vectorStore.Status != "completed" {
@@ -112,15 +253,119 @@ func (s *Service) initializeAssistantWithProperties(ctx context.Context) (*opena
 			defaultTimeout,
 			10*time.Second,
 		); err != nil {
-			return nil, fmt.Errorf("could not wait for vector store completion: %w", err)
+			return fmt.Errorf("could not wait for vector store completion: %w", err)
 		}
 	}
 
-	assist, err := s.client.CreateAssistant(ctx, assistantCfg(uploadedFile.ID, vectorStore.ID))
+	cfg := assistantCfg(catalogueFile, vectorStore.ID)
+	hash, err := configHash(cfg)
 	if err != nil {
-		return nil, fmt.Errorf("could not create assistant: %w", err)
+		return err
+	}
+
+	assist, err := s.client.CreateAssistant(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("could not create assistant: %w", err)
+	}
+
+	if err := saveAssistantState(ctx, &assistantState{
+		AssistantID:     assist.ID,
+		VectorStoreID:   vectorStore.ID,
+		CatalogueFileID: catalogueFile,
+		ConfigHash:      hash,
+	}); err != nil {
+		return err
+	}
+
+	if err := replaceKnowledgeFiles(ctx, files); err != nil {
+		return err
+	}
+
+	Assistant = assist
+	s.knowledge = knowledge{
+		vectorStoreID:   vectorStore.ID,
+		catalogueFileID: catalogueFile,
+		files:           files,
+	}
+	return nil
+}
+
+// uploadCatalogue uploads every property as a single file and returns its ID.
+func (s *Service) uploadCatalogue(ctx context.Context, props []*properties.Property) (string, error) {
+	uploadedFile, err := s.client.UploadNamedFile(
+		ctx,
+		resourceFilename("catalogue"),
+		strings.NewReader(
+			formatter.FormatProperties(props),
+		),
+		"assistants",
+	)
+	if err != nil {
+		return "", fmt.Errorf("could not upload properties data: %w", err)
+	}
+	return uploadedFile.ID, nil
+}
+
+// uploadProperty uploads a single property as its own file and returns its ID.
+func (s *Service) uploadProperty(ctx context.Context, p *properties.Property) (string, error) {
+	uploadedFile, err := s.client.UploadNamedFile(
+		ctx,
+		resourceFilename("property_"+p.Reference),
+		strings.NewReader(
+			formatter.FormatProperties([]*properties.Property{p}),
+		),
+		"assistants",
+	)
+	if err != nil {
+		return "", fmt.Errorf("could not upload property %s: %w", p.Reference, err)
+	}
+	return uploadedFile.ID, nil
+}
+
+// resourceMetadata returns the tags set on the vector stores we create.
+func resourceMetadata() openaicli.Meta {
+	return openaicli.Meta{
+		metadataAppKey: metadataApp,
+		metadataEnvKey: environment(),
+	}
+}
+
+// resourceFilename returns a filename tagged with our app and environment,
+// since OpenAI files do not support metadata.
+func resourceFilename(kind string) string {
+	return fmt.Sprintf("%s%s_%d.json", resourceFilePrefix(), kind, time.Now().UnixNano())
+}
+
+func resourceFilePrefix() string {
+	return fmt.Sprintf("%s_%s_", metadataApp, environment())
+}
+
+func environment() string {
+	return encore.Meta().Environment.Name
+}
+
+// listAllProperties walks every page of properties.List.
+func listAllProperties(ctx context.Context) ([]*properties.Property, error) {
+	var (
+		all    []*properties.Property
+		cursor string
+	)
+
+	for {
+		page, err := properties.List(ctx, properties.ListInput{
+			Cursor: cursor,
+			Limit:  200,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		all = append(all, page.Properties...)
+		if page.NextCursor == "" {
+			return all, nil
+		}
+		cursor = page.NextCursor
 	}
-	return assist, nil
 }
 
 func assistantCfg(fileID, vectorStoreID string) *openaicli.CreateAssistantInput {
@@ -136,15 +381,21 @@ func assistantCfg(fileID, vectorStoreID string) *openaicli.CreateAssistantInput
 				Type:     openaicli.ToolTypeFunction,
 				Function: leadFunctionDefinition(),
 			},
+			{
+				Type:     openaicli.ToolTypeFunction,
+				Function: searchPropertiesFunctionDefinition(),
+			},
 		},
 		ToolResources: openaicli.ToolResources{
 			CodeInterpreter: &openaicli.CodeInterpreter{FileIDs: []string{fileID}},
 			FileSearch:      &openaicli.FileSearch{VectorStoreIDs: []string{vectorStoreID}},
 		},
 		Metadata: openaicli.Meta{
-			"type":    "real_estate_assistant",
-			"region":  "Aracaju",
-			"version": "1.0",
+			"type":         "real_estate_assistant",
+			"region":       "Aracaju",
+			"version":      "1.0",
+			metadataAppKey: metadataApp,
+			metadataEnvKey: environment(),
 		},
 	}
 }
@@ -166,3 +417,58 @@ func leadFunctionDefinition() *openaicli.FunctionDefinition {
 		},
 	}
 }
+
+func searchPropertiesFunctionDefinition() *openaicli.FunctionDefinition {
+	return &openaicli.FunctionDefinition{
+		Name: "search_properties",
+		Description: "Search the properties database with structured criteria. " +
+			"Use it whenever the user gives objective criteria such as property type, district, price range, bedrooms or features. " +
+			"Returns the matching properties with their references.",
+		Parameters: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"property_type": map[string]any{
+					"type":        "string",
+					"description": "Property type, e.g. casa, apartamento, sobrado",
+				},
+				"district": map[string]any{
+					"type":        "string",
+					"description": "District (bairro), e.g. Jardins, Atalaia",
+				},
+				"city": map[string]any{
+					"type":        "string",
+					"description": "City, e.g. Aracaju",
+				},
+				"min_price": map[string]any{
+					"type":        "number",
+					"description": "Minimum price in BRL",
+				},
+				"max_price": map[string]any{
+					"type":        "number",
+					"description": "Maximum price in BRL",
+				},
+				"min_bedrooms": map[string]any{
+					"type":        "integer",
+					"description": "Minimum number of bedrooms",
+				},
+				"min_bathrooms": map[string]any{
+					"type":        "integer",
+					"description": "Minimum number of bathrooms",
+				},
+				"min_garage_spots": map[string]any{
+					"type":        "integer",
+					"description": "Minimum number of garage spots",
+				},
+				"features": map[string]any{
+					"type":        "array",
+					"items":       map[string]any{"type": "string"},
+					"description": "Features the property must have, written exactly as in the database, e.g. piscina",
+				},
+				"text": map[string]any{
+					"type":        "string",
+					"description": "Free text matched against the property name and description",
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,237 @@
+package imolink
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"encore.app/internal/pkg/openaicli"
+	"encore.app/properties"
+	"encore.dev/beta/errs"
+	"encore.dev/pubsub"
+	"encore.dev/rlog"
+)
+
+const (
+	// catalogueRefreshDelay batches the catalogue uploads of property
+	// changes arriving together, such as a bulk import.
+	catalogueRefreshDelay   = 30 * time.Second
+	catalogueRefreshTimeout = 5 * time.Minute
+)
+
+var _ = pubsub.NewSubscription(
+	properties.PropertyChanges,
+	"imolink-knowledge-sync",
+	pubsub.SubscriptionConfig[*properties.PropertyChangedEvent]{
+		Handler: pubsub.MethodHandler((*Service).SyncPropertyChange),
+	},
+)
+
+// SyncPropertyChange updates the assistant knowledge for a single changed property.
+// Only the property's own file in the vector store is replaced; the assistant
+// is then pointed at a refreshed catalogue without being recreated, once the
+// changes arriving together are synced.
+func (s *Service) SyncPropertyChange(ctx context.Context, evt *properties.PropertyChangedEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Without an assistant there is nothing to update incrementally,
+	// so the first change trains the assistant from scratch.
+	if Assistant == nil || s.knowledge.vectorStoreID == "" {
+		if evt.Kind == properties.ChangeDeleted {
+			return nil
+		}
+		if err := s.initializeAssistantWithProperties(ctx); err != nil {
+			return fmt.Errorf("could not initialize assistant: %w", err)
+		}
+		return nil
+	}
+
+	switch evt.Kind {
+	case properties.ChangeCreated, properties.ChangeUpdated:
+		// Get reports off-market properties as missing. The assistant must
+		// not recommend them either, since they are only shown to the
+		// leads they are shared with, and a deleted property's file goes
+		// with it.
+		prop, err := properties.Get(ctx, evt.PropertyID)
+		if err != nil {
+			if errs.Code(err) != errs.NotFound {
+				return fmt.Errorf("could not get property %s: %w", evt.PropertyID, err)
+			}
+			if err := s.removePropertyFile(ctx, evt.PropertyID); err != nil {
+				return err
+			}
+			break
+		}
+
+		if err := s.replacePropertyFile(ctx, prop); err != nil {
+			return err
+		}
+	case properties.ChangeDeleted:
+		if err := s.removePropertyFile(ctx, evt.PropertyID); err != nil {
+			return err
+		}
+	default:
+		rlog.Warn("ignoring unknown property change", "kind", evt.Kind, "property_id", evt.PropertyID)
+		return nil
+	}
+
+	// The change is recorded before the message is acked, so a restart
+	// before the refresh doesn't lose it.
+	if err := markCatalogueChanged(ctx); err != nil {
+		return err
+	}
+	s.scheduleCatalogueRefresh()
+
+	rlog.Info("synced property change", "kind", evt.Kind, "reference", evt.Reference)
+	return nil
+}
+
+// scheduleCatalogueRefresh marks the catalogue as stale. It is refreshed
+// once catalogueRefreshDelay after the first change, so a batch of changes
+// uploads it a single time. Once the service is stopping, the refresh is
+// left to the next boot. The caller must hold s.mu.
+func (s *Service) scheduleCatalogueRefresh() {
+	s.catalogueDirty = true
+	if s.catalogueTimer == nil && !s.stopping {
+		s.catalogueTimer = time.AfterFunc(catalogueRefreshDelay, func() {
+			ctx, cancel := context.WithTimeout(context.Background(), catalogueRefreshTimeout)
+			defer cancel()
+			s.flushCatalogue(ctx)
+		})
+	}
+}
+
+// flushCatalogue refreshes the catalogue if property changes are pending,
+// retrying later on failure.
+func (s *Service) flushCatalogue(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.catalogueTimer = nil
+	if !s.catalogueDirty {
+		return
+	}
+
+	s.catalogueDirty = false
+	if err := s.refreshCatalogue(ctx); err != nil {
+		rlog.Error("could not refresh catalogue", "error", err)
+		s.scheduleCatalogueRefresh()
+	}
+}
+
+// Shutdown refreshes the catalogue if changes are pending, until force is
+// done. Changes it can't refresh stay marked in the database for the next
+// boot.
+func (s *Service) Shutdown(force context.Context) {
+	s.mu.Lock()
+	s.stopping = true
+	if s.catalogueTimer != nil {
+		s.catalogueTimer.Stop()
+		s.catalogueTimer = nil
+	}
+	s.mu.Unlock()
+
+	s.flushCatalogue(force)
+}
+
+// replacePropertyFile uploads the property and swaps it for its previous file
+// in the vector store. The caller must hold s.mu.
+func (s *Service) replacePropertyFile(ctx context.Context, prop *properties.Property) error {
+	fileID, err := s.uploadProperty(ctx, prop)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.client.AddVectorStoreFile(ctx, s.knowledge.vectorStoreID, fileID); err != nil {
+		return fmt.Errorf("could not add property %s to vector store: %w", prop.Reference, err)
+	}
+
+	if err := s.removePropertyFile(ctx, prop.ID); err != nil {
+		return err
+	}
+
+	if err := saveKnowledgeFile(ctx, prop.ID, fileID); err != nil {
+		return err
+	}
+
+	s.knowledge.files[prop.ID] = fileID
+	return nil
+}
+
+// removePropertyFile detaches and deletes the property's file, if any.
+// The caller must hold s.mu.
+func (s *Service) removePropertyFile(ctx context.Context, propertyID string) error {
+	fileID, ok := s.knowledge.files[propertyID]
+	if !ok {
+		return nil
+	}
+
+	if err := s.client.RemoveVectorStoreFile(ctx, s.knowledge.vectorStoreID, fileID); err != nil {
+		return fmt.Errorf("could not remove file %s from vector store: %w", fileID, err)
+	}
+
+	if err := s.client.DeleteFile(ctx, fileID); err != nil {
+		return fmt.Errorf("could not delete file %s: %w", fileID, err)
+	}
+
+	if err := deleteKnowledgeFile(ctx, propertyID); err != nil {
+		return err
+	}
+
+	delete(s.knowledge.files, propertyID)
+	return nil
+}
+
+// refreshCatalogue uploads the full catalogue for the code interpreter and
+// points the existing assistant at it. The caller must hold s.mu.
+func (s *Service) refreshCatalogue(ctx context.Context) error {
+	// Changes marked after the state is loaded may be missing from the
+	// listing, so they keep the catalogue marked as changed.
+	state, err := loadAssistantState(ctx)
+	if err != nil {
+		return err
+	}
+	changedAt := state.CatalogueChangedAt
+
+	props, err := listAllProperties(ctx)
+	if err != nil {
+		return fmt.Errorf("could not list properties: %w", err)
+	}
+
+	catalogueFile, err := s.uploadCatalogue(ctx, props)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.client.ModifyAssistant(ctx, Assistant.ID, &openaicli.ModifyAssistantInput{
+		ToolResources: &openaicli.ToolResources{
+			CodeInterpreter: &openaicli.CodeInterpreter{FileIDs: []string{catalogueFile}},
+			FileSearch:      &openaicli.FileSearch{VectorStoreIDs: []string{s.knowledge.vectorStoreID}},
+		},
+	}); err != nil {
+		return fmt.Errorf("could not update assistant resources: %w", err)
+	}
+
+	state.CatalogueFileID = catalogueFile
+	if err := saveAssistantState(ctx, state); err != nil {
+		return err
+	}
+
+	previous := s.knowledge.catalogueFileID
+	s.knowledge.catalogueFileID = catalogueFile
+
+	if previous != "" {
+		if err := s.client.DeleteFile(ctx, previous); err != nil {
+			rlog.Warn("could not delete previous catalogue file", "file_id", previous, "error", err)
+		}
+	}
+
+	// A mark left behind only costs another refresh on the next boot.
+	if changedAt != nil {
+		if err := clearCatalogueChanged(ctx, *changedAt); err != nil {
+			rlog.Warn("could not clear catalogue changes", "error", err)
+		}
+	}
+	return nil
+}
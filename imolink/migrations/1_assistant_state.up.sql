@@ -0,0 +1,15 @@
+CREATE TABLE assistant_state (
+    name VARCHAR(64) PRIMARY KEY,
+    assistant_id VARCHAR(255) NOT NULL,
+    vector_store_id VARCHAR(255) NOT NULL,
+    catalogue_file_id VARCHAR(255) NOT NULL,
+    config_hash VARCHAR(64) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE knowledge_files (
+    property_id VARCHAR(255) PRIMARY KEY,
+    file_id VARCHAR(255) NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
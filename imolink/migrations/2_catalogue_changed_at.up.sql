@@ -0,0 +1,3 @@
+-- catalogue_changed_at is set while property changes are not in the
+-- catalogue yet, so a refresh interrupted by a restart is done on boot.
+ALTER TABLE assistant_state ADD COLUMN catalogue_changed_at TIMESTAMP WITH TIME ZONE;
@@ -0,0 +1,166 @@
+package imolink
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"encore.app/internal/pkg/openaicli"
+	"encore.dev/storage/sqldb"
+)
+
+// defaultAssistantName is the assistant_state row used by the service.
+const defaultAssistantName = "default"
+
+var errStateNotFound = errors.New("assistant state not found")
+
+// assistantState is the persisted set of OpenAI resources behind the assistant.
+type assistantState struct {
+	AssistantID     string
+	VectorStoreID   string
+	CatalogueFileID string
+	ConfigHash      string
+	// CatalogueChangedAt is the time of the last property change not in
+	// the catalogue yet, if any.
+	CatalogueChangedAt *time.Time
+}
+
+func loadAssistantState(ctx context.Context) (*assistantState, error) {
+	var st assistantState
+	if err := db.QueryRow(ctx, `
+		SELECT assistant_id, vector_store_id, catalogue_file_id, config_hash, catalogue_changed_at
+		FROM assistant_state
+		WHERE name = $1
+	`, defaultAssistantName).Scan(
+		&st.AssistantID, &st.VectorStoreID, &st.CatalogueFileID, &st.ConfigHash, &st.CatalogueChangedAt,
+	); err != nil {
+		if errors.Is(err, sqldb.ErrNoRows) {
+			return nil, errStateNotFound
+		}
+		return nil, fmt.Errorf("could not load assistant state: %w", err)
+	}
+	return &st, nil
+}
+
+func saveAssistantState(ctx context.Context, st *assistantState) error {
+	if _, err := db.Exec(ctx, `
+		INSERT INTO assistant_state (name, assistant_id, vector_store_id, catalogue_file_id, config_hash)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (name) DO UPDATE SET
+			assistant_id = EXCLUDED.assistant_id,
+			vector_store_id = EXCLUDED.vector_store_id,
+			catalogue_file_id = EXCLUDED.catalogue_file_id,
+			config_hash = EXCLUDED.config_hash,
+			updated_at = NOW()
+	`,
+		defaultAssistantName, st.AssistantID, st.VectorStoreID,
+		st.CatalogueFileID, st.ConfigHash,
+	); err != nil {
+		return fmt.Errorf("could not save assistant state: %w", err)
+	}
+	return nil
+}
+
+// markCatalogueChanged records that a property change is not in the
+// catalogue yet.
+func markCatalogueChanged(ctx context.Context) error {
+	if _, err := db.Exec(ctx, `
+		UPDATE assistant_state SET catalogue_changed_at = NOW() WHERE name = $1
+	`, defaultAssistantName); err != nil {
+		return fmt.Errorf("could not mark catalogue changed: %w", err)
+	}
+	return nil
+}
+
+// clearCatalogueChanged records that the catalogue has the property changes
+// marked up to changedAt. Changes marked later keep it marked.
+func clearCatalogueChanged(ctx context.Context, changedAt time.Time) error {
+	if _, err := db.Exec(ctx, `
+		UPDATE assistant_state SET catalogue_changed_at = NULL
+		WHERE name = $1 AND catalogue_changed_at <= $2
+	`, defaultAssistantName, changedAt); err != nil {
+		return fmt.Errorf("could not clear catalogue changed: %w", err)
+	}
+	return nil
+}
+
+func loadKnowledgeFiles(ctx context.Context) (map[string]string, error) {
+	rows, err := db.Query(ctx, `SELECT property_id, file_id FROM knowledge_files`)
+	if err != nil {
+		return nil, fmt.Errorf("could not load knowledge files: %w", err)
+	}
+	defer rows.Close()
+
+	files := make(map[string]string)
+	for rows.Next() {
+		var propertyID, fileID string
+		if err := rows.Scan(&propertyID, &fileID); err != nil {
+			return nil, fmt.Errorf("could not scan knowledge file: %w", err)
+		}
+		files[propertyID] = fileID
+	}
+	return files, rows.Err()
+}
+
+// replaceKnowledgeFiles swaps the whole property -> file mapping.
+func replaceKnowledgeFiles(ctx context.Context, files map[string]string) error {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(ctx, `DELETE FROM knowledge_files`); err != nil {
+		return fmt.Errorf("could not clear knowledge files: %w", err)
+	}
+
+	for propertyID, fileID := range files {
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO knowledge_files (property_id, file_id) VALUES ($1, $2)
+		`, propertyID, fileID); err != nil {
+			return fmt.Errorf("could not store knowledge file: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+func saveKnowledgeFile(ctx context.Context, propertyID, fileID string) error {
+	if _, err := db.Exec(ctx, `
+		INSERT INTO knowledge_files (property_id, file_id)
+		VALUES ($1, $2)
+		ON CONFLICT (property_id) DO UPDATE SET
+			file_id = EXCLUDED.file_id,
+			updated_at = NOW()
+	`, propertyID, fileID); err != nil {
+		return fmt.Errorf("could not save knowledge file: %w", err)
+	}
+	return nil
+}
+
+func deleteKnowledgeFile(ctx context.Context, propertyID string) error {
+	if _, err := db.Exec(ctx, `
+		DELETE FROM knowledge_files WHERE property_id = $1
+	`, propertyID); err != nil {
+		return fmt.Errorf("could not delete knowledge file: %w", err)
+	}
+	return nil
+}
+
+// configHash fingerprints the assistant configuration, ignoring the
+// tool resources since those change on every knowledge sync.
+func configHash(cfg *openaicli.CreateAssistantInput) (string, error) {
+	c := *cfg
+	c.ToolResources = openaicli.ToolResources{}
+
+	b, err := json.Marshal(c)
+	if err != nil {
+		return "", fmt.Errorf("could not marshal assistant config: %w", err)
+	}
+
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
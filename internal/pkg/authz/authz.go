@@ -0,0 +1,107 @@
+// Package authz checks the role of the authenticated caller of an endpoint.
+package authz
+
+import (
+	"fmt"
+	"slices"
+
+	"encore.dev/beta/auth"
+	"encore.dev/beta/errs"
+)
+
+// Role is the access level of a caller.
+type Role string
+
+// Roles, from the most to the least privileged.
+const (
+	// RoleAdmin can do anything, including wiping data and managing the
+	// WhatsApp login and the assistant.
+	RoleAdmin Role = "admin"
+	// RoleAgent manages properties and works the leads.
+	RoleAgent Role = "agent"
+	// RoleReadOnly can only read.
+	RoleReadOnly Role = "read-only"
+)
+
+var ranks = map[Role]int{
+	RoleReadOnly: 1,
+	RoleAgent:    2,
+	RoleAdmin:    3,
+}
+
+// Valid reports whether the role is known.
+func (r Role) Valid() bool {
+	_, ok := ranks[r]
+	return ok
+}
+
+// Allows reports whether the role grants what required grants. An admin
+// can do whatever an agent can, and an agent whatever read-only can.
+func (r Role) Allows(required Role) bool {
+	rank, ok := ranks[r]
+	return ok && rank >= ranks[required]
+}
+
+// Scope is an area of the API an API key can be limited to.
+type Scope string
+
+const (
+	ScopeProperties Scope = "properties"
+	ScopeLeads      Scope = "leads"
+	ScopeWhatsApp   Scope = "whatsapp"
+	ScopeAssistant  Scope = "assistant"
+	ScopeKeys       Scope = "keys"
+)
+
+var scopes = map[Scope]bool{
+	ScopeProperties: true,
+	ScopeLeads:      true,
+	ScopeWhatsApp:   true,
+	ScopeAssistant:  true,
+	ScopeKeys:       true,
+}
+
+// Valid reports whether the scope is known.
+func (s Scope) Valid() bool {
+	return scopes[s]
+}
+
+// Caller is implemented by the auth data of authenticated callers.
+type Caller interface {
+	CallerRole() Role
+	// CallerScopes limits the caller to some scopes.
+	// No scopes at all gives access to every scope.
+	CallerScopes() []Scope
+}
+
+// Require returns an error unless the caller is authenticated with a role
+// that allows required, and has access to the scope. Endpoints call it
+// first thing.
+func Require(scope Scope, required Role) error {
+	caller, ok := auth.Data().(Caller)
+	if !ok {
+		return &errs.Error{
+			Code:    errs.Unauthenticated,
+			Message: "authentication required",
+		}
+	}
+
+	if !caller.CallerRole().Allows(required) {
+		return &errs.Error{
+			Code:    errs.PermissionDenied,
+			Message: fmt.Sprintf("the %s role is required", required),
+		}
+	}
+
+	if !inScope(caller.CallerScopes(), scope) {
+		return &errs.Error{
+			Code:    errs.PermissionDenied,
+			Message: fmt.Sprintf("the %s scope is required", scope),
+		}
+	}
+	return nil
+}
+
+func inScope(granted []Scope, scope Scope) bool {
+	return len(granted) == 0 || slices.Contains(granted, scope)
+}
@@ -0,0 +1,52 @@
+package authz
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoleAllows(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		role     Role
+		required Role
+		want     bool
+	}{
+		"admin as admin":      {RoleAdmin, RoleAdmin, true},
+		"admin as agent":      {RoleAdmin, RoleAgent, true},
+		"admin as read-only":  {RoleAdmin, RoleReadOnly, true},
+		"agent as admin":      {RoleAgent, RoleAdmin, false},
+		"agent as agent":      {RoleAgent, RoleAgent, true},
+		"agent as read-only":  {RoleAgent, RoleReadOnly, true},
+		"read-only as agent":  {RoleReadOnly, RoleAgent, false},
+		"read-only as itself": {RoleReadOnly, RoleReadOnly, true},
+		"unknown role":        {Role("owner"), RoleReadOnly, false},
+		"empty role":          {Role(""), RoleReadOnly, false},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.want, tc.role.Allows(tc.required))
+		})
+	}
+}
+
+func TestRoleValid(t *testing.T) {
+	t.Parallel()
+
+	assert.True(t, RoleAdmin.Valid())
+	assert.True(t, RoleAgent.Valid())
+	assert.True(t, RoleReadOnly.Valid())
+	assert.False(t, Role("owner").Valid())
+}
+
+func TestInScope(t *testing.T) {
+	t.Parallel()
+
+	assert.True(t, inScope(nil, ScopeLeads))
+	assert.True(t, inScope([]Scope{ScopeProperties, ScopeLeads}, ScopeLeads))
+	assert.False(t, inScope([]Scope{ScopeProperties}, ScopeLeads))
+}
@@ -0,0 +1,196 @@
+// Package chatqueue runs jobs on a bounded pool of workers while
+// keeping the jobs of each key (e.g. a chat) in submission order.
+package chatqueue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMaxWorkers = 8
+	defaultMaxPending = 256
+)
+
+var (
+	// ErrQueueFull is returned by Enqueue when too many jobs are pending.
+	ErrQueueFull = errors.New("chatqueue: queue is full")
+	// ErrClosed is returned by Enqueue after Close was called.
+	ErrClosed = errors.New("chatqueue: dispatcher is closed")
+)
+
+// Job is a unit of work submitted to the dispatcher.
+type Job func()
+
+// Observer is notified of the dispatcher activity, e.g. to record metrics.
+type Observer interface {
+	// Enqueued is called after a job is accepted.
+	Enqueued(pending int)
+	// Rejected is called when a job is refused because the queue is full.
+	Rejected()
+	// Completed is called after a job returns or panics.
+	Completed(elapsed time.Duration, pending int)
+	// Panicked is called with the value recovered from a panicking job.
+	Panicked(key string, recovered any)
+}
+
+// Option configures a Dispatcher.
+type Option func(*Dispatcher)
+
+// WithMaxWorkers sets how many jobs, each for a different key, run at once.
+func WithMaxWorkers(n int) Option {
+	return func(d *Dispatcher) {
+		if n > 0 {
+			d.maxWorkers = n
+		}
+	}
+}
+
+// WithMaxPending sets how many jobs may be queued or running across
+// all keys before Enqueue starts rejecting new ones.
+func WithMaxPending(n int) Option {
+	return func(d *Dispatcher) {
+		if n > 0 {
+			d.maxPending = n
+		}
+	}
+}
+
+// WithObserver sets the observer notified of the dispatcher activity.
+func WithObserver(o Observer) Option {
+	return func(d *Dispatcher) {
+		d.observer = o
+	}
+}
+
+// Dispatcher runs jobs concurrently across keys and sequentially within a key.
+type Dispatcher struct {
+	mu         sync.Mutex
+	queues     map[string][]Job
+	pending    int
+	closed     bool
+	maxWorkers int
+	maxPending int
+	workers    chan struct{}
+	wg         sync.WaitGroup
+	observer   Observer
+}
+
+// New creates a new Dispatcher.
+func New(opts ...Option) *Dispatcher {
+	d := Dispatcher{
+		queues:     make(map[string][]Job),
+		maxWorkers: defaultMaxWorkers,
+		maxPending: defaultMaxPending,
+		observer:   nopObserver{},
+	}
+	for _, opt := range opts {
+		opt(&d)
+	}
+	d.workers = make(chan struct{}, d.maxWorkers)
+	return &d
+}
+
+// Enqueue schedules the job after every job previously enqueued for the same key.
+// It never blocks; when the dispatcher is at capacity it returns ErrQueueFull.
+func (d *Dispatcher) Enqueue(key string, job Job) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed {
+		return ErrClosed
+	}
+
+	if d.pending >= d.maxPending {
+		d.observer.Rejected()
+		return ErrQueueFull
+	}
+
+	queue, running := d.queues[key]
+	d.queues[key] = append(queue, job)
+	d.pending++
+	d.observer.Enqueued(d.pending)
+
+	// A key present in the map already has a goroutine draining it.
+	if !running {
+		d.wg.Add(1)
+		go d.drain(key)
+	}
+	return nil
+}
+
+// Pending returns the number of jobs queued or running.
+func (d *Dispatcher) Pending() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.pending
+}
+
+// Close stops accepting jobs and waits for the pending ones to finish
+// or for ctx to be done, whichever happens first.
+func (d *Dispatcher) Close(ctx context.Context) error {
+	d.mu.Lock()
+	d.closed = true
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("chatqueue: %d jobs still pending: %w", d.Pending(), ctx.Err())
+	}
+}
+
+func (d *Dispatcher) drain(key string) {
+	defer d.wg.Done()
+
+	for {
+		d.mu.Lock()
+		queue := d.queues[key]
+		if len(queue) == 0 {
+			delete(d.queues, key)
+			d.mu.Unlock()
+			return
+		}
+		job := queue[0]
+		d.queues[key] = queue[1:]
+		d.mu.Unlock()
+
+		d.workers <- struct{}{}
+		start := time.Now()
+		d.run(key, job)
+		<-d.workers
+
+		d.mu.Lock()
+		d.pending--
+		pending := d.pending
+		d.mu.Unlock()
+
+		d.observer.Completed(time.Since(start), pending)
+	}
+}
+
+func (d *Dispatcher) run(key string, job Job) {
+	defer func() {
+		if r := recover(); r != nil {
+			d.observer.Panicked(key, r)
+		}
+	}()
+	job()
+}
+
+type nopObserver struct{}
+
+func (nopObserver) Enqueued(int)                 {}
+func (nopObserver) Rejected()                    {}
+func (nopObserver) Completed(time.Duration, int) {}
+func (nopObserver) Panicked(string, any)         {}
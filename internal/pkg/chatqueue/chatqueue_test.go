@@ -0,0 +1,120 @@
+package chatqueue
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDispatcher(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Runs jobs of the same key in order", func(t *testing.T) {
+		t.Parallel()
+
+		d := New(WithMaxWorkers(4))
+
+		var (
+			mu  sync.Mutex
+			got []int
+		)
+
+		for i := 0; i < 50; i++ {
+			require.NoError(t, d.Enqueue("chat", func() {
+				time.Sleep(time.Millisecond)
+				mu.Lock()
+				got = append(got, i)
+				mu.Unlock()
+			}))
+		}
+
+		require.NoError(t, d.Close(context.Background()))
+
+		require.Len(t, got, 50)
+		for i := range got {
+			assert.Equal(t, i, got[i])
+		}
+	})
+
+	t.Run("Limits concurrent workers across keys", func(t *testing.T) {
+		t.Parallel()
+
+		d := New(WithMaxWorkers(2))
+
+		var running, maxRunning atomic.Int32
+		for i := 0; i < 10; i++ {
+			require.NoError(t, d.Enqueue(fmt.Sprintf("chat-%d", i), func() {
+				n := running.Add(1)
+				for {
+					m := maxRunning.Load()
+					if n <= m || maxRunning.CompareAndSwap(m, n) {
+						break
+					}
+				}
+				time.Sleep(5 * time.Millisecond)
+				running.Add(-1)
+			}))
+		}
+
+		require.NoError(t, d.Close(context.Background()))
+		assert.Equal(t, int32(2), maxRunning.Load())
+	})
+
+	t.Run("Rejects jobs when full", func(t *testing.T) {
+		t.Parallel()
+
+		obs := &countingObserver{}
+		d := New(WithMaxPending(2), WithObserver(obs))
+
+		release := make(chan struct{})
+		block := func() { <-release }
+
+		require.NoError(t, d.Enqueue("a", block))
+		require.NoError(t, d.Enqueue("b", block))
+		assert.ErrorIs(t, d.Enqueue("c", block), ErrQueueFull)
+		assert.Equal(t, int32(1), obs.rejected.Load())
+
+		close(release)
+		require.NoError(t, d.Close(context.Background()))
+		assert.Equal(t, 0, d.Pending())
+		assert.Equal(t, int32(2), obs.completed.Load())
+	})
+
+	t.Run("Recovers from panicking jobs", func(t *testing.T) {
+		t.Parallel()
+
+		obs := &countingObserver{}
+		d := New(WithObserver(obs))
+
+		var ran atomic.Bool
+		require.NoError(t, d.Enqueue("chat", func() { panic("boom") }))
+		require.NoError(t, d.Enqueue("chat", func() { ran.Store(true) }))
+
+		require.NoError(t, d.Close(context.Background()))
+		assert.True(t, ran.Load())
+		assert.Equal(t, int32(1), obs.panicked.Load())
+	})
+
+	t.Run("Refuses jobs after close", func(t *testing.T) {
+		t.Parallel()
+
+		d := New()
+		require.NoError(t, d.Close(context.Background()))
+		assert.ErrorIs(t, d.Enqueue("chat", func() {}), ErrClosed)
+	})
+}
+
+type countingObserver struct {
+	rejected, completed, panicked atomic.Int32
+}
+
+func (o *countingObserver) Enqueued(int)                 {}
+func (o *countingObserver) Rejected()                    { o.rejected.Add(1) }
+func (o *countingObserver) Completed(time.Duration, int) { o.completed.Add(1) }
+func (o *countingObserver) Panicked(string, any)         { o.panicked.Add(1) }
@@ -0,0 +1,86 @@
+package chatqueue
+
+import (
+	"sync"
+	"time"
+)
+
+// Debouncer groups the items added for a key and hands them over together
+// once no new item arrived for that key during the quiet window.
+type Debouncer[T any] struct {
+	mu      sync.Mutex
+	window  time.Duration
+	flush   func(key string, items []T)
+	batches map[string]*batch[T]
+}
+
+type batch[T any] struct {
+	items []T
+	timer *time.Timer
+	seq   uint64 // identifies the latest timer, so stale ones are ignored
+}
+
+// NewDebouncer creates a Debouncer calling flush with each completed batch.
+// A window of zero or less disables debouncing: every item is flushed on its own.
+func NewDebouncer[T any](window time.Duration, flush func(key string, items []T)) *Debouncer[T] {
+	return &Debouncer[T]{
+		window:  window,
+		flush:   flush,
+		batches: make(map[string]*batch[T]),
+	}
+}
+
+// Add appends the item to the key's batch and restarts its quiet window.
+func (d *Debouncer[T]) Add(key string, item T) {
+	if d.window <= 0 {
+		d.flush(key, []T{item})
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	b, ok := d.batches[key]
+	if !ok {
+		b = &batch[T]{}
+		d.batches[key] = b
+	}
+
+	b.items = append(b.items, item)
+	b.seq++
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+
+	seq := b.seq
+	b.timer = time.AfterFunc(d.window, func() {
+		d.fire(key, seq)
+	})
+}
+
+// Flush hands over every pending batch immediately.
+func (d *Debouncer[T]) Flush() {
+	d.mu.Lock()
+	batches := d.batches
+	d.batches = make(map[string]*batch[T])
+	d.mu.Unlock()
+
+	for key, b := range batches {
+		b.timer.Stop()
+		d.flush(key, b.items)
+	}
+}
+
+func (d *Debouncer[T]) fire(key string, seq uint64) {
+	d.mu.Lock()
+	b, ok := d.batches[key]
+	if !ok || b.seq != seq {
+		// A newer item restarted the window, or the batch was flushed.
+		d.mu.Unlock()
+		return
+	}
+	delete(d.batches, key)
+	d.mu.Unlock()
+
+	d.flush(key, b.items)
+}
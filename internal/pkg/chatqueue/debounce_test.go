@@ -0,0 +1,89 @@
+package chatqueue
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type flushRecorder struct {
+	mu      sync.Mutex
+	batches map[string][][]string
+}
+
+func (r *flushRecorder) flush(key string, items []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.batches[key] = append(r.batches[key], items)
+}
+
+func (r *flushRecorder) get(key string) [][]string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.batches[key]
+}
+
+func TestDebouncer(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Merges items within the quiet window", func(t *testing.T) {
+		t.Parallel()
+
+		rec := &flushRecorder{batches: make(map[string][][]string)}
+		d := NewDebouncer(50*time.Millisecond, rec.flush)
+
+		d.Add("chat", "oi")
+		d.Add("chat", "procuro casa")
+		d.Add("other", "olá")
+		d.Add("chat", "3 quartos")
+
+		require.Eventually(t, func() bool {
+			return len(rec.get("chat")) == 1 && len(rec.get("other")) == 1
+		}, time.Second, 5*time.Millisecond)
+
+		assert.Equal(t, []string{"oi", "procuro casa", "3 quartos"}, rec.get("chat")[0])
+		assert.Equal(t, []string{"olá"}, rec.get("other")[0])
+	})
+
+	t.Run("Starts a new batch after the window", func(t *testing.T) {
+		t.Parallel()
+
+		rec := &flushRecorder{batches: make(map[string][][]string)}
+		d := NewDebouncer(20*time.Millisecond, rec.flush)
+
+		d.Add("chat", "primeira")
+		require.Eventually(t, func() bool { return len(rec.get("chat")) == 1 }, time.Second, 5*time.Millisecond)
+
+		d.Add("chat", "segunda")
+		require.Eventually(t, func() bool { return len(rec.get("chat")) == 2 }, time.Second, 5*time.Millisecond)
+
+		assert.Equal(t, [][]string{{"primeira"}, {"segunda"}}, rec.get("chat"))
+	})
+
+	t.Run("Flushes immediately without a window", func(t *testing.T) {
+		t.Parallel()
+
+		rec := &flushRecorder{batches: make(map[string][][]string)}
+		d := NewDebouncer(0, rec.flush)
+
+		d.Add("chat", "oi")
+		d.Add("chat", "tudo bem?")
+
+		assert.Equal(t, [][]string{{"oi"}, {"tudo bem?"}}, rec.get("chat"))
+	})
+
+	t.Run("Flush hands over pending batches", func(t *testing.T) {
+		t.Parallel()
+
+		rec := &flushRecorder{batches: make(map[string][][]string)}
+		d := NewDebouncer(time.Hour, rec.flush)
+
+		d.Add("chat", "oi")
+		d.Flush()
+
+		assert.Equal(t, [][]string{{"oi"}}, rec.get("chat"))
+	})
+}
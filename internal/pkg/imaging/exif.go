@@ -0,0 +1,173 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+const (
+	jpegMarkerSOI  = 0xD8
+	jpegMarkerSOS  = 0xDA
+	jpegMarkerAPP1 = 0xE1
+
+	tiffTagOrientation = 0x0112
+	tiffTagGPSIFD      = 0x8825
+)
+
+var (
+	exifHeader = []byte("Exif\x00\x00")
+	pngHeader  = []byte("\x89PNG\r\n\x1a\n")
+)
+
+// tiffTypeSizes maps TIFF field types to the size in bytes of one value.
+var tiffTypeSizes = map[uint16]uint32{
+	1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1,
+	7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8,
+}
+
+// jpegExif returns the TIFF payload of the Exif APP1 segment of a JPEG,
+// as a slice of data so it can be edited in place, or nil if there is none.
+func jpegExif(data []byte) []byte {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != jpegMarkerSOI {
+		return nil
+	}
+
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return nil
+		}
+
+		marker := data[i+1]
+		if marker == jpegMarkerSOS {
+			return nil
+		}
+
+		size := int(binary.BigEndian.Uint16(data[i+2:]))
+		if size < 2 || i+2+size > len(data) {
+			return nil
+		}
+
+		segment := data[i+4 : i+2+size]
+		if marker == jpegMarkerAPP1 && bytes.HasPrefix(segment, exifHeader) {
+			return segment[len(exifHeader):]
+		}
+		i += 2 + size
+	}
+	return nil
+}
+
+// tiff walks the first IFD of an Exif TIFF payload.
+type tiff struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+func parseTIFF(data []byte) (*tiff, bool) {
+	if len(data) < 8 {
+		return nil, false
+	}
+
+	var order binary.ByteOrder
+	switch string(data[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, false
+	}
+
+	if order.Uint16(data[2:]) != 42 {
+		return nil, false
+	}
+	return &tiff{data: data, order: order}, true
+}
+
+// entries calls fn with the offset of each 12 byte entry of the IFD at offset.
+func (t *tiff) entries(offset uint32, fn func(entry uint32)) bool {
+	if uint64(offset)+2 > uint64(len(t.data)) {
+		return false
+	}
+
+	count := uint32(t.order.Uint16(t.data[offset:]))
+	if uint64(offset)+2+uint64(count)*12 > uint64(len(t.data)) {
+		return false
+	}
+
+	for i := uint32(0); i < count; i++ {
+		fn(offset + 2 + i*12)
+	}
+	return true
+}
+
+func (t *tiff) firstIFD() uint32 {
+	return t.order.Uint32(t.data[4:])
+}
+
+// orientation returns the EXIF orientation, or 1 when it is not set.
+func (t *tiff) orientation() int {
+	orientation := 1
+	t.entries(t.firstIFD(), func(entry uint32) {
+		if t.order.Uint16(t.data[entry:]) == tiffTagOrientation {
+			if o := int(t.order.Uint16(t.data[entry+8:])); o >= 1 && o <= 8 {
+				orientation = o
+			}
+		}
+	})
+	return orientation
+}
+
+// clearGPS zeroes the GPS IFD and every value it points to,
+// leaving a valid IFD with no entries.
+func (t *tiff) clearGPS() {
+	var gps uint32
+	t.entries(t.firstIFD(), func(entry uint32) {
+		if t.order.Uint16(t.data[entry:]) == tiffTagGPSIFD {
+			gps = t.order.Uint32(t.data[entry+8:])
+		}
+	})
+
+	if gps == 0 {
+		return
+	}
+
+	var count uint32
+	ok := t.entries(gps, func(entry uint32) {
+		count++
+
+		size := tiffTypeSizes[t.order.Uint16(t.data[entry+2:])] * t.order.Uint32(t.data[entry+4:])
+		if size <= 4 {
+			return
+		}
+
+		valueOffset := t.order.Uint32(t.data[entry+8:])
+		if uint64(valueOffset)+uint64(size) <= uint64(len(t.data)) {
+			clear(t.data[valueOffset : valueOffset+size])
+		}
+	})
+
+	if ok {
+		clear(t.data[gps : gps+2+count*12])
+	}
+}
+
+// stripPNGExif returns the PNG without its eXIf chunks.
+func stripPNGExif(data []byte) []byte {
+	out := make([]byte, 0, len(data))
+	out = append(out, pngHeader...)
+
+	for i := len(pngHeader); i+8 <= len(data); {
+		size := int(binary.BigEndian.Uint32(data[i:]))
+		end := i + 12 + size
+		if size < 0 || end > len(data) {
+			// Keep whatever follows a malformed chunk untouched.
+			return append(out, data[i:]...)
+		}
+
+		if string(data[i+4:i+8]) != "eXIf" {
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+	return out
+}
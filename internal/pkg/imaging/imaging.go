@@ -0,0 +1,190 @@
+// Package imaging prepares uploaded images for publishing: it removes
+// location metadata and renders resized JPEG derivatives.
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"net/http"
+
+	// Formats accepted for uploads.
+	_ "image/gif"
+	_ "image/png"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	// DefaultQuality is the JPEG quality used for derivatives and conversions.
+	DefaultQuality = 85
+	// MaxPixels bounds the width times the height of the images decoded,
+	// since a decoded image takes four bytes per pixel.
+	MaxPixels = 50_000_000
+
+	contentTypeJPEG = "image/jpeg"
+	contentTypePNG  = "image/png"
+	contentTypeGIF  = "image/gif"
+)
+
+// ErrUnsupportedFormat is returned for data that is not a decodable image.
+var ErrUnsupportedFormat = errors.New("imaging: unsupported image format")
+
+// ErrTooLarge is returned for images with more than MaxPixels pixels.
+var ErrTooLarge = errors.New("imaging: image is too large")
+
+// Sanitize removes the GPS location from the image metadata and returns the
+// cleaned image with its content type. JPEG and PNG images are cleaned in
+// place, GIF images carry no EXIF, and any other format is converted to JPEG.
+func Sanitize(data []byte) ([]byte, string, error) {
+	switch http.DetectContentType(data) {
+	case contentTypeJPEG:
+		out := bytes.Clone(data)
+		if t, ok := parseTIFF(jpegExif(out)); ok {
+			t.clearGPS()
+		}
+		return out, contentTypeJPEG, nil
+	case contentTypePNG:
+		return stripPNGExif(data), contentTypePNG, nil
+	case contentTypeGIF:
+		return data, contentTypeGIF, nil
+	}
+
+	img, err := decode(data)
+	if err != nil {
+		return nil, "", err
+	}
+
+	out, err := encodeJPEG(img, DefaultQuality)
+	if err != nil {
+		return nil, "", err
+	}
+	return out, contentTypeJPEG, nil
+}
+
+// Derive renders one JPEG per size, each scaled to fit a square of that many
+// pixels. Images are never upscaled, and the EXIF orientation is applied
+// since derivatives carry no metadata.
+func Derive(data []byte, sizes ...int) ([][]byte, error) {
+	img, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	orientation := 1
+	if t, ok := parseTIFF(jpegExif(data)); ok {
+		orientation = t.orientation()
+	}
+
+	out := make([][]byte, 0, len(sizes))
+	for _, size := range sizes {
+		// Fitting a square does not depend on the orientation, so only
+		// the scaled image is rotated.
+		derived, err := encodeJPEG(orient(fit(img, size), orientation), DefaultQuality)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, derived)
+	}
+	return out, nil
+}
+
+// decode checks the dimensions in the image header against MaxPixels
+// before decoding the image.
+func decode(data []byte) (image.Image, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedFormat, err)
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > MaxPixels {
+		return nil, fmt.Errorf("%w: %dx%d pixels", ErrTooLarge, cfg.Width, cfg.Height)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedFormat, err)
+	}
+	return img, nil
+}
+
+func encodeJPEG(img image.Image, quality int) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, fmt.Errorf("could not encode JPEG: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// fit scales img down to fit a size x size square, over a white
+// background since JPEG has no transparency.
+func fit(img image.Image, size int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	if w > size || h > size {
+		if w >= h {
+			w, h = size, max(1, h*size/w)
+		} else {
+			w, h = max(1, w*size/h), size
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Over, nil)
+	return dst
+}
+
+// orient returns img as displayed with the given EXIF orientation.
+func orient(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	// Pixels are copied straight between the RGBA buffers, which is much
+	// faster than going through At and Set.
+	src, ok := img.(*image.RGBA)
+	if !ok {
+		src = image.NewRGBA(b)
+		draw.Draw(src, b, img, b.Min, draw.Src)
+	}
+
+	// Orientations 5 to 8 swap the width and the height.
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			}
+			si, di := src.PixOffset(b.Min.X+x, b.Min.Y+y), dst.PixOffset(dx, dy)
+			copy(dst.Pix[di:di+4], src.Pix[si:si+4])
+		}
+	}
+	return dst
+}
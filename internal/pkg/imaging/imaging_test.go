@@ -0,0 +1,260 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var gpsLatitude = []byte{10, 0, 0, 0, 1, 0, 0, 0, 55, 0, 0, 0, 1, 0, 0, 0, 42, 0, 0, 0, 1, 0, 0, 0}
+
+// exifPayload builds a little endian TIFF with an orientation and a GPS IFD
+// holding a latitude reference and a latitude.
+func exifPayload(orientation uint16) []byte {
+	le := binary.LittleEndian
+	b := make([]byte, 92)
+
+	copy(b, "II")
+	le.PutUint16(b[2:], 42)
+	le.PutUint32(b[4:], 8)
+
+	// IFD0 at 8.
+	le.PutUint16(b[8:], 2)
+	le.PutUint16(b[10:], tiffTagOrientation)
+	le.PutUint16(b[12:], 3)
+	le.PutUint32(b[14:], 1)
+	le.PutUint16(b[18:], orientation)
+	le.PutUint16(b[22:], tiffTagGPSIFD)
+	le.PutUint16(b[24:], 4)
+	le.PutUint32(b[26:], 1)
+	le.PutUint32(b[30:], 38)
+
+	// GPS IFD at 38.
+	le.PutUint16(b[38:], 2)
+	le.PutUint16(b[40:], 0x0001)
+	le.PutUint16(b[42:], 2)
+	le.PutUint32(b[44:], 2)
+	copy(b[48:], "S\x00")
+	le.PutUint16(b[52:], 0x0002)
+	le.PutUint16(b[54:], 5)
+	le.PutUint32(b[56:], 3)
+	le.PutUint32(b[60:], 68)
+	copy(b[68:], gpsLatitude)
+	return b
+}
+
+func testImage(w, h int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), 128, 255})
+		}
+	}
+	return img
+}
+
+func testJPEG(t *testing.T, w, h int, exif []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, testImage(w, h), nil))
+	data := buf.Bytes()
+
+	if exif == nil {
+		return data
+	}
+
+	segment := append(append([]byte{}, exifHeader...), exif...)
+	app1 := []byte{0xFF, jpegMarkerAPP1, 0, 0}
+	binary.BigEndian.PutUint16(app1[2:], uint16(len(segment)+2))
+	app1 = append(app1, segment...)
+
+	out := append([]byte{}, data[:2]...)
+	out = append(out, app1...)
+	return append(out, data[2:]...)
+}
+
+func pngChunk(typ string, data []byte) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	chunk = append(chunk, typ...)
+	chunk = append(chunk, data...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+func TestSanitize(t *testing.T) {
+	t.Parallel()
+
+	t.Run("clears the GPS IFD of a JPEG", func(t *testing.T) {
+		t.Parallel()
+
+		data := testJPEG(t, 16, 8, exifPayload(6))
+
+		out, contentType, err := Sanitize(data)
+		require.NoError(t, err)
+
+		assert.Equal(t, "image/jpeg", contentType)
+		assert.Len(t, out, len(data))
+		assert.True(t, bytes.Contains(data, gpsLatitude))
+		assert.False(t, bytes.Contains(out, gpsLatitude))
+		assert.False(t, bytes.Contains(out, []byte("S\x00\x00\x00")))
+
+		tf, ok := parseTIFF(jpegExif(out))
+		require.True(t, ok)
+		assert.Equal(t, 6, tf.orientation())
+
+		_, err = jpeg.Decode(bytes.NewReader(out))
+		assert.NoError(t, err)
+	})
+
+	t.Run("leaves a JPEG without EXIF untouched", func(t *testing.T) {
+		t.Parallel()
+
+		data := testJPEG(t, 16, 8, nil)
+
+		out, contentType, err := Sanitize(data)
+		require.NoError(t, err)
+
+		assert.Equal(t, "image/jpeg", contentType)
+		assert.Equal(t, data, out)
+	})
+
+	t.Run("drops the eXIf chunk of a PNG", func(t *testing.T) {
+		t.Parallel()
+
+		var buf bytes.Buffer
+		require.NoError(t, png.Encode(&buf, testImage(4, 4)))
+		data := buf.Bytes()
+
+		// Insert the eXIf chunk right after IHDR.
+		ihdrEnd := len(pngHeader) + 12 + 13
+		withExif := append([]byte{}, data[:ihdrEnd]...)
+		withExif = append(withExif, pngChunk("eXIf", exifPayload(1))...)
+		withExif = append(withExif, data[ihdrEnd:]...)
+
+		out, contentType, err := Sanitize(withExif)
+		require.NoError(t, err)
+
+		assert.Equal(t, "image/png", contentType)
+		assert.Equal(t, data, out)
+	})
+
+	t.Run("rejects data that is not an image", func(t *testing.T) {
+		t.Parallel()
+
+		_, _, err := Sanitize([]byte("not an image"))
+		assert.ErrorIs(t, err, ErrUnsupportedFormat)
+	})
+}
+
+func TestDerive(t *testing.T) {
+	t.Parallel()
+
+	t.Run("scales to fit each size without upscaling", func(t *testing.T) {
+		t.Parallel()
+
+		derived, err := Derive(testJPEG(t, 400, 200, nil), 100, 1000)
+		require.NoError(t, err)
+		require.Len(t, derived, 2)
+
+		thumb, err := jpeg.DecodeConfig(bytes.NewReader(derived[0]))
+		require.NoError(t, err)
+		assert.Equal(t, 100, thumb.Width)
+		assert.Equal(t, 50, thumb.Height)
+
+		full, err := jpeg.DecodeConfig(bytes.NewReader(derived[1]))
+		require.NoError(t, err)
+		assert.Equal(t, 400, full.Width)
+		assert.Equal(t, 200, full.Height)
+	})
+
+	t.Run("applies the EXIF orientation", func(t *testing.T) {
+		t.Parallel()
+
+		derived, err := Derive(testJPEG(t, 40, 20, exifPayload(6)), 100)
+		require.NoError(t, err)
+
+		cfg, err := jpeg.DecodeConfig(bytes.NewReader(derived[0]))
+		require.NoError(t, err)
+		assert.Equal(t, 20, cfg.Width)
+		assert.Equal(t, 40, cfg.Height)
+	})
+
+	t.Run("converts PNG to JPEG", func(t *testing.T) {
+		t.Parallel()
+
+		var buf bytes.Buffer
+		require.NoError(t, png.Encode(&buf, testImage(30, 60)))
+
+		derived, err := Derive(buf.Bytes(), 30)
+		require.NoError(t, err)
+
+		cfg, err := jpeg.DecodeConfig(bytes.NewReader(derived[0]))
+		require.NoError(t, err)
+		assert.Equal(t, 15, cfg.Width)
+		assert.Equal(t, 30, cfg.Height)
+	})
+
+	t.Run("rejects data that is not an image", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := Derive([]byte("not an image"), 100)
+		assert.ErrorIs(t, err, ErrUnsupportedFormat)
+	})
+
+	t.Run("rejects images with too many pixels before decoding", func(t *testing.T) {
+		t.Parallel()
+
+		// Only the header is present, so decoding would fail.
+		ihdr := binary.BigEndian.AppendUint32(nil, 10000)
+		ihdr = binary.BigEndian.AppendUint32(ihdr, 10000)
+		ihdr = append(ihdr, 8, 2, 0, 0, 0)
+		data := append(append([]byte{}, pngHeader...), pngChunk("IHDR", ihdr)...)
+
+		_, err := Derive(data, 100)
+		assert.ErrorIs(t, err, ErrTooLarge)
+	})
+}
+
+func TestOrient(t *testing.T) {
+	t.Parallel()
+
+	img := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	img.Set(0, 0, color.RGBA{255, 0, 0, 255})
+	img.Set(1, 0, color.RGBA{0, 0, 255, 255})
+
+	tests := map[int]struct {
+		w, h int
+		red  image.Point
+	}{
+		1: {2, 1, image.Pt(0, 0)},
+		2: {2, 1, image.Pt(1, 0)},
+		3: {2, 1, image.Pt(1, 0)},
+		6: {1, 2, image.Pt(0, 0)},
+		8: {1, 2, image.Pt(0, 1)},
+	}
+
+	// A paletted image checks the conversion of images that are not RGBA.
+	paletted := image.NewPaletted(image.Rect(0, 0, 2, 1), color.Palette{color.RGBA{255, 0, 0, 255}, color.RGBA{0, 0, 255, 255}})
+	paletted.SetColorIndex(1, 0, 1)
+
+	for orientation, tc := range tests {
+		out := orient(img, orientation)
+
+		assert.Equal(t, tc.w, out.Bounds().Dx(), "orientation %d", orientation)
+		assert.Equal(t, tc.h, out.Bounds().Dy(), "orientation %d", orientation)
+
+		r, _, _, _ := out.At(tc.red.X, tc.red.Y).RGBA()
+		assert.Equal(t, uint32(0xffff), r, "orientation %d", orientation)
+
+		r, _, _, _ = orient(paletted, orientation).At(tc.red.X, tc.red.Y).RGBA()
+		assert.Equal(t, uint32(0xffff), r, "paletted orientation %d", orientation)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 
 	"encore.app/internal/pkg/httpclient"
 )
@@ -48,3 +49,148 @@ func (c *Client) CreateAssistant(ctx context.Context, in *CreateAssistantInput)
 	}
 	return &assistant, nil
 }
+
+// ModifyAssistant updates an existing assistant in place.
+func (c *Client) ModifyAssistant(ctx context.Context, assistantID string, in *ModifyAssistantInput) (*Assistant, error) {
+	jsonData, err := json.Marshal(in)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal assistant config: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodPost,
+		fmt.Sprintf("%s/assistants/%s", c.baseURL, assistantID),
+		bytes.NewBuffer(jsonData),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	req.Header.Set("OpenAI-Beta", "assistants=v2")
+
+	resp, err := httpclient.DoWithRetry(c.httpClient, req)
+	if err != nil {
+		return nil, fmt.Errorf("could not send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("unexpected status code '%d', response: '%s'", resp.StatusCode, string(b))
+	}
+
+	var assistant Assistant
+	if err := json.NewDecoder(resp.Body).Decode(&assistant); err != nil {
+		return nil, fmt.Errorf("could not decode response: %w", err)
+	}
+	return &assistant, nil
+}
+
+// GetAssistant retrieves an assistant by ID.
+// It returns ErrNotFound if the assistant does not exist.
+func (c *Client) GetAssistant(ctx context.Context, assistantID string) (*Assistant, error) {
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodGet,
+		fmt.Sprintf("%s/assistants/%s", c.baseURL, assistantID),
+		nil,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not create request: %w", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	req.Header.Set("OpenAI-Beta", "assistants=v2")
+
+	resp, err := httpclient.DoWithRetry(c.httpClient, req)
+	if err != nil {
+		return nil, fmt.Errorf("could not send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("unexpected status code '%d', response: '%s'", resp.StatusCode, string(b))
+	}
+
+	var assistant Assistant
+	if err := json.NewDecoder(resp.Body).Decode(&assistant); err != nil {
+		return nil, fmt.Errorf("could not decode response: %w", err)
+	}
+	return &assistant, nil
+}
+
+// ListAssistants returns a page of assistants, newest first. Pass the
+// LastID of the previous page as after to fetch the next one.
+func (c *Client) ListAssistants(ctx context.Context, after string) (*AssistantList, error) {
+	q := url.Values{"limit": {"100"}}
+	if after != "" {
+		q.Set("after", after)
+	}
+
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodGet,
+		c.baseURL+"/assistants?"+q.Encode(),
+		nil,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not create request: %w", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	req.Header.Set("OpenAI-Beta", "assistants=v2")
+
+	resp, err := httpclient.DoWithRetry(c.httpClient, req)
+	if err != nil {
+		return nil, fmt.Errorf("could not send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("unexpected status code '%d', response: '%s'", resp.StatusCode, string(b))
+	}
+
+	var list AssistantList
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("could not decode response: %w", err)
+	}
+	return &list, nil
+}
+
+// DeleteAssistant deletes an assistant. Deleting an assistant
+// that no longer exists is not an error.
+func (c *Client) DeleteAssistant(ctx context.Context, assistantID string) error {
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodDelete,
+		fmt.Sprintf("%s/assistants/%s", c.baseURL, assistantID),
+		nil,
+	)
+	if err != nil {
+		return fmt.Errorf("could not create request: %w", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	req.Header.Set("OpenAI-Beta", "assistants=v2")
+
+	resp, err := httpclient.DoWithRetry(c.httpClient, req)
+	if err != nil {
+		return fmt.Errorf("could not send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		b, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("unexpected status code '%d', response: '%s'", resp.StatusCode, string(b))
+	}
+	return nil
+}
@@ -54,6 +54,16 @@ type (
 		Metadata     Meta     `json:"metadata,omitempty"`
 	}
 
+	ModifyAssistantInput struct {
+		Metadata      Meta           `json:"metadata,omitempty"`
+		Name          string         `json:"name,omitempty"`
+		Description   string         `json:"description,omitempty"`
+		Model         Model          `json:"model,omitempty"`
+		Instructions  string         `json:"instructions,omitempty"`
+		Tools         []Tool         `json:"tools,omitempty"`
+		ToolResources *ToolResources `json:"tool_resources,omitempty"`
+	}
+
 	Tool struct {
 		Type     string              `json:"type"`
 		Function *FunctionDefinition `json:"function,omitempty"`
@@ -81,6 +91,7 @@ type (
 	// Vector Store
 
 	CreateVectorStoreInput struct {
+		Metadata    Meta     `json:"metadata,omitempty"`
 		Name        string   `json:"name"`
 		Description string   `json:"description,omitempty"`
 		FileIDs     []string `json:"file_ids"`
@@ -106,6 +117,14 @@ type (
 		LastActiveAt int64                  `json:"last_active_at"`
 	}
 
+	VectorStoreFile struct {
+		ID            string `json:"id"`
+		Object        string `json:"object"`
+		Status        string `json:"status"`
+		VectorStoreID string `json:"vector_store_id"`
+		CreatedAt     int64  `json:"created_at"`
+	}
+
 	// WhisperAI
 
 	TranscribeAudioInput struct {
@@ -168,6 +187,7 @@ type (
 		ID        string `json:"id"`
 		Object    string `json:"object"`
 		Purpose   string `json:"purpose"`
+		Filename  string `json:"filename"`
 		CreatedAt int64  `json:"created_at"`
 	}
 
@@ -175,9 +195,33 @@ type (
 		ID        string `json:"id"`
 		Object    string `json:"object"`
 		Purpose   string `json:"purpose"`
+		Filename  string `json:"filename"`
+		Bytes     int64  `json:"bytes"`
 		CreatedAt int64  `json:"created_at"`
 	}
 
+	FileList struct {
+		Object  string        `json:"object"`
+		Data    []FileDetails `json:"data"`
+		HasMore bool          `json:"has_more"`
+	}
+
+	AssistantList struct {
+		Object  string      `json:"object"`
+		Data    []Assistant `json:"data"`
+		FirstID string      `json:"first_id"`
+		LastID  string      `json:"last_id"`
+		HasMore bool        `json:"has_more"`
+	}
+
+	VectorStoreList struct {
+		Object  string        `json:"object"`
+		Data    []VectorStore `json:"data"`
+		FirstID string        `json:"first_id"`
+		LastID  string        `json:"last_id"`
+		HasMore bool          `json:"has_more"`
+	}
+
 	Thread struct {
 		ID        string `json:"id"`
 		Object    string `json:"object"`
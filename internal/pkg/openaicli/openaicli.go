@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -14,6 +15,9 @@ import (
 	"encore.app/internal/pkg/httpclient"
 )
 
+// ErrNotFound is returned when the requested resource does not exist.
+var ErrNotFound = errors.New("openai: resource not found")
+
 // Client represents an OpenAI API client
 type Client struct {
 	apiKey     string
@@ -45,7 +49,7 @@ func New(apiKey string, httpClient *http.Client, opts ...ClientOption) *Client {
 }
 
 // ListFiles retrieves a list of files that have been uploaded
-func (c *Client) ListFiles(ctx context.Context) (*ListResponse, error) {
+func (c *Client) ListFiles(ctx context.Context) (*FileList, error) {
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/files", nil)
 	if err != nil {
 		return nil, fmt.Errorf("error creating request: %w", err)
@@ -64,7 +68,7 @@ func (c *Client) ListFiles(ctx context.Context) (*ListResponse, error) {
 		return nil, fmt.Errorf("API error (status %d): %s", resp.StatusCode, body)
 	}
 
-	var fileList ListResponse
+	var fileList FileList
 	if err := json.NewDecoder(resp.Body).Decode(&fileList); err != nil {
 		return nil, fmt.Errorf("error decoding response: %w", err)
 	}
@@ -73,11 +77,15 @@ func (c *Client) ListFiles(ctx context.Context) (*ListResponse, error) {
 
 // UploadFile uploads a file to OpenAI with enhanced logging
 func (c *Client) UploadFile(ctx context.Context, data io.Reader, purpose string) (*FileUploadResponse, error) {
+	return c.UploadNamedFile(ctx, fmt.Sprintf("data_%d.json", time.Now().UnixNano()), data, purpose)
+}
+
+// UploadNamedFile uploads a file to OpenAI under the given filename.
+// Since files carry no metadata, the filename is how callers tag them.
+func (c *Client) UploadNamedFile(ctx context.Context, filename string, data io.Reader, purpose string) (*FileUploadResponse, error) {
 	var body bytes.Buffer
 	writer := multipart.NewWriter(&body)
 
-	filename := fmt.Sprintf("data_%d.json", time.Now().UnixNano())
-
 	part, err := writer.CreateFormFile("file", filename)
 	if err != nil {
 		return nil, fmt.Errorf("error creating form file: %w", err)
@@ -122,6 +130,34 @@ func (c *Client) UploadFile(ctx context.Context, data io.Reader, purpose string)
 	return &uploadResp, nil
 }
 
+// DeleteFile deletes an uploaded file. Deleting a file
+// that no longer exists is not an error.
+func (c *Client) DeleteFile(ctx context.Context, fileID string) error {
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodDelete,
+		fmt.Sprintf("%s/files/%s", c.baseURL, fileID),
+		nil,
+	)
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+c.apiKey)
+
+	resp, err := httpclient.DoWithRetry(c.httpClient, req)
+	if err != nil {
+		return fmt.Errorf("error sending request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("API error (status %d): %s", resp.StatusCode, body)
+	}
+	return nil
+}
+
 func (c *Client) GetFileContent(ctx context.Context, fileID string) ([]byte, error) {
 	req, err := http.NewRequestWithContext(
 		ctx,
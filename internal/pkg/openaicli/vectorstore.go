@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"time"
 
 	"encore.app/internal/pkg/httpclient"
@@ -94,3 +95,133 @@ func (c *Client) WaitForVectorStoreCompletion(ctx context.Context, vectorStoreID
 		time.Sleep(delay)
 	}
 }
+
+// AddVectorStoreFile attaches an uploaded file to a vector store.
+func (c *Client) AddVectorStoreFile(ctx context.Context, vectorStoreID, fileID string) (*VectorStoreFile, error) {
+	body, err := json.Marshal(struct {
+		FileID string `json:"file_id"`
+	}{
+		FileID: fileID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request body: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodPost,
+		fmt.Sprintf("%s/vector_stores/%s/files", c.baseURL, vectorStoreID),
+		bytes.NewBuffer(body),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	req.Header.Set("OpenAI-Beta", "assistants=v2")
+
+	resp, err := httpclient.DoWithRetry(c.httpClient, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("failed to add vector store file, status: '%s', body: '%s'", resp.Status, b)
+	}
+
+	var out VectorStoreFile
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return &out, nil
+}
+
+// RemoveVectorStoreFile detaches a file from a vector store.
+// The file itself is not deleted.
+func (c *Client) RemoveVectorStoreFile(ctx context.Context, vectorStoreID, fileID string) error {
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodDelete,
+		fmt.Sprintf("%s/vector_stores/%s/files/%s", c.baseURL, vectorStoreID, fileID),
+		nil,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	req.Header.Set("OpenAI-Beta", "assistants=v2")
+
+	resp, err := httpclient.DoWithRetry(c.httpClient, req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		b, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("failed to remove vector store file, status: '%s', body: '%s'", resp.Status, b)
+	}
+	return nil
+}
+
+// ListVectorStores returns a page of vector stores, newest first. Pass the
+// LastID of the previous page as after to fetch the next one.
+func (c *Client) ListVectorStores(ctx context.Context, after string) (*VectorStoreList, error) {
+	q := url.Values{"limit": {"100"}}
+	if after != "" {
+		q.Set("after", after)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/vector_stores?"+q.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	req.Header.Set("OpenAI-Beta", "assistants=v2")
+
+	resp, err := httpclient.DoWithRetry(c.httpClient, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("failed to list vector stores, status: '%s', body: '%s'", resp.Status, b)
+	}
+
+	var out VectorStoreList
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return &out, nil
+}
+
+// DeleteVectorStore deletes a vector store. The files it references are
+// not deleted. Deleting a vector store that no longer exists is not an error.
+func (c *Client) DeleteVectorStore(ctx context.Context, vectorStoreID string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, c.baseURL+"/vector_stores/"+vectorStoreID, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	req.Header.Set("OpenAI-Beta", "assistants=v2")
+
+	resp, err := httpclient.DoWithRetry(c.httpClient, req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		b, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("failed to delete vector store, status: '%s', body: '%s'", resp.Status, b)
+	}
+	return nil
+}
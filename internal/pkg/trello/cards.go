@@ -0,0 +1,106 @@
+package trello
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// CreateCard creates a card and returns it.
+func (c *Client) CreateCard(ctx context.Context, card NewCard) (*Card, error) {
+	var created Card
+	if err := c.do(ctx, http.MethodPost, "/cards", card, &created); err != nil {
+		return nil, fmt.Errorf("could not create card: %w", err)
+	}
+	return &created, nil
+}
+
+// GetCard returns the card with its attachments.
+func (c *Client) GetCard(ctx context.Context, id string) (*Card, error) {
+	var card Card
+	if err := c.do(ctx, http.MethodGet, "/cards/"+url.PathEscape(id)+"?attachments=true", nil, &card); err != nil {
+		return nil, fmt.Errorf("could not get card: %w", err)
+	}
+	return &card, nil
+}
+
+// UpdateCard applies a partial update to the card and returns it.
+func (c *Client) UpdateCard(ctx context.Context, id string, update CardUpdate) (*Card, error) {
+	var card Card
+	if err := c.do(ctx, http.MethodPut, "/cards/"+url.PathEscape(id), update, &card); err != nil {
+		return nil, fmt.Errorf("could not update card: %w", err)
+	}
+	return &card, nil
+}
+
+// MoveCard moves the card to another list.
+func (c *Client) MoveCard(ctx context.Context, id, listID string) error {
+	if _, err := c.UpdateCard(ctx, id, CardUpdate{ListID: &listID}); err != nil {
+		return fmt.Errorf("could not move card: %w", err)
+	}
+	return nil
+}
+
+// AddLabel adds an existing board label to the card.
+func (c *Client) AddLabel(ctx context.Context, cardID, labelID string) error {
+	if err := c.do(ctx, http.MethodPost, "/cards/"+url.PathEscape(cardID)+"/idLabels", map[string]string{
+		"value": labelID,
+	}, nil); err != nil {
+		return fmt.Errorf("could not add label: %w", err)
+	}
+	return nil
+}
+
+// RemoveLabel removes a label from the card.
+func (c *Client) RemoveLabel(ctx context.Context, cardID, labelID string) error {
+	if err := c.do(ctx, http.MethodDelete, "/cards/"+url.PathEscape(cardID)+"/idLabels/"+url.PathEscape(labelID), nil, nil); err != nil {
+		return fmt.Errorf("could not remove label: %w", err)
+	}
+	return nil
+}
+
+// AddComment leaves a comment on the card.
+func (c *Client) AddComment(ctx context.Context, cardID, text string) (*Comment, error) {
+	var comment Comment
+	if err := c.do(ctx, http.MethodPost, "/cards/"+url.PathEscape(cardID)+"/actions/comments", map[string]string{
+		"text": text,
+	}, &comment); err != nil {
+		return nil, fmt.Errorf("could not add comment: %w", err)
+	}
+	return &comment, nil
+}
+
+// AddChecklist creates a checklist with the given items on the card.
+func (c *Client) AddChecklist(ctx context.Context, cardID, name string, items []string) (*Checklist, error) {
+	var checklist Checklist
+	if err := c.do(ctx, http.MethodPost, "/checklists", map[string]string{
+		"idCard": cardID,
+		"name":   name,
+	}, &checklist); err != nil {
+		return nil, fmt.Errorf("could not create checklist: %w", err)
+	}
+
+	for _, item := range items {
+		var checkItem CheckItem
+		if err := c.do(ctx, http.MethodPost, "/checklists/"+url.PathEscape(checklist.ID)+"/checkItems", map[string]string{
+			"name": item,
+		}, &checkItem); err != nil {
+			return nil, fmt.Errorf("could not add checklist item: %w", err)
+		}
+		checklist.CheckItems = append(checklist.CheckItems, &checkItem)
+	}
+	return &checklist, nil
+}
+
+// AttachLink attaches a link to the card.
+func (c *Client) AttachLink(ctx context.Context, cardID, name, link string) (*Attachment, error) {
+	var attachment Attachment
+	if err := c.do(ctx, http.MethodPost, "/cards/"+url.PathEscape(cardID)+"/attachments", map[string]string{
+		"name": name,
+		"url":  link,
+	}, &attachment); err != nil {
+		return nil, fmt.Errorf("could not attach link: %w", err)
+	}
+	return &attachment, nil
+}
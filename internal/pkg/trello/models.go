@@ -0,0 +1,101 @@
+package trello
+
+import "time"
+
+// Card is a Trello card.
+type Card struct {
+	ID          string        `json:"id"`
+	Name        string        `json:"name"`
+	Description string        `json:"desc"`
+	ListID      string        `json:"idList"`
+	LabelIDs    []string      `json:"idLabels"`
+	Closed      bool          `json:"closed"`
+	URL         string        `json:"url"`
+	Attachments []*Attachment `json:"attachments,omitempty"`
+}
+
+// NewCard holds the fields of a card to create.
+type NewCard struct {
+	Name        string   `json:"name"`
+	Description string   `json:"desc"`
+	ListID      string   `json:"idList"`
+	LabelIDs    []string `json:"idLabels,omitempty"`
+}
+
+// CardUpdate is a partial update of a card. Only non-nil fields are sent.
+type CardUpdate struct {
+	Name        *string `json:"name,omitempty"`
+	Description *string `json:"desc,omitempty"`
+	ListID      *string `json:"idList,omitempty"`
+	Closed      *bool   `json:"closed,omitempty"`
+}
+
+// Comment is a comment left on a card.
+type Comment struct {
+	ID string `json:"id"`
+}
+
+// Checklist is a checklist of a card.
+type Checklist struct {
+	ID         string       `json:"id"`
+	Name       string       `json:"name"`
+	CardID     string       `json:"idCard"`
+	CheckItems []*CheckItem `json:"checkItems"`
+}
+
+// CheckItem is an item of a checklist. State is "complete" or "incomplete".
+type CheckItem struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	State string `json:"state"`
+}
+
+// Attachment is a file or link attached to a card.
+type Attachment struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// Webhook is a registered Trello webhook.
+type Webhook struct {
+	ID          string `json:"id"`
+	Description string `json:"description"`
+	ModelID     string `json:"idModel"`
+	CallbackURL string `json:"callbackURL"`
+	Active      bool   `json:"active"`
+}
+
+// WebhookEvent is the body of a webhook callback.
+type WebhookEvent struct {
+	Action Action `json:"action"`
+}
+
+// Action is a change made on Trello, such as "updateCard".
+type Action struct {
+	ID            string     `json:"id"`
+	Type          string     `json:"type"`
+	Date          time.Time  `json:"date"`
+	Data          ActionData `json:"data"`
+	MemberCreator Member     `json:"memberCreator"`
+}
+
+// ActionData holds the objects affected by an action. ListBefore and
+// ListAfter are only set when a card moves to another list.
+type ActionData struct {
+	Card       *ActionRef `json:"card,omitempty"`
+	ListBefore *ActionRef `json:"listBefore,omitempty"`
+	ListAfter  *ActionRef `json:"listAfter,omitempty"`
+}
+
+// ActionRef identifies a card or list in an action.
+type ActionRef struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// Member is a Trello user.
+type Member struct {
+	ID       string `json:"id"`
+	FullName string `json:"fullName"`
+}
@@ -1,50 +1,177 @@
+// Package trello is a client for the Trello REST API.
 package trello
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 )
 
-type TrelloCard struct {
-	Name        string `json:"name"`
-	Description string `json:"desc"`
-	ListID      string `json:"idList"`
+const (
+	defaultBaseURL     = "https://api.trello.com/1"
+	defaultMaxRetries  = 3
+	baseRateLimitDelay = time.Second
+	maxRateLimitDelay  = 10 * time.Second
+)
+
+var (
+	// ErrNotFound is returned when the card, list or board does not exist.
+	ErrNotFound = errors.New("trello: resource not found")
+	// ErrUnauthorized is returned when the API key or token is invalid,
+	// or cannot access the resource.
+	ErrUnauthorized = errors.New("trello: unauthorized")
+	// ErrRateLimited is returned when requests are still rate limited
+	// after every retry.
+	ErrRateLimited = errors.New("trello: rate limited")
+)
+
+// APIError is an error response of the Trello API. It matches
+// ErrNotFound, ErrUnauthorized and ErrRateLimited with errors.Is.
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("trello: API error (status %d): %s", e.StatusCode, e.Message)
+}
+
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	}
+	return false
 }
 
-type TrelloAPI struct {
-	APIKey  string
-	Token   string
-	BaseURL string
+// Client is a Trello API client.
+type Client struct {
+	apiKey     string
+	token      string
+	httpClient *http.Client
+	baseURL    string
+	maxRetries int
 }
 
-func NewTrelloAPI(apiKey, token string) *TrelloAPI {
-	return &TrelloAPI{
-		APIKey:  apiKey,
-		Token:   token,
-		BaseURL: "https://api.trello.com/1",
+// ClientOption allows configuring the client.
+type ClientOption func(*Client)
+
+// WithBaseURL sets a custom base URL for the client.
+func WithBaseURL(url string) ClientOption {
+	return func(c *Client) {
+		c.baseURL = url
 	}
 }
 
-func (t *TrelloAPI) CreateCard(card TrelloCard) error {
-	url := fmt.Sprintf("%s/cards?idList=%s&key=%s&token=%s", t.BaseURL, card.ListID, t.APIKey, t.Token)
+// WithMaxRetries sets how many times a rate limited request is retried.
+func WithMaxRetries(n int) ClientOption {
+	return func(c *Client) {
+		c.maxRetries = n
+	}
+}
 
-	payload, err := json.Marshal(card)
-	if err != nil {
-		return fmt.Errorf("error marshaling card data: %v", err)
+// New creates a new Trello client.
+func New(apiKey, token string, httpClient *http.Client, opts ...ClientOption) *Client {
+	c := Client{
+		apiKey:     apiKey,
+		token:      token,
+		httpClient: httpClient,
+		baseURL:    defaultBaseURL,
+		maxRetries: defaultMaxRetries,
 	}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return &c
+}
 
-	resp, err := http.Post(url, "application/json", bytes.NewBuffer(payload))
-	if err != nil {
-		return fmt.Errorf("error making POST request: %v", err)
+// do sends a request with the JSON encoding of in as its body, if any,
+// and decodes the response into out, if any. Rate limited requests are
+// retried after the delay asked by Trello.
+func (c *Client) do(ctx context.Context, method, path string, in, out any) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return fmt.Errorf("error marshaling request: %w", err)
+		}
 	}
+
+	for attempt := 0; ; attempt++ {
+		var reqBody io.Reader
+		if body != nil {
+			reqBody = bytes.NewReader(body)
+		}
+
+		req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reqBody)
+		if err != nil {
+			return fmt.Errorf("error creating request: %w", err)
+		}
+
+		// Credentials go in a header rather than the query string,
+		// so they do not end up in logged URLs.
+		req.Header.Set("Authorization", fmt.Sprintf(`OAuth oauth_consumer_key="%s", oauth_token="%s"`, c.apiKey, c.token))
+		req.Header.Set("Accept", "application/json")
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("error sending request: %w", err)
+		}
+
+		if resp.StatusCode == http.StatusTooManyRequests && attempt < c.maxRetries {
+			delay := retryAfter(resp, attempt)
+			resp.Body.Close()
+
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("request cancelled while rate limited: %w", ctx.Err())
+			case <-time.After(delay):
+				continue
+			}
+		}
+		return decodeResponse(resp, out)
+	}
+}
+
+func decodeResponse(resp *http.Response, out any) error {
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return &APIError{StatusCode: resp.StatusCode, Message: string(msg)}
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("error decoding response: %w", err)
 	}
 	return nil
 }
+
+// retryAfter is the delay asked by the Retry-After header, or an
+// exponential backoff when there is none.
+func retryAfter(resp *http.Response, attempt int) time.Duration {
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds >= 0 {
+		return min(time.Duration(seconds)*time.Second, maxRateLimitDelay)
+	}
+
+	delay := time.Duration(float64(baseRateLimitDelay) * math.Pow(2, float64(attempt)))
+	return min(delay, maxRateLimitDelay)
+}
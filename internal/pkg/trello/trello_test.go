@@ -0,0 +1,212 @@
+package trello
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestClient(t *testing.T, handler http.Handler, opts ...ClientOption) *Client {
+	t.Helper()
+
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	return New("key", "token", srv.Client(), append([]ClientOption{WithBaseURL(srv.URL)}, opts...)...)
+}
+
+func TestClient(t *testing.T) {
+	t.Parallel()
+
+	t.Run("sends credentials in the Authorization header", func(t *testing.T) {
+		t.Parallel()
+
+		var auth, query string
+		client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			auth = r.Header.Get("Authorization")
+			query = r.URL.RawQuery
+			io.WriteString(w, `{"id":"card-1","name":"Maria","idList":"list-1"}`)
+		}))
+
+		card, err := client.CreateCard(context.Background(), NewCard{Name: "Maria", ListID: "list-1"})
+		require.NoError(t, err)
+
+		assert.Equal(t, "card-1", card.ID)
+		assert.Equal(t, `OAuth oauth_consumer_key="key", oauth_token="token"`, auth)
+		assert.Empty(t, query)
+	})
+
+	t.Run("maps error responses to typed errors", func(t *testing.T) {
+		t.Parallel()
+
+		tests := map[int]error{
+			http.StatusNotFound:     ErrNotFound,
+			http.StatusUnauthorized: ErrUnauthorized,
+			http.StatusForbidden:    ErrUnauthorized,
+		}
+
+		for status, want := range tests {
+			client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "nope", status)
+			}))
+
+			_, err := client.GetCard(context.Background(), "card-1")
+			assert.ErrorIs(t, err, want)
+
+			var apiErr *APIError
+			require.ErrorAs(t, err, &apiErr)
+			assert.Equal(t, status, apiErr.StatusCode)
+		}
+	})
+
+	t.Run("retries rate limited requests", func(t *testing.T) {
+		t.Parallel()
+
+		var calls atomic.Int32
+		client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			assert.JSONEq(t, `{"text":"Visita agendada"}`, string(body))
+
+			if calls.Add(1) < 3 {
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			io.WriteString(w, `{"id":"comment-1"}`)
+		}))
+
+		comment, err := client.AddComment(context.Background(), "card-1", "Visita agendada")
+		require.NoError(t, err)
+		assert.Equal(t, "comment-1", comment.ID)
+		assert.Equal(t, int32(3), calls.Load())
+	})
+
+	t.Run("gives up when still rate limited", func(t *testing.T) {
+		t.Parallel()
+
+		var calls atomic.Int32
+		client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}), WithMaxRetries(2))
+
+		err := client.MoveCard(context.Background(), "card-1", "list-2")
+		assert.ErrorIs(t, err, ErrRateLimited)
+		assert.Equal(t, int32(3), calls.Load())
+	})
+
+	t.Run("stops waiting when the context is done", func(t *testing.T) {
+		t.Parallel()
+
+		client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Retry-After", "10")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		err := client.AddLabel(ctx, "card-1", "label-1")
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run("UpdateCard only sends the set fields", func(t *testing.T) {
+		t.Parallel()
+
+		var body map[string]any
+		client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, http.MethodPut, r.Method)
+			assert.Equal(t, "/cards/card-1", r.URL.Path)
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			io.WriteString(w, `{"id":"card-1"}`)
+		}))
+
+		name := "Maria Silva"
+		_, err := client.UpdateCard(context.Background(), "card-1", CardUpdate{Name: &name})
+		require.NoError(t, err)
+		assert.Equal(t, map[string]any{"name": "Maria Silva"}, body)
+	})
+
+	t.Run("AddChecklist creates the checklist and its items", func(t *testing.T) {
+		t.Parallel()
+
+		mux := http.NewServeMux()
+		mux.HandleFunc("POST /checklists", func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, `{"id":"checklist-1","name":"Documentos","idCard":"card-1"}`)
+		})
+		mux.HandleFunc("POST /checklists/checklist-1/checkItems", func(w http.ResponseWriter, r *http.Request) {
+			var item map[string]string
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&item))
+			json.NewEncoder(w).Encode(CheckItem{ID: "item-" + item["name"], Name: item["name"], State: "incomplete"})
+		})
+
+		client := newTestClient(t, mux)
+
+		checklist, err := client.AddChecklist(context.Background(), "card-1", "Documentos", []string{"RG", "CPF"})
+		require.NoError(t, err)
+		assert.Equal(t, "checklist-1", checklist.ID)
+		require.Len(t, checklist.CheckItems, 2)
+		assert.Equal(t, "item-CPF", checklist.CheckItems[1].ID)
+	})
+
+	t.Run("AttachLink", func(t *testing.T) {
+		t.Parallel()
+
+		client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "/cards/card-1/attachments", r.URL.Path)
+			io.WriteString(w, `{"id":"attachment-1","name":"Imóvel AP001","url":"https://imolink.test/properties/AP001"}`)
+		}))
+
+		attachment, err := client.AttachLink(context.Background(), "card-1", "Imóvel AP001", "https://imolink.test/properties/AP001")
+		require.NoError(t, err)
+		assert.Equal(t, "attachment-1", attachment.ID)
+	})
+}
+
+func TestRetryAfter(t *testing.T) {
+	t.Parallel()
+
+	withHeader := func(v string) *http.Response {
+		resp := &http.Response{Header: http.Header{}}
+		if v != "" {
+			resp.Header.Set("Retry-After", v)
+		}
+		return resp
+	}
+
+	assert.Equal(t, 3*time.Second, retryAfter(withHeader("3"), 0))
+	assert.Equal(t, maxRateLimitDelay, retryAfter(withHeader("120"), 0))
+	assert.Equal(t, time.Second, retryAfter(withHeader(""), 0))
+	assert.Equal(t, 4*time.Second, retryAfter(withHeader(""), 2))
+	assert.Equal(t, maxRateLimitDelay, retryAfter(withHeader(""), 10))
+}
+
+func TestVerifyWebhook(t *testing.T) {
+	t.Parallel()
+
+	body := []byte(`{"action":{"type":"updateCard"}}`)
+	callbackURL := "https://imolink.test/leads/trello/webhook/trello"
+
+	// Computed as Trello documents it: base64(HMAC-SHA1(secret, body + callbackURL)).
+	mac := hmac.New(sha1.New, []byte("secret"))
+	mac.Write(append(body, callbackURL...))
+	signature := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	assert.True(t, VerifyWebhook("secret", body, callbackURL, signature))
+	assert.False(t, VerifyWebhook("other", body, callbackURL, signature))
+	assert.False(t, VerifyWebhook("secret", body, "https://evil.test/", signature))
+	assert.False(t, VerifyWebhook("secret", []byte(`{}`), callbackURL, signature))
+	assert.False(t, VerifyWebhook("secret", body, callbackURL, ""))
+}
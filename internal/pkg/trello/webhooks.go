@@ -0,0 +1,49 @@
+package trello
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// SignatureHeader is the header holding the signature of webhook callbacks.
+const SignatureHeader = "X-Trello-Webhook"
+
+// CreateWebhook registers a webhook calling callbackURL on every change
+// of the model, such as a board. Trello checks the callback URL with a
+// HEAD request, which must succeed for the webhook to be created.
+func (c *Client) CreateWebhook(ctx context.Context, callbackURL, modelID, description string) (*Webhook, error) {
+	var webhook Webhook
+	if err := c.do(ctx, http.MethodPost, "/webhooks", map[string]string{
+		"callbackURL": callbackURL,
+		"idModel":     modelID,
+		"description": description,
+	}, &webhook); err != nil {
+		return nil, fmt.Errorf("could not create webhook: %w", err)
+	}
+	return &webhook, nil
+}
+
+// ListWebhooks returns the webhooks registered with the client token.
+func (c *Client) ListWebhooks(ctx context.Context) ([]*Webhook, error) {
+	var webhooks []*Webhook
+	if err := c.do(ctx, http.MethodGet, "/tokens/"+url.PathEscape(c.token)+"/webhooks", nil, &webhooks); err != nil {
+		return nil, fmt.Errorf("could not list webhooks: %w", err)
+	}
+	return webhooks, nil
+}
+
+// VerifyWebhook reports whether signature is the signature Trello computes
+// for a callback: the base64 HMAC-SHA1 of the body followed by the callback
+// URL the webhook was registered with, keyed with the API secret.
+func VerifyWebhook(secret string, body []byte, callbackURL, signature string) bool {
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write(body)
+	mac.Write([]byte(callbackURL))
+	expected := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
@@ -0,0 +1,15 @@
+#Sink: {
+	Name:              string
+	Type:              "trello" | "webhook" | "file"
+	TrelloBoardID:     string | *""
+	TrelloListID:      string | *""
+	TrelloStatusLists: {[string]: string}
+	WebhookURL:        string | *""
+	FilePath:          string | *""
+	FileFormat:        *"jsonl" | "csv"
+}
+
+Sinks: [...#Sink]
+Sinks: [
+	{Name: "trello", Type: "trello", TrelloListID: "6765c8d942977be5554e82d8"},
+]
@@ -0,0 +1,35 @@
+package leads
+
+import "encore.dev/config"
+
+// Config is the leads service configuration, loaded from config.cue.
+type Config struct {
+	// Sinks are the CRMs every lead is exported to. They are delivered
+	// to independently and in parallel.
+	Sinks []SinkConfig
+}
+
+// SinkConfig configures a CRM sink.
+type SinkConfig struct {
+	// Name identifies the sink in the outbox and in the lead's CRM
+	// references, so it must be unique and should not change.
+	Name string
+	// Type is "trello", "webhook" or "file".
+	Type string
+	// TrelloBoardID is the board whose webhook reports the cards moved
+	// by agents. Without it, changes made on the board are not synced back.
+	TrelloBoardID string
+	// TrelloListID is the list new cards are created in.
+	TrelloListID string
+	// TrelloStatusLists maps lead statuses to the list their card is
+	// moved to. Cards of other statuses are not moved.
+	TrelloStatusLists map[string]string
+	// WebhookURL receives the leads as signed JSON.
+	WebhookURL string
+	// FilePath is the file leads are appended to.
+	FilePath string
+	// FileFormat is "csv" or "jsonl".
+	FileFormat string
+}
+
+var cfg = config.Load[*Config]()
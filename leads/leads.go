@@ -1,64 +1,657 @@
+// Package leads provides a service to manage the leads collected by the chatbot.
 package leads
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"os"
+	"strings"
 	"time"
 
+	"encore.app/internal/pkg/apierror"
+	"encore.app/internal/pkg/authz"
+	"encore.app/internal/pkg/httpclient"
 	"encore.app/internal/pkg/idutil"
 	"encore.app/internal/pkg/trello"
+
+	"encore.dev/beta/errs"
 	"encore.dev/storage/sqldb"
 )
 
 const (
-	newLeadsTrelloLane = "6765c8d942977be5554e82d8"
-	brLocation         = "America/Sao_Paulo"
+	brLocation    = "America/Sao_Paulo"
+	trelloTimeout = 15 * time.Second
+
+	defaultListLimit = 50
+	maxListLimit     = 200
 )
 
-type CreateLeadInput struct {
-	Name  string
-	Phone string
+var (
+	db = sqldb.NewDatabase("leads", sqldb.DatabaseConfig{
+		Migrations: "./migrations",
+	})
+
+	secrets struct {
+		TrelloAPIKey string
+		TrelloToken  string
+		// TrelloAPISecret verifies the signature of Trello webhook callbacks.
+		TrelloAPISecret string
+		// CRMWebhookSecret signs the requests of webhook sinks.
+		CRMWebhookSecret string
+	}
+)
+
+//encore:service
+type Service struct {
+	sinks []CRMSink
+
+	outboxWake chan struct{}
+	outboxStop chan struct{}
+	outboxDone chan struct{}
 }
 
-func CreateLead(ctx context.Context, db *sqldb.Database, trelloAPI *trello.TrelloAPI, input *CreateLeadInput) error {
-	id, err := idutil.NewID()
+func initService() (*Service, error) {
+	if err := importLegacyLeads(context.Background()); err != nil {
+		return nil, fmt.Errorf("could not import legacy leads: %w", err)
+	}
+
+	sinks, err := newSinks(cfg.Sinks, sinkDeps{
+		trello: trello.New(
+			secrets.TrelloAPIKey,
+			secrets.TrelloToken,
+			httpclient.New(httpclient.WithTimeout(trelloTimeout)),
+		),
+		webhookSecret: secrets.CRMWebhookSecret,
+		propertyURL:   propertyURL,
+	})
 	if err != nil {
-		return fmt.Errorf("could not generate ID: %w", err)
+		return nil, fmt.Errorf("could not configure CRM sinks: %w", err)
+	}
+
+	s := &Service{
+		sinks:      sinks,
+		outboxWake: make(chan struct{}, 1),
+		outboxStop: make(chan struct{}),
+		outboxDone: make(chan struct{}),
 	}
 
-	go func() {
-		if _, err := db.Exec(context.Background(), `
-			INSERT INTO leads (id, name, phone)
-			VALUES ($1, $2, $3)
-		`, id, input.Name, input.Phone); err != nil {
-			fmt.Fprintf(os.Stderr, "could not insert lead: %v\n", err)
-			return
+	go s.runOutbox()
+	return s, nil
+}
+
+// Shutdown stops the outbox worker once its current batch is delivered.
+func (s *Service) Shutdown(force context.Context) {
+	close(s.outboxStop)
+
+	select {
+	case <-s.outboxDone:
+	case <-force.Done():
+	}
+}
+
+// CreateLead records a contact from the given phone number. A returning
+// contact updates the existing lead instead of creating a new one.
+// The lead is saved before returning, while its export to the CRM sinks
+// goes through the outbox.
+//
+//encore:api private method=POST path=/leads
+func (s *Service) CreateLead(ctx context.Context, input *CreateLeadInput) (*Lead, error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, apierror.E("could not begin transaction", err, errs.Internal)
+	}
+	defer tx.Rollback()
+
+	id, err := upsertLead(ctx, tx, input.Name, input.Phone, time.Now())
+	if err != nil {
+		if errors.Is(err, errNoPhone) {
+			return nil, &errs.Error{
+				Code:    errs.InvalidArgument,
+				Message: err.Error(),
+			}
 		}
+		return nil, apierror.E("could not save lead", err, errs.Internal)
+	}
+
+	// Agents keep the leads they were assigned, even when the lead writes
+	// to another number.
+	if input.AssignedAgent != "" {
+		if _, err := tx.Exec(ctx, `
+			UPDATE leads SET assigned_agent = $2, updated_at = NOW()
+			WHERE id = $1 AND assigned_agent IS NULL
+		`, id, input.AssignedAgent); err != nil {
+			return nil, apierror.E("could not assign lead", err, errs.Internal)
+		}
+	}
+
+	if err := s.enqueueExports(ctx, tx, id); err != nil {
+		return nil, apierror.E("could not save lead", err, errs.Internal)
+	}
 
-		loc, err := time.LoadLocation(brLocation)
+	if err := tx.Commit(); err != nil {
+		return nil, apierror.E("could not save lead", err, errs.Internal)
+	}
+
+	s.wakeOutbox()
+	return s.get(ctx, id)
+}
+
+//encore:api auth method=GET path=/leads
+func (s *Service) List(ctx context.Context, in ListInput) (*Leads, error) {
+	if err := authz.Require(authz.ScopeLeads, authz.RoleReadOnly); err != nil {
+		return nil, err
+	}
+
+	if in.Status != "" && !statuses[in.Status] {
+		return nil, &errs.Error{
+			Code:    errs.InvalidArgument,
+			Message: fmt.Sprintf("invalid status %q", in.Status),
+		}
+	}
+
+	limit := in.Limit
+	if limit <= 0 {
+		limit = defaultListLimit
+	}
+	limit = min(limit, maxListLimit)
+
+	var (
+		conds []string
+		args  []any
+	)
+
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if in.Status != "" {
+		conds = append(conds, "status = "+arg(in.Status))
+	}
+	if in.AssignedAgent != "" {
+		conds = append(conds, "assigned_agent = "+arg(in.AssignedAgent))
+	}
+	// IDs are ULIDs, so they sort by creation time.
+	if in.Cursor != "" {
+		conds = append(conds, "id < "+arg(in.Cursor))
+	}
+
+	query := `SELECT ` + leadColumns + ` FROM leads`
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	// We fetch one extra row to know whether there is a next page.
+	query += " ORDER BY id DESC LIMIT " + arg(limit+1)
+
+	rows, err := db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, apierror.E("could not fetch leads", err, errs.Internal)
+	}
+	defer rows.Close()
+
+	leads := Leads{Leads: make([]*Lead, 0)}
+	for rows.Next() {
+		lead, err := scanLead(rows)
 		if err != nil {
-			loc = time.UTC
+			return nil, apierror.E("could not scan lead", err, errs.Internal)
 		}
+		leads.Leads = append(leads.Leads, lead)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, apierror.E("could not iterate leads", err, errs.Internal)
+	}
 
-		now := time.Now().In(loc)
-		description := fmt.Sprintf(
-			"Nome: %s\nTelefone: %s\nCriado em: %s",
-			input.Name,
-			input.Phone,
-			now.Format("02/01/2006 às 15:04"),
-		)
+	if len(leads.Leads) > limit {
+		leads.Leads = leads.Leads[:limit]
+		leads.NextCursor = leads.Leads[limit-1].ID
+	}
+	return &leads, nil
+}
+
+//encore:api auth method=GET path=/leads/:id
+func (s *Service) Get(ctx context.Context, id string) (*Lead, error) {
+	if err := authz.Require(authz.ScopeLeads, authz.RoleReadOnly); err != nil {
+		return nil, err
+	}
+	return s.get(ctx, id)
+}
+
+// get returns the lead for endpoints that already checked the caller,
+// or that are not called by users at all, such as CreateLead.
+func (s *Service) get(ctx context.Context, id string) (*Lead, error) {
+	lead, err := fetchLead(ctx, id)
+	if err != nil {
+		return nil, apierror.E("could not fetch lead", err, errs.Internal)
+	}
+
+	if lead == nil {
+		return nil, &errs.Error{
+			Code:    errs.NotFound,
+			Message: "lead not found",
+		}
+	}
+	return lead, nil
+}
+
+//encore:api auth method=PATCH path=/leads/:id
+func (s *Service) Update(ctx context.Context, id string, in *UpdateInput) (*Lead, error) {
+	if err := authz.Require(authz.ScopeLeads, authz.RoleAgent); err != nil {
+		return nil, err
+	}
+
+	var (
+		sets []string
+		args []any
+	)
+
+	set := func(col string, v any) {
+		args = append(args, v)
+		sets = append(sets, fmt.Sprintf("%s = $%d", col, len(args)))
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, apierror.E("could not begin transaction", err, errs.Internal)
+	}
+	defer tx.Rollback()
+
+	if in.Name != nil {
+		if strings.TrimSpace(*in.Name) == "" {
+			return nil, &errs.Error{
+				Code:    errs.InvalidArgument,
+				Message: "name must not be empty",
+			}
+		}
+
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO lead_name_history (lead_id, old_name, new_name)
+			SELECT id, name, $2 FROM leads WHERE id = $1 AND name <> $2
+		`, id, *in.Name); err != nil {
+			return nil, apierror.E("could not record name change", err, errs.Internal)
+		}
+		set("name", *in.Name)
+	}
+
+	if in.Status != nil {
+		if !statuses[*in.Status] {
+			return nil, &errs.Error{
+				Code:    errs.InvalidArgument,
+				Message: fmt.Sprintf("invalid status %q", *in.Status),
+			}
+		}
+		set("status", *in.Status)
+		// Only a status that actually changes moves status_changed_at.
+		sets = append(sets, fmt.Sprintf(
+			"status_changed_at = CASE WHEN status = $%d THEN status_changed_at ELSE NOW() END", len(args),
+		))
+	}
+
+	if in.AssignedAgent != nil {
+		var agent *string
+		if *in.AssignedAgent != "" {
+			agent = in.AssignedAgent
+		}
+		set("assigned_agent", agent)
+	}
+
+	if len(sets) == 0 {
+		return nil, &errs.Error{
+			Code:    errs.InvalidArgument,
+			Message: "no fields to update",
+		}
+	}
+
+	sets = append(sets, "updated_at = NOW()")
+	args = append(args, id)
+
+	result, err := tx.Exec(ctx, fmt.Sprintf(
+		"UPDATE leads SET %s WHERE id = $%d", strings.Join(sets, ", "), len(args),
+	), args...)
+	if err != nil {
+		return nil, apierror.E("could not update lead", err, errs.Internal)
+	}
+
+	if result.RowsAffected() == 0 {
+		return nil, &errs.Error{
+			Code:    errs.NotFound,
+			Message: "lead not found",
+		}
+	}
+
+	// The CRM sinks reflect the new name, status or agent.
+	if err := s.enqueueExports(ctx, tx, id); err != nil {
+		return nil, apierror.E("could not update lead", err, errs.Internal)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, apierror.E("could not update lead", err, errs.Internal)
+	}
+
+	s.wakeOutbox()
+	return s.get(ctx, id)
+}
 
-		if err := trelloAPI.CreateCard(trello.TrelloCard{
-			Name:        input.Name,
-			Description: description,
-			ListID:      newLeadsTrelloLane,
-		}); err != nil {
-			fmt.Fprintf(os.Stderr, "could not create Trello card: %v\n", err)
-			return
+//encore:api auth method=POST path=/leads/:id/notes
+func (s *Service) AddNote(ctx context.Context, id string, in *AddNoteInput) (*Note, error) {
+	if err := authz.Require(authz.ScopeLeads, authz.RoleAgent); err != nil {
+		return nil, err
+	}
+
+	if strings.TrimSpace(in.Author) == "" || strings.TrimSpace(in.Text) == "" {
+		return nil, &errs.Error{
+			Code:    errs.InvalidArgument,
+			Message: "author and text are required",
 		}
+	}
+
+	noteID, err := idutil.NewID()
+	if err != nil {
+		return nil, apierror.E("could not generate ID", err, errs.Internal)
+	}
 
-		fmt.Printf("Created lead - ID: %s, Name: %s, Phone: %s\n", id, input.Name, input.Phone)
-	}()
+	note := Note{
+		ID:     noteID,
+		LeadID: id,
+		Author: in.Author,
+		Text:   in.Text,
+	}
+
+	// The insert only happens if the lead exists.
+	if err := db.QueryRow(ctx, `
+		INSERT INTO lead_notes (id, lead_id, author, text)
+		SELECT $1, id, $3, $4 FROM leads WHERE id = $2
+		RETURNING created_at
+	`, note.ID, id, note.Author, note.Text).Scan(&note.CreatedAt); err != nil {
+		if errors.Is(err, sqldb.ErrNoRows) {
+			return nil, &errs.Error{
+				Code:    errs.NotFound,
+				Message: "lead not found",
+			}
+		}
+		return nil, apierror.E("could not add note", err, errs.Internal)
+	}
+
+	if _, err := db.Exec(ctx, `UPDATE leads SET updated_at = NOW() WHERE id = $1`, id); err != nil {
+		return nil, apierror.E("could not update lead", err, errs.Internal)
+	}
+	return &note, nil
+}
+
+//encore:api auth method=POST path=/leads/:id/properties
+func (s *Service) LinkProperties(ctx context.Context, id string, in *LinkPropertiesInput) (*Lead, error) {
+	if err := authz.Require(authz.ScopeLeads, authz.RoleAgent); err != nil {
+		return nil, err
+	}
+
+	if len(in.References) == 0 {
+		return nil, &errs.Error{
+			Code:    errs.InvalidArgument,
+			Message: "references are required",
+		}
+	}
+
+	exists, err := leadExists(ctx, id)
+	if err != nil {
+		return nil, apierror.E("could not check lead existence", err, errs.Internal)
+	}
+
+	if !exists {
+		return nil, &errs.Error{
+			Code:    errs.NotFound,
+			Message: "lead not found",
+		}
+	}
+
+	if err := s.linkProperties(ctx, id, in.References); err != nil {
+		return nil, apierror.E("could not link properties", err, errs.Internal)
+	}
+	return s.get(ctx, id)
+}
+
+// RecordShownProperties links the properties the chatbot recommended
+// to the lead with the given phone number.
+//
+//encore:api private method=POST path=/leads/shown-properties
+func (s *Service) RecordShownProperties(ctx context.Context, in *RecordShownPropertiesInput) error {
+	if len(in.References) == 0 {
+		return nil
+	}
+
+	var id string
+	if err := db.QueryRow(ctx, `
+		SELECT id FROM leads WHERE phone = $1
+	`, normalizePhone(in.Phone)).Scan(&id); err != nil {
+		if errors.Is(err, sqldb.ErrNoRows) {
+			return &errs.Error{
+				Code:    errs.NotFound,
+				Message: "lead not found",
+			}
+		}
+		return apierror.E("could not fetch lead", err, errs.Internal)
+	}
+
+	if err := s.linkProperties(ctx, id, in.References); err != nil {
+		return apierror.E("could not link properties", err, errs.Internal)
+	}
+	return nil
+}
+
+const leadColumns = `
+	id, name, phone, status, assigned_agent,
+	contact_count, last_contact_at, status_changed_at, created_at, updated_at`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanLead(row scanner) (*Lead, error) {
+	var l Lead
+	if err := row.Scan(
+		&l.ID, &l.Name, &l.Phone, &l.Status, &l.AssignedAgent,
+		&l.ContactCount, &l.LastContactAt, &l.StatusChangedAt, &l.CreatedAt, &l.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+// fetchLead returns the lead with its notes, shown properties and
+// history, or nil if there is none.
+func fetchLead(ctx context.Context, id string) (*Lead, error) {
+	lead, err := scanLead(db.QueryRow(ctx, `SELECT `+leadColumns+` FROM leads WHERE id = $1`, id))
+	if err != nil {
+		if errors.Is(err, sqldb.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("could not scan lead: %w", err)
+	}
+
+	if lead.Notes, err = fetchNotes(ctx, id); err != nil {
+		return nil, err
+	}
+
+	if lead.ShownProperties, err = fetchShownProperties(ctx, id); err != nil {
+		return nil, err
+	}
+
+	if lead.Contacts, err = fetchContacts(ctx, id); err != nil {
+		return nil, err
+	}
+
+	if lead.NameHistory, err = fetchNameHistory(ctx, id); err != nil {
+		return nil, err
+	}
+
+	if lead.CRMRefs, err = fetchCRMRefs(ctx, id); err != nil {
+		return nil, err
+	}
+	return lead, nil
+}
+
+func fetchNotes(ctx context.Context, leadID string) ([]*Note, error) {
+	rows, err := db.Query(ctx, `
+		SELECT id, lead_id, author, text, created_at
+		FROM lead_notes
+		WHERE lead_id = $1
+		ORDER BY created_at
+	`, leadID)
+	if err != nil {
+		return nil, fmt.Errorf("could not query notes: %w", err)
+	}
+	defer rows.Close()
+
+	var notes []*Note
+	for rows.Next() {
+		var n Note
+		if err := rows.Scan(&n.ID, &n.LeadID, &n.Author, &n.Text, &n.CreatedAt); err != nil {
+			return nil, fmt.Errorf("could not scan note: %w", err)
+		}
+		notes = append(notes, &n)
+	}
+	return notes, rows.Err()
+}
+
+func fetchShownProperties(ctx context.Context, leadID string) ([]*ShownProperty, error) {
+	rows, err := db.Query(ctx, `
+		SELECT property_reference, first_shown_at, last_shown_at
+		FROM lead_properties
+		WHERE lead_id = $1
+		ORDER BY last_shown_at DESC
+	`, leadID)
+	if err != nil {
+		return nil, fmt.Errorf("could not query shown properties: %w", err)
+	}
+	defer rows.Close()
+
+	var props []*ShownProperty
+	for rows.Next() {
+		var p ShownProperty
+		if err := rows.Scan(&p.Reference, &p.FirstShownAt, &p.LastShownAt); err != nil {
+			return nil, fmt.Errorf("could not scan shown property: %w", err)
+		}
+		props = append(props, &p)
+	}
+	return props, rows.Err()
+}
+
+func fetchContacts(ctx context.Context, leadID string) ([]*Contact, error) {
+	rows, err := db.Query(ctx, `
+		SELECT name, contacted_at
+		FROM lead_contacts
+		WHERE lead_id = $1
+		ORDER BY contacted_at
+	`, leadID)
+	if err != nil {
+		return nil, fmt.Errorf("could not query contacts: %w", err)
+	}
+	defer rows.Close()
+
+	var contacts []*Contact
+	for rows.Next() {
+		var c Contact
+		if err := rows.Scan(&c.Name, &c.ContactedAt); err != nil {
+			return nil, fmt.Errorf("could not scan contact: %w", err)
+		}
+		contacts = append(contacts, &c)
+	}
+	return contacts, rows.Err()
+}
+
+func fetchNameHistory(ctx context.Context, leadID string) ([]*NameChange, error) {
+	rows, err := db.Query(ctx, `
+		SELECT old_name, new_name, changed_at
+		FROM lead_name_history
+		WHERE lead_id = $1
+		ORDER BY changed_at
+	`, leadID)
+	if err != nil {
+		return nil, fmt.Errorf("could not query name history: %w", err)
+	}
+	defer rows.Close()
+
+	var history []*NameChange
+	for rows.Next() {
+		var c NameChange
+		if err := rows.Scan(&c.OldName, &c.NewName, &c.ChangedAt); err != nil {
+			return nil, fmt.Errorf("could not scan name change: %w", err)
+		}
+		history = append(history, &c)
+	}
+	return history, rows.Err()
+}
+
+func fetchCRMRefs(ctx context.Context, leadID string) (map[string]string, error) {
+	rows, err := db.Query(ctx, `
+		SELECT sink, external_id FROM lead_crm_refs WHERE lead_id = $1
+	`, leadID)
+	if err != nil {
+		return nil, fmt.Errorf("could not query CRM refs: %w", err)
+	}
+	defer rows.Close()
+
+	var refs map[string]string
+	for rows.Next() {
+		var sink, ref string
+		if err := rows.Scan(&sink, &ref); err != nil {
+			return nil, fmt.Errorf("could not scan CRM ref: %w", err)
+		}
+
+		if refs == nil {
+			refs = make(map[string]string)
+		}
+		refs[sink] = ref
+	}
+	return refs, rows.Err()
+}
+
+// linkProperties records the properties as shown to the lead. The CRM
+// sinks are only updated when a property is shown for the first time,
+// since the chatbot shows the same properties over and over.
+func (s *Service) linkProperties(ctx context.Context, leadID string, refs []string) error {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var added bool
+	for _, ref := range refs {
+		// xmax is only 0 for a row that was inserted rather than updated.
+		var inserted bool
+		if err := tx.QueryRow(ctx, `
+			INSERT INTO lead_properties (lead_id, property_reference)
+			VALUES ($1, $2)
+			ON CONFLICT (lead_id, property_reference)
+			DO UPDATE SET last_shown_at = NOW()
+			RETURNING xmax = 0
+		`, leadID, ref).Scan(&inserted); err != nil {
+			return fmt.Errorf("could not link property %s: %w", ref, err)
+		}
+		added = added || inserted
+	}
+
+	if added {
+		if err := s.enqueueExports(ctx, tx, leadID); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit transaction: %w", err)
+	}
+
+	if added {
+		s.wakeOutbox()
+	}
 	return nil
 }
+
+func leadExists(ctx context.Context, id string) (bool, error) {
+	var exists bool
+	if err := db.QueryRow(ctx, `
+		SELECT EXISTS(SELECT 1 FROM leads WHERE id = $1)
+	`, id).Scan(&exists); err != nil {
+		return false, fmt.Errorf("error checking lead existence: %w", err)
+	}
+	return exists, nil
+}
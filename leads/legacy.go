@@ -0,0 +1,71 @@
+package leads
+
+import (
+	"context"
+	"fmt"
+
+	"encore.dev/rlog"
+	"encore.dev/storage/sqldb"
+)
+
+// legacyDB is the whatsapp service database, where leads were
+// stored before they had a service of their own.
+var legacyDB = sqldb.Named("whatsapp")
+
+// legacyImportSource is the lead_imports row recording that the leads of
+// the whatsapp database have been imported.
+const legacyImportSource = "whatsapp"
+
+// importLegacyLeads copies the leads stored in the whatsapp database. The
+// import and its lead_imports row are committed together, so it runs once
+// even when several instances start at the same time.
+func importLegacyLeads(ctx context.Context) error {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Another instance importing holds the row until it commits.
+	result, err := tx.Exec(ctx, `
+		INSERT INTO lead_imports (source) VALUES ($1)
+		ON CONFLICT (source) DO NOTHING
+	`, legacyImportSource)
+	if err != nil {
+		return fmt.Errorf("could not record lead import: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return nil
+	}
+
+	rows, err := legacyDB.Query(ctx, `
+		SELECT name, phone, created_at FROM leads ORDER BY created_at
+	`)
+	if err != nil {
+		return fmt.Errorf("could not query legacy leads: %w", err)
+	}
+	defer rows.Close()
+
+	// Legacy rows were created once per contact, so they are merged
+	// by phone number like any other contact.
+	for rows.Next() {
+		var l Lead
+		if err := rows.Scan(&l.Name, &l.Phone, &l.CreatedAt); err != nil {
+			return fmt.Errorf("could not scan legacy lead: %w", err)
+		}
+
+		if normalizePhone(l.Phone) == "" {
+			rlog.Warn("skipping legacy lead without phone", "name", l.Name)
+			continue
+		}
+
+		if _, err := upsertLead(ctx, tx, l.Name, l.Phone, l.CreatedAt); err != nil {
+			return fmt.Errorf("could not import lead %s: %w", l.Phone, err)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("could not read legacy leads: %w", err)
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,32 @@
+CREATE TABLE leads (
+    id VARCHAR(255) PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    phone VARCHAR(20) NOT NULL,
+    status VARCHAR(32) NOT NULL DEFAULT 'new',
+    assigned_agent VARCHAR(255),
+    status_changed_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_leads_phone ON leads (phone);
+CREATE INDEX idx_leads_status ON leads (status);
+CREATE INDEX idx_leads_assigned_agent ON leads (assigned_agent);
+
+CREATE TABLE lead_notes (
+    id VARCHAR(255) PRIMARY KEY,
+    lead_id VARCHAR(255) NOT NULL REFERENCES leads (id) ON DELETE CASCADE,
+    author VARCHAR(255) NOT NULL,
+    text TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_lead_notes_lead_id ON lead_notes (lead_id, created_at);
+
+CREATE TABLE lead_properties (
+    lead_id VARCHAR(255) NOT NULL REFERENCES leads (id) ON DELETE CASCADE,
+    property_reference VARCHAR(50) NOT NULL,
+    first_shown_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    last_shown_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (lead_id, property_reference)
+);
@@ -0,0 +1,86 @@
+ALTER TABLE leads
+    ADD COLUMN trello_card_id VARCHAR(255),
+    ADD COLUMN contact_count INTEGER NOT NULL DEFAULT 1,
+    ADD COLUMN last_contact_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW();
+
+UPDATE leads SET last_contact_at = created_at;
+
+CREATE TABLE lead_contacts (
+    id BIGSERIAL PRIMARY KEY,
+    lead_id VARCHAR(255) NOT NULL REFERENCES leads (id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    contacted_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_lead_contacts_lead_id ON lead_contacts (lead_id, contacted_at);
+
+CREATE TABLE lead_name_history (
+    id BIGSERIAL PRIMARY KEY,
+    lead_id VARCHAR(255) NOT NULL REFERENCES leads (id) ON DELETE CASCADE,
+    old_name VARCHAR(255) NOT NULL,
+    new_name VARCHAR(255) NOT NULL,
+    changed_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_lead_name_history_lead_id ON lead_name_history (lead_id, changed_at);
+
+-- Normalize phones the same way as normalizePhone: digits only, with the
+-- ninth digit added to Brazilian mobile numbers that lack it.
+UPDATE leads SET phone = regexp_replace(phone, '\D', '', 'g');
+UPDATE leads SET phone = substr(phone, 1, 4) || '9' || substr(phone, 5)
+WHERE phone ~ '^55[0-9]{2}[6-9][0-9]{7}$';
+
+-- Merge leads sharing a phone into the oldest one. Every row was a contact,
+-- and the most recent name wins.
+CREATE TEMPORARY TABLE lead_merges AS
+SELECT
+    id,
+    name,
+    created_at,
+    first_value(id) OVER (PARTITION BY phone ORDER BY created_at, id) AS keep_id,
+    lag(name) OVER (PARTITION BY phone ORDER BY created_at, id) AS previous_name
+FROM leads;
+
+INSERT INTO lead_contacts (lead_id, name, contacted_at)
+SELECT keep_id, name, created_at FROM lead_merges;
+
+INSERT INTO lead_name_history (lead_id, old_name, new_name, changed_at)
+SELECT keep_id, previous_name, name, created_at
+FROM lead_merges
+WHERE previous_name IS NOT NULL AND previous_name <> name;
+
+UPDATE lead_notes n SET lead_id = m.keep_id
+FROM lead_merges m
+WHERE n.lead_id = m.id AND m.id <> m.keep_id;
+
+INSERT INTO lead_properties (lead_id, property_reference, first_shown_at, last_shown_at)
+SELECT m.keep_id, p.property_reference, min(p.first_shown_at), max(p.last_shown_at)
+FROM lead_properties p
+JOIN lead_merges m ON m.id = p.lead_id
+WHERE m.id <> m.keep_id
+GROUP BY m.keep_id, p.property_reference
+ON CONFLICT (lead_id, property_reference) DO UPDATE SET
+    first_shown_at = LEAST(lead_properties.first_shown_at, EXCLUDED.first_shown_at),
+    last_shown_at = GREATEST(lead_properties.last_shown_at, EXCLUDED.last_shown_at);
+
+UPDATE leads l SET
+    name = latest.name,
+    contact_count = latest.contacts,
+    last_contact_at = latest.created_at
+FROM (
+    SELECT DISTINCT ON (keep_id)
+        keep_id, name, created_at,
+        count(*) OVER (PARTITION BY keep_id) AS contacts
+    FROM lead_merges
+    ORDER BY keep_id, created_at DESC, id DESC
+) latest
+WHERE l.id = latest.keep_id;
+
+DELETE FROM leads l
+USING lead_merges m
+WHERE l.id = m.id AND m.id <> m.keep_id;
+
+DROP TABLE lead_merges;
+
+DROP INDEX idx_leads_phone;
+CREATE UNIQUE INDEX idx_leads_phone ON leads (phone);
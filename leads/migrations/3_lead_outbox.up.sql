@@ -0,0 +1,15 @@
+CREATE TABLE lead_outbox (
+    id BIGSERIAL PRIMARY KEY,
+    lead_id VARCHAR(255) NOT NULL,
+    kind VARCHAR(64) NOT NULL,
+    status VARCHAR(16) NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+    next_attempt_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    locked_until TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    delivered_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX idx_lead_outbox_due ON lead_outbox (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX idx_lead_outbox_status ON lead_outbox (status, id);
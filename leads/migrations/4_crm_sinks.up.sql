@@ -0,0 +1,19 @@
+CREATE TABLE lead_crm_refs (
+    lead_id VARCHAR(255) NOT NULL REFERENCES leads (id) ON DELETE CASCADE,
+    sink VARCHAR(64) NOT NULL,
+    external_id VARCHAR(255) NOT NULL,
+    exported_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (lead_id, sink)
+);
+
+CREATE INDEX idx_lead_crm_refs_external_id ON lead_crm_refs (sink, external_id);
+
+-- Cards created before sinks were configurable belong to the "trello" sink.
+INSERT INTO lead_crm_refs (lead_id, sink, external_id)
+SELECT id, 'trello', trello_card_id FROM leads WHERE trello_card_id IS NOT NULL;
+
+ALTER TABLE leads DROP COLUMN trello_card_id;
+
+ALTER TABLE lead_outbox ADD COLUMN sink VARCHAR(64) NOT NULL DEFAULT '';
+
+UPDATE lead_outbox SET kind = 'crm_sync', sink = 'trello' WHERE kind = 'trello_sync';
@@ -0,0 +1,7 @@
+CREATE TABLE lead_imports (
+    source VARCHAR(64) PRIMARY KEY,
+    imported_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- Leads stored before imports were tracked came from a completed import.
+INSERT INTO lead_imports (source) SELECT 'whatsapp' WHERE EXISTS (SELECT 1 FROM leads);
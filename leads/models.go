@@ -0,0 +1,181 @@
+package leads
+
+import "time"
+
+// Lead statuses, in pipeline order.
+const (
+	StatusNew       = "new"
+	StatusContacted = "contacted"
+	StatusVisiting  = "visiting"
+	StatusProposal  = "proposal"
+	StatusWon       = "won"
+	StatusLost      = "lost"
+)
+
+var statuses = map[string]bool{
+	StatusNew:       true,
+	StatusContacted: true,
+	StatusVisiting:  true,
+	StatusProposal:  true,
+	StatusWon:       true,
+	StatusLost:      true,
+}
+
+// Lead is a customer who left their contact through the chatbot.
+// There is a single lead per normalized phone number.
+type Lead struct {
+	ID              string           `json:"id"`
+	Name            string           `json:"name"`
+	Phone           string           `json:"phone"`
+	Status          string           `json:"status"`
+	AssignedAgent   *string          `json:"assignedAgent,omitempty"`
+	ContactCount    int              `json:"contactCount"`
+	LastContactAt   time.Time        `json:"lastContactAt"`
+	Notes           []*Note          `json:"notes,omitempty"`
+	ShownProperties []*ShownProperty `json:"shownProperties,omitempty"`
+	Contacts        []*Contact       `json:"contacts,omitempty"`
+	NameHistory     []*NameChange    `json:"nameHistory,omitempty"`
+	// CRMRefs identifies the lead in each CRM sink it was exported to,
+	// such as its Trello card ID, by sink name.
+	CRMRefs         map[string]string `json:"crmRefs,omitempty"`
+	StatusChangedAt time.Time         `json:"statusChangedAt"`
+	CreatedAt       time.Time         `json:"createdAt"`
+	UpdatedAt       time.Time         `json:"updatedAt"`
+}
+
+// Contact is a time the lead left their name through the chatbot.
+type Contact struct {
+	Name        string    `json:"name"`
+	ContactedAt time.Time `json:"contactedAt"`
+}
+
+// NameChange records a lead being renamed, by the customer or by an agent.
+type NameChange struct {
+	OldName   string    `json:"oldName"`
+	NewName   string    `json:"newName"`
+	ChangedAt time.Time `json:"changedAt"`
+}
+
+// Note is a timestamped comment left on a lead by an agent.
+type Note struct {
+	ID        string    `json:"id"`
+	LeadID    string    `json:"leadId"`
+	Author    string    `json:"author"`
+	Text      string    `json:"text"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// ShownProperty is a property recommended to a lead.
+type ShownProperty struct {
+	Reference    string    `json:"reference"`
+	FirstShownAt time.Time `json:"firstShownAt"`
+	LastShownAt  time.Time `json:"lastShownAt"`
+}
+
+// Leads is a page of leads.
+type Leads struct {
+	Leads []*Lead `json:"leads"`
+	// NextCursor is set by List when more results are available.
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+// CreateLeadInput is a contact from a phone number. AssignedAgent, if
+// set, assigns the lead to the agent unless it is assigned already.
+type CreateLeadInput struct {
+	Name          string
+	Phone         string
+	AssignedAgent string
+}
+
+// ListInput holds the filters and pagination options for List.
+// Leads are returned newest first.
+type ListInput struct {
+	Status        string `query:"status"`
+	AssignedAgent string `query:"agent"`
+	// Cursor is the NextCursor returned by a previous call.
+	Cursor string `query:"cursor"`
+	// Limit caps the page size, defaulting to 50 and capped at 200.
+	Limit int `query:"limit"`
+}
+
+// UpdateInput holds a partial update of a lead.
+// Only non-nil fields are written.
+type UpdateInput struct {
+	Name   *string `json:"name,omitempty"`
+	Status *string `json:"status,omitempty"`
+	// AssignedAgent set to an empty string unassigns the lead.
+	AssignedAgent *string `json:"assignedAgent,omitempty"`
+}
+
+// AddNoteInput is a note to add to a lead.
+type AddNoteInput struct {
+	Author string `json:"author"`
+	Text   string `json:"text"`
+}
+
+// LinkPropertiesInput holds property references shown to a lead.
+type LinkPropertiesInput struct {
+	References []string `json:"references"`
+}
+
+// RecordShownPropertiesInput holds the property references the
+// chatbot recommended to the lead with the given phone number.
+type RecordShownPropertiesInput struct {
+	Phone      string   `json:"phone"`
+	References []string `json:"references"`
+}
+
+// OutboxMessage is a pending, delivered or dead side effect of a lead
+// change, such as exporting it to a CRM sink. Sink is the name of the
+// sink the message is for, if any.
+type OutboxMessage struct {
+	ID            int64      `json:"id"`
+	LeadID        string     `json:"leadId"`
+	Kind          string     `json:"kind"`
+	Sink          string     `json:"sink,omitempty"`
+	Status        string     `json:"status"`
+	Attempts      int        `json:"attempts"`
+	LastError     *string    `json:"lastError,omitempty"`
+	NextAttemptAt time.Time  `json:"nextAttemptAt"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	DeliveredAt   *time.Time `json:"deliveredAt,omitempty"`
+}
+
+// OutboxMessages is a list of outbox messages.
+type OutboxMessages struct {
+	Messages []*OutboxMessage `json:"messages"`
+}
+
+// ListOutboxInput filters the outbox messages, newest first.
+type ListOutboxInput struct {
+	// Status is pending, delivered or dead. Defaults to dead.
+	Status string `query:"status"`
+	// Limit caps the number of messages, defaulting to 50 and capped at 200.
+	Limit int `query:"limit"`
+}
+
+// ReplayOutboxInput selects the dead messages to deliver again.
+type ReplayOutboxInput struct {
+	// IDs of the messages to replay. Empty replays every dead message.
+	IDs []int64 `json:"ids,omitempty"`
+}
+
+// ReplayOutboxResponse reports how many messages were queued again.
+type ReplayOutboxResponse struct {
+	Replayed int `json:"replayed"`
+}
+
+// TrelloWebhook is the webhook of a Trello sink.
+type TrelloWebhook struct {
+	Sink        string `json:"sink"`
+	BoardID     string `json:"boardId"`
+	CallbackURL string `json:"callbackUrl"`
+	WebhookID   string `json:"webhookId"`
+	// Created is false when the webhook was already registered.
+	Created bool `json:"created"`
+}
+
+// TrelloWebhooks lists the webhooks of the Trello sinks.
+type TrelloWebhooks struct {
+	Webhooks []*TrelloWebhook `json:"webhooks"`
+}
@@ -0,0 +1,356 @@
+package leads
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"encore.app/internal/pkg/apierror"
+	"encore.app/internal/pkg/authz"
+
+	"encore.dev/beta/errs"
+	"encore.dev/rlog"
+	"encore.dev/storage/sqldb"
+)
+
+// Side effects delivered through the outbox.
+const (
+	outboxKindCRMSync = "crm_sync"
+)
+
+const (
+	outboxStatusPending   = "pending"
+	outboxStatusDelivered = "delivered"
+	outboxStatusDead      = "dead"
+
+	outboxPollInterval    = 10 * time.Second
+	outboxBatchSize       = 10
+	outboxDeliveryTimeout = 30 * time.Second
+	// outboxLease is how long a claimed message is hidden from other
+	// workers, so a crashed delivery is retried once the lease expires.
+	// A sink may deliver the whole batch one message after the other,
+	// so the lease outlasts the batch timing out message by message.
+	outboxLease = outboxBatchSize*outboxDeliveryTimeout + time.Minute
+
+	outboxMaxAttempts = 10
+	outboxBaseDelay   = 30 * time.Second
+	outboxMaxDelay    = time.Hour
+)
+
+var outboxStatuses = map[string]bool{
+	outboxStatusPending:   true,
+	outboxStatusDelivered: true,
+	outboxStatusDead:      true,
+}
+
+// enqueueOutbox adds a side effect for the lead, optionally for a CRM sink.
+// It must run in the same transaction as the change that causes it, so
+// neither exists without the other.
+func enqueueOutbox(ctx context.Context, tx *sqldb.Tx, leadID, kind, sink string) error {
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO lead_outbox (lead_id, kind, sink) VALUES ($1, $2, $3)
+	`, leadID, kind, sink); err != nil {
+		return fmt.Errorf("could not enqueue %s: %w", kind, err)
+	}
+	return nil
+}
+
+// enqueueExports queues the export of the lead to every CRM sink.
+func (s *Service) enqueueExports(ctx context.Context, tx *sqldb.Tx, leadID string) error {
+	for _, sink := range s.sinks {
+		if err := enqueueOutbox(ctx, tx, leadID, outboxKindCRMSync, sink.Name()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//encore:api auth method=GET path=/leads/outbox
+func (s *Service) ListOutbox(ctx context.Context, in ListOutboxInput) (*OutboxMessages, error) {
+	if err := authz.Require(authz.ScopeLeads, authz.RoleReadOnly); err != nil {
+		return nil, err
+	}
+
+	status := in.Status
+	if status == "" {
+		status = outboxStatusDead
+	}
+
+	if !outboxStatuses[status] {
+		return nil, &errs.Error{
+			Code:    errs.InvalidArgument,
+			Message: fmt.Sprintf("invalid status %q", in.Status),
+		}
+	}
+
+	limit := in.Limit
+	if limit <= 0 {
+		limit = defaultListLimit
+	}
+	limit = min(limit, maxListLimit)
+
+	rows, err := db.Query(ctx, `
+		SELECT id, lead_id, kind, sink, status, attempts, last_error,
+			next_attempt_at, created_at, delivered_at
+		FROM lead_outbox
+		WHERE status = $1
+		ORDER BY id DESC
+		LIMIT $2
+	`, status, limit)
+	if err != nil {
+		return nil, apierror.E("could not fetch outbox", err, errs.Internal)
+	}
+	defer rows.Close()
+
+	msgs := OutboxMessages{Messages: make([]*OutboxMessage, 0)}
+	for rows.Next() {
+		var m OutboxMessage
+		if err := rows.Scan(
+			&m.ID, &m.LeadID, &m.Kind, &m.Sink, &m.Status, &m.Attempts, &m.LastError,
+			&m.NextAttemptAt, &m.CreatedAt, &m.DeliveredAt,
+		); err != nil {
+			return nil, apierror.E("could not scan outbox message", err, errs.Internal)
+		}
+		msgs.Messages = append(msgs.Messages, &m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, apierror.E("could not iterate outbox", err, errs.Internal)
+	}
+	return &msgs, nil
+}
+
+// ReplayOutbox queues dead messages for delivery again, with a fresh
+// retry budget.
+//
+//encore:api auth method=POST path=/leads/outbox/replay
+func (s *Service) ReplayOutbox(ctx context.Context, in *ReplayOutboxInput) (*ReplayOutboxResponse, error) {
+	if err := authz.Require(authz.ScopeLeads, authz.RoleAdmin); err != nil {
+		return nil, err
+	}
+
+	query := `
+		UPDATE lead_outbox SET
+			status = 'pending', attempts = 0, last_error = NULL,
+			next_attempt_at = NOW(), locked_until = NULL
+		WHERE status = 'dead'`
+
+	var args []any
+	if len(in.IDs) > 0 {
+		query += ` AND id = ANY($1)`
+		args = append(args, in.IDs)
+	}
+
+	result, err := db.Exec(ctx, query, args...)
+	if err != nil {
+		return nil, apierror.E("could not replay outbox", err, errs.Internal)
+	}
+
+	s.wakeOutbox()
+	return &ReplayOutboxResponse{Replayed: int(result.RowsAffected())}, nil
+}
+
+// runOutbox delivers due outbox messages until Shutdown is called.
+// It polls periodically and is woken up early by wakeOutbox.
+func (s *Service) runOutbox() {
+	defer close(s.outboxDone)
+
+	ticker := time.NewTicker(outboxPollInterval)
+	defer ticker.Stop()
+
+	for {
+		s.drainOutbox()
+
+		select {
+		case <-s.outboxStop:
+			return
+		case <-ticker.C:
+		case <-s.outboxWake:
+		}
+	}
+}
+
+// wakeOutbox asks the worker to look for due messages now.
+func (s *Service) wakeOutbox() {
+	select {
+	case s.outboxWake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Service) drainOutbox() {
+	for {
+		n, err := s.processOutbox(context.Background())
+		if err != nil {
+			rlog.Error("could not process outbox", "error", err)
+			return
+		}
+
+		if n < outboxBatchSize {
+			return
+		}
+	}
+}
+
+// processOutbox claims a batch of due messages and delivers them,
+// returning how many were claimed.
+func (s *Service) processOutbox(ctx context.Context) (int, error) {
+	rows, err := db.Query(ctx, `
+		UPDATE lead_outbox SET locked_until = NOW() + $1 * INTERVAL '1 second'
+		WHERE id IN (
+			SELECT id FROM lead_outbox
+			WHERE status = 'pending'
+				AND next_attempt_at <= NOW()
+				AND (locked_until IS NULL OR locked_until < NOW())
+				-- Messages of a lead being delivered elsewhere to the
+				-- same sink wait their turn.
+				AND NOT EXISTS (
+					SELECT 1 FROM lead_outbox other
+					WHERE other.lead_id = lead_outbox.lead_id
+						AND other.sink = lead_outbox.sink
+						AND other.status = 'pending'
+						AND other.locked_until >= NOW()
+				)
+			ORDER BY id
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, lead_id, kind, sink, attempts
+	`, int(outboxLease.Seconds()), outboxBatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("could not claim outbox messages: %w", err)
+	}
+
+	var batch []*OutboxMessage
+	for rows.Next() {
+		var m OutboxMessage
+		if err := rows.Scan(&m.ID, &m.LeadID, &m.Kind, &m.Sink, &m.Attempts); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("could not scan outbox message: %w", err)
+		}
+		batch = append(batch, &m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("could not iterate outbox messages: %w", err)
+	}
+
+	// Each sink gets its messages in order, while a slow or failing sink
+	// does not hold back the others.
+	bySink := make(map[string][]*OutboxMessage)
+	for _, m := range batch {
+		bySink[m.Sink] = append(bySink[m.Sink], m)
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		failures []error
+	)
+
+	for _, msgs := range bySink {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for _, m := range msgs {
+				deliverCtx, cancel := context.WithTimeout(ctx, outboxDeliveryTimeout)
+				err := s.deliver(deliverCtx, m)
+				cancel()
+
+				if err := recordDelivery(ctx, m, err); err != nil {
+					mu.Lock()
+					failures = append(failures, err)
+					mu.Unlock()
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := errors.Join(failures...); err != nil {
+		return 0, err
+	}
+	return len(batch), nil
+}
+
+// sink returns the configured sink with the name, or nil.
+func (s *Service) sink(name string) CRMSink {
+	for _, sink := range s.sinks {
+		if sink.Name() == name {
+			return sink
+		}
+	}
+	return nil
+}
+
+func (s *Service) deliver(ctx context.Context, m *OutboxMessage) error {
+	switch m.Kind {
+	case outboxKindCRMSync:
+		sink := s.sink(m.Sink)
+		if sink == nil {
+			return fmt.Errorf("unknown CRM sink %q", m.Sink)
+		}
+
+		lead, err := fetchLead(ctx, m.LeadID)
+		if err != nil {
+			return err
+		}
+
+		// There is nothing to export for a lead deleted in the meantime.
+		if lead == nil {
+			return nil
+		}
+		return exportLead(ctx, sink, lead)
+	}
+	return fmt.Errorf("unknown outbox message kind %q", m.Kind)
+}
+
+// recordDelivery marks the message as delivered, or schedules its next
+// attempt after a failure. A message failing too many times is dead and
+// waits for ReplayOutbox.
+func recordDelivery(ctx context.Context, m *OutboxMessage, deliveryErr error) error {
+	attempts := m.Attempts + 1
+
+	if deliveryErr == nil {
+		if _, err := db.Exec(ctx, `
+			UPDATE lead_outbox SET
+				status = 'delivered', attempts = $2, last_error = NULL,
+				delivered_at = NOW(), locked_until = NULL
+			WHERE id = $1
+		`, m.ID, attempts); err != nil {
+			return fmt.Errorf("could not mark outbox message delivered: %w", err)
+		}
+		return nil
+	}
+
+	status := outboxStatusPending
+	if attempts >= outboxMaxAttempts {
+		status = outboxStatusDead
+		rlog.Error("outbox message is dead", "id", m.ID, "kind", m.Kind, "sink", m.Sink, "lead_id", m.LeadID, "error", deliveryErr)
+	} else {
+		rlog.Warn("could not deliver outbox message", "id", m.ID, "kind", m.Kind, "sink", m.Sink, "attempts", attempts, "error", deliveryErr)
+	}
+
+	if _, err := db.Exec(ctx, `
+		UPDATE lead_outbox SET
+			status = $2, attempts = $3, last_error = $4,
+			next_attempt_at = $5, locked_until = NULL
+		WHERE id = $1
+	`, m.ID, status, attempts, deliveryErr.Error(), time.Now().Add(retryDelay(attempts))); err != nil {
+		return fmt.Errorf("could not record outbox failure: %w", err)
+	}
+	return nil
+}
+
+// retryDelay is the exponential backoff before the next attempt,
+// after the given number of failed attempts.
+func retryDelay(attempts int) time.Duration {
+	delay := outboxBaseDelay
+	for i := 1; i < attempts && delay < outboxMaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, outboxMaxDelay)
+}
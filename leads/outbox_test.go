@@ -0,0 +1,34 @@
+package leads
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryDelay(t *testing.T) {
+	t.Parallel()
+
+	tests := map[int]time.Duration{
+		1:   30 * time.Second,
+		2:   time.Minute,
+		3:   2 * time.Minute,
+		7:   32 * time.Minute,
+		8:   time.Hour,
+		9:   time.Hour,
+		100: time.Hour,
+	}
+
+	for attempts, want := range tests {
+		assert.Equal(t, want, retryDelay(attempts), "attempts %d", attempts)
+	}
+}
+
+func TestOutboxLease(t *testing.T) {
+	t.Parallel()
+
+	// A batch delivered to a single sink must not be claimed again while
+	// its last message is still waiting for its turn.
+	assert.Greater(t, outboxLease, outboxBatchSize*outboxDeliveryTimeout)
+}
@@ -0,0 +1,24 @@
+package leads
+
+import "strings"
+
+// normalizePhone reduces a phone number to its digits, so the same number
+// written differently (+55 (79) 99999-0000, a WhatsApp JID user...) maps to
+// a single lead. Brazilian mobile numbers missing the ninth digit, as some
+// WhatsApp accounts still report them, get it added back.
+func normalizePhone(phone string) string {
+	var b strings.Builder
+	for _, r := range phone {
+		if r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		}
+	}
+	digits := b.String()
+
+	// 55 + two digit area code + eight digit number starting with 6 to 9
+	// is a mobile number from before the ninth digit was introduced.
+	if len(digits) == 12 && strings.HasPrefix(digits, "55") && digits[4] >= '6' {
+		return digits[:4] + "9" + digits[4:]
+	}
+	return digits
+}
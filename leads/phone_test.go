@@ -0,0 +1,30 @@
+package leads
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizePhone(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		phone string
+		want  string
+	}{
+		"digits only":                  {"5579999990000", "5579999990000"},
+		"formatted":                    {"+55 (79) 99999-0000", "5579999990000"},
+		"mobile without ninth digit":   {"557999990000", "5579999990000"},
+		"landline is left as is":       {"557932140000", "557932140000"},
+		"foreign number is left as is": {"351912345678", "351912345678"},
+		"empty":                        {"", ""},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.want, normalizePhone(tc.phone))
+		})
+	}
+}
@@ -0,0 +1,461 @@
+package leads
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"encore.app/internal/pkg/httpclient"
+	"encore.app/internal/pkg/trello"
+
+	"encore.dev"
+)
+
+const (
+	sinkTypeTrello  = "trello"
+	sinkTypeWebhook = "webhook"
+	sinkTypeFile    = "file"
+
+	fileFormatCSV   = "csv"
+	fileFormatJSONL = "jsonl"
+
+	// Events sent by the webhook and file sinks.
+	eventLeadCreated = "lead.created"
+	eventLeadUpdated = "lead.updated"
+
+	webhookTimeout = 15 * time.Second
+
+	// webhookSignatureHeader holds "sha256=" followed by the hex HMAC-SHA256
+	// of the timestamp header, a dot and the body, keyed with the secret.
+	webhookSignatureHeader = "X-Imolink-Signature"
+	webhookTimestampHeader = "X-Imolink-Timestamp"
+)
+
+// CRMSink exports leads to a CRM.
+type CRMSink interface {
+	// Name is the configured name of the sink.
+	Name() string
+	// Export sends the current state of the lead. ref is what the previous
+	// export of the lead to this sink returned, and is empty the first time.
+	// The returned ref identifies the lead in the CRM.
+	Export(ctx context.Context, lead *Lead, ref string) (string, error)
+}
+
+// sinkDeps holds what the sinks need besides their configuration.
+type sinkDeps struct {
+	trello        *trello.Client
+	webhookSecret string
+	// propertyURL returns the public page of a property.
+	propertyURL func(ref string) string
+}
+
+// newSinks builds the configured sinks, failing on an invalid configuration
+// so that a typo does not silently stop leads from being exported.
+func newSinks(configs []SinkConfig, deps sinkDeps) ([]CRMSink, error) {
+	sinks := make([]CRMSink, 0, len(configs))
+	names := make(map[string]bool)
+
+	for _, c := range configs {
+		if c.Name == "" {
+			return nil, errors.New("sink name is required")
+		}
+
+		if names[c.Name] {
+			return nil, fmt.Errorf("duplicate sink name %q", c.Name)
+		}
+		names[c.Name] = true
+
+		switch c.Type {
+		case sinkTypeTrello:
+			if c.TrelloListID == "" {
+				return nil, fmt.Errorf("sink %q: Trello list ID is required", c.Name)
+			}
+
+			// Cards moved on the board change the status of their lead,
+			// so each list can only stand for a single status.
+			lists := make(map[string]bool)
+			for status, listID := range c.TrelloStatusLists {
+				if !statuses[status] {
+					return nil, fmt.Errorf("sink %q: invalid status %q in Trello status lists", c.Name, status)
+				}
+
+				if lists[listID] {
+					return nil, fmt.Errorf("sink %q: Trello list %q is mapped to several statuses", c.Name, listID)
+				}
+				lists[listID] = true
+			}
+			sinks = append(sinks, newTrelloSink(c, deps.trello, deps.propertyURL))
+		case sinkTypeWebhook:
+			if c.WebhookURL == "" {
+				return nil, fmt.Errorf("sink %q: webhook URL is required", c.Name)
+			}
+
+			if deps.webhookSecret == "" {
+				return nil, fmt.Errorf("sink %q: the CRMWebhookSecret secret is not set", c.Name)
+			}
+			sinks = append(sinks, newWebhookSink(c.Name, c.WebhookURL, deps.webhookSecret))
+		case sinkTypeFile:
+			if c.FilePath == "" {
+				return nil, fmt.Errorf("sink %q: file path is required", c.Name)
+			}
+
+			if c.FileFormat != fileFormatCSV && c.FileFormat != fileFormatJSONL {
+				return nil, fmt.Errorf("sink %q: unknown file format %q", c.Name, c.FileFormat)
+			}
+			sinks = append(sinks, newFileSink(c.Name, c.FilePath, c.FileFormat))
+		default:
+			return nil, fmt.Errorf("sink %q: unknown type %q", c.Name, c.Type)
+		}
+	}
+	return sinks, nil
+}
+
+// propertyURL returns the public page of the property.
+func propertyURL(ref string) string {
+	base := encore.Meta().APIBaseURL
+	return fmt.Sprintf("%s://%s/properties/%s", base.Scheme, base.Host, url.PathEscape(ref))
+}
+
+// exportLead exports the lead to the sink and stores the ref it returns.
+// The ref is stored even if the export fails halfway, so that a retry
+// does not create the lead in the CRM a second time.
+func exportLead(ctx context.Context, sink CRMSink, lead *Lead) error {
+	ref := lead.CRMRefs[sink.Name()]
+
+	newRef, exportErr := sink.Export(ctx, lead, ref)
+	if exportErr != nil {
+		exportErr = fmt.Errorf("could not export lead to %s: %w", sink.Name(), exportErr)
+	}
+
+	if newRef == "" {
+		return exportErr
+	}
+
+	if _, err := db.Exec(ctx, `
+		INSERT INTO lead_crm_refs (lead_id, sink, external_id)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (lead_id, sink) DO UPDATE SET
+			external_id = EXCLUDED.external_id,
+			exported_at = NOW()
+	`, lead.ID, sink.Name(), newRef); err != nil {
+		return errors.Join(exportErr, fmt.Errorf("could not store %s ref: %w", sink.Name(), err))
+	}
+	return exportErr
+}
+
+// leadEvent is the body of webhook requests and the lines of JSONL files.
+type leadEvent struct {
+	Event  string    `json:"event"`
+	SentAt time.Time `json:"sentAt"`
+	Lead   *Lead     `json:"lead"`
+}
+
+func eventFor(ref string) string {
+	if ref == "" {
+		return eventLeadCreated
+	}
+	return eventLeadUpdated
+}
+
+// trelloSink keeps a card per lead on a Trello board. Cards are created in
+// the list of new leads and follow the lead status through statusLists.
+// Cards moved by agents update the lead status through the board webhook.
+type trelloSink struct {
+	name        string
+	api         *trello.Client
+	boardID     string
+	listID      string
+	statusLists map[string]string
+	propertyURL func(ref string) string
+}
+
+func newTrelloSink(c SinkConfig, api *trello.Client, propertyURL func(ref string) string) *trelloSink {
+	return &trelloSink{
+		name:        c.Name,
+		api:         api,
+		boardID:     c.TrelloBoardID,
+		listID:      c.TrelloListID,
+		statusLists: c.TrelloStatusLists,
+		propertyURL: propertyURL,
+	}
+}
+
+func (t *trelloSink) Name() string { return t.name }
+
+// statusForList returns the lead status the list stands for, if any.
+func (t *trelloSink) statusForList(listID string) (string, bool) {
+	for status, id := range t.statusLists {
+		if id == listID {
+			return status, true
+		}
+	}
+	return "", false
+}
+
+// Export creates the card of the lead, or updates it if ref is its card ID,
+// and attaches the links of the properties shown to the lead. A card
+// deleted from the board is created again.
+func (t *trelloSink) Export(ctx context.Context, lead *Lead, ref string) (string, error) {
+	description := trelloDescription(lead)
+	attached := make(map[string]bool)
+
+	if ref != "" {
+		update := trello.CardUpdate{
+			Name:        &lead.Name,
+			Description: &description,
+		}
+
+		// Statuses without a list leave the card where agents put it.
+		if listID, ok := t.statusLists[lead.Status]; ok {
+			update.ListID = &listID
+		}
+
+		_, err := t.api.UpdateCard(ctx, ref, update)
+		switch {
+		case errors.Is(err, trello.ErrNotFound):
+			ref = ""
+		case err != nil:
+			return ref, fmt.Errorf("could not update Trello card: %w", err)
+		default:
+			card, err := t.api.GetCard(ctx, ref)
+			if err != nil {
+				return ref, fmt.Errorf("could not get Trello card: %w", err)
+			}
+
+			for _, a := range card.Attachments {
+				attached[a.URL] = true
+			}
+		}
+	}
+
+	if ref == "" {
+		listID := t.listID
+		if statusList, ok := t.statusLists[lead.Status]; ok {
+			listID = statusList
+		}
+
+		card, err := t.api.CreateCard(ctx, trello.NewCard{
+			Name:        lead.Name,
+			Description: description,
+			ListID:      listID,
+		})
+		if err != nil {
+			return "", fmt.Errorf("could not create Trello card: %w", err)
+		}
+		ref = card.ID
+	}
+
+	for _, p := range lead.ShownProperties {
+		link := t.propertyURL(p.Reference)
+		if attached[link] {
+			continue
+		}
+
+		if _, err := t.api.AttachLink(ctx, ref, "Imóvel "+p.Reference, link); err != nil {
+			return ref, fmt.Errorf("could not attach property %s: %w", p.Reference, err)
+		}
+	}
+	return ref, nil
+}
+
+func trelloDescription(lead *Lead) string {
+	loc, err := time.LoadLocation(brLocation)
+	if err != nil {
+		loc = time.UTC
+	}
+
+	const layout = "02/01/2006 às 15:04"
+
+	description := fmt.Sprintf(
+		"Nome: %s\nTelefone: %s\nCriado em: %s\nÚltimo contato: %s\nContatos: %d",
+		lead.Name,
+		lead.Phone,
+		lead.CreatedAt.In(loc).Format(layout),
+		lead.LastContactAt.In(loc).Format(layout),
+		lead.ContactCount,
+	)
+
+	if len(lead.NameHistory) > 0 {
+		names := make([]string, 0, len(lead.NameHistory))
+		for _, change := range lead.NameHistory {
+			names = append(names, change.OldName)
+		}
+		description += "\nNomes anteriores: " + strings.Join(names, ", ")
+	}
+	return description
+}
+
+// webhookSink posts each export as signed JSON to a URL.
+type webhookSink struct {
+	name   string
+	url    string
+	secret []byte
+	client *http.Client
+}
+
+func newWebhookSink(name, url, secret string) *webhookSink {
+	return &webhookSink{
+		name:   name,
+		url:    url,
+		secret: []byte(secret),
+		client: httpclient.New(httpclient.WithTimeout(webhookTimeout)),
+	}
+}
+
+func (w *webhookSink) Name() string { return w.name }
+
+// Export posts the lead and returns its ID. Any response other than
+// a 2xx is a failure, so the export is retried.
+func (w *webhookSink) Export(ctx context.Context, lead *Lead, ref string) (string, error) {
+	now := time.Now()
+
+	body, err := json.Marshal(leadEvent{
+		Event:  eventFor(ref),
+		SentAt: now.UTC(),
+		Lead:   lead,
+	})
+	if err != nil {
+		return "", fmt.Errorf("could not marshal lead: %w", err)
+	}
+
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("could not create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookTimestampHeader, timestamp)
+	req.Header.Set(webhookSignatureHeader, "sha256="+signWebhook(w.secret, timestamp, body))
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("could not post lead: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("webhook responded with status %d: %s", resp.StatusCode, msg)
+	}
+	return lead.ID, nil
+}
+
+// signWebhook returns the hex HMAC-SHA256 of the timestamp and body.
+// Receivers should reject old timestamps to prevent replays.
+func signWebhook(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// fileSink appends each export to a CSV or JSONL file, for CRMs that
+// import files.
+type fileSink struct {
+	name   string
+	path   string
+	format string
+
+	mu sync.Mutex
+}
+
+var csvHeader = []string{
+	"event", "exported_at", "id", "name", "phone", "status",
+	"assigned_agent", "contact_count", "last_contact_at", "created_at",
+}
+
+func newFileSink(name, path, format string) *fileSink {
+	return &fileSink{name: name, path: path, format: format}
+}
+
+func (f *fileSink) Name() string { return f.name }
+
+// Export appends the lead to the file and returns its ID.
+func (f *fileSink) Export(ctx context.Context, lead *Lead, ref string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(f.path), 0o755); err != nil {
+		return "", fmt.Errorf("could not create directory: %w", err)
+	}
+
+	file, err := os.OpenFile(f.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return "", fmt.Errorf("could not open file: %w", err)
+	}
+	defer file.Close()
+
+	now := time.Now().UTC()
+	event := eventFor(ref)
+
+	switch f.format {
+	case fileFormatCSV:
+		err = f.writeCSV(file, event, now, lead)
+	default:
+		err = json.NewEncoder(file).Encode(leadEvent{Event: event, SentAt: now, Lead: lead})
+	}
+	if err != nil {
+		return "", fmt.Errorf("could not write lead: %w", err)
+	}
+
+	if err := file.Close(); err != nil {
+		return "", fmt.Errorf("could not close file: %w", err)
+	}
+	return lead.ID, nil
+}
+
+func (f *fileSink) writeCSV(file *os.File, event string, at time.Time, lead *Lead) error {
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	w := csv.NewWriter(file)
+
+	// The header is only written to a new file.
+	if info.Size() == 0 {
+		if err := w.Write(csvHeader); err != nil {
+			return err
+		}
+	}
+
+	var agent string
+	if lead.AssignedAgent != nil {
+		agent = *lead.AssignedAgent
+	}
+
+	if err := w.Write([]string{
+		event,
+		at.Format(time.RFC3339),
+		lead.ID,
+		lead.Name,
+		lead.Phone,
+		lead.Status,
+		agent,
+		strconv.Itoa(lead.ContactCount),
+		lead.LastContactAt.UTC().Format(time.RFC3339),
+		lead.CreatedAt.UTC().Format(time.RFC3339),
+	}); err != nil {
+		return err
+	}
+
+	w.Flush()
+	return w.Error()
+}
@@ -0,0 +1,459 @@
+package leads
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"encore.app/internal/pkg/trello"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testLead() *Lead {
+	at := time.Date(2024, 12, 20, 15, 4, 0, 0, time.UTC)
+	return &Lead{
+		ID:            "01JFK3ZQ8Y4B9W2N6V5T7X1C0D",
+		Name:          "Maria",
+		Phone:         "5579999990000",
+		Status:        StatusNew,
+		ContactCount:  1,
+		LastContactAt: at,
+		CreatedAt:     at,
+		UpdatedAt:     at,
+	}
+}
+
+// recordedRequest is a request received by a stand-in server.
+type recordedRequest struct {
+	Method string
+	Path   string
+	Header http.Header
+	Body   []byte
+}
+
+// standIn starts a server that records requests and answers
+// with the given status and body.
+func standIn(t *testing.T, status int, body string) (*httptest.Server, func() []recordedRequest) {
+	t.Helper()
+
+	var (
+		mu       sync.Mutex
+		requests []recordedRequest
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+
+		mu.Lock()
+		requests = append(requests, recordedRequest{
+			Method: r.Method,
+			Path:   r.URL.Path,
+			Header: r.Header.Clone(),
+			Body:   data,
+		})
+		mu.Unlock()
+
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, func() []recordedRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]recordedRequest(nil), requests...)
+	}
+}
+
+// trelloStandIn is a Trello board with a single card, card-1, which
+// already has the link of property AP001 attached.
+func trelloStandIn(t *testing.T, cardExists bool) (*trello.Client, func() []recordedRequest) {
+	t.Helper()
+
+	var (
+		mu       sync.Mutex
+		requests []recordedRequest
+	)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /cards", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"id":"card-2"}`)
+	})
+	mux.HandleFunc("PUT /cards/card-1", func(w http.ResponseWriter, r *http.Request) {
+		if !cardExists {
+			http.Error(w, "The requested resource was not found.", http.StatusNotFound)
+			return
+		}
+		io.WriteString(w, `{"id":"card-1"}`)
+	})
+	mux.HandleFunc("GET /cards/card-1", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"id":"card-1","attachments":[{"id":"a1","url":"https://imolink.test/properties/AP001"}]}`)
+	})
+	mux.HandleFunc("POST /cards/{id}/attachments", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"id":"a2"}`)
+	})
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+
+		mu.Lock()
+		requests = append(requests, recordedRequest{
+			Method: r.Method,
+			Path:   r.URL.Path,
+			Header: r.Header.Clone(),
+			Body:   data,
+		})
+		mu.Unlock()
+
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	client := trello.New("key", "token", srv.Client(), trello.WithBaseURL(srv.URL))
+	return client, func() []recordedRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]recordedRequest(nil), requests...)
+	}
+}
+
+func testPropertyURL(ref string) string {
+	return "https://imolink.test/properties/" + ref
+}
+
+func TestTrelloSink(t *testing.T) {
+	t.Parallel()
+
+	trelloConfig := SinkConfig{
+		Name:              "trello",
+		Type:              sinkTypeTrello,
+		TrelloListID:      "list-new",
+		TrelloStatusLists: map[string]string{StatusVisiting: "list-visiting"},
+	}
+
+	t.Run("creates a card on the first export", func(t *testing.T) {
+		t.Parallel()
+
+		client, requests := trelloStandIn(t, true)
+		sink := newTrelloSink(trelloConfig, client, testPropertyURL)
+
+		ref, err := sink.Export(context.Background(), testLead(), "")
+		require.NoError(t, err)
+		assert.Equal(t, "card-2", ref)
+
+		reqs := requests()
+		require.Len(t, reqs, 1)
+		assert.Equal(t, http.MethodPost, reqs[0].Method)
+		assert.Equal(t, "/cards", reqs[0].Path)
+
+		var card trello.NewCard
+		require.NoError(t, json.Unmarshal(reqs[0].Body, &card))
+		assert.Equal(t, "Maria", card.Name)
+		assert.Equal(t, "list-new", card.ListID)
+		assert.Contains(t, card.Description, "Telefone: 5579999990000")
+	})
+
+	t.Run("moves the card to the list of the status", func(t *testing.T) {
+		t.Parallel()
+
+		client, requests := trelloStandIn(t, true)
+		sink := newTrelloSink(trelloConfig, client, testPropertyURL)
+
+		lead := testLead()
+		lead.Status = StatusVisiting
+
+		ref, err := sink.Export(context.Background(), lead, "card-1")
+		require.NoError(t, err)
+		assert.Equal(t, "card-1", ref)
+
+		reqs := requests()
+		require.NotEmpty(t, reqs)
+		assert.Equal(t, http.MethodPut, reqs[0].Method)
+
+		var update trello.CardUpdate
+		require.NoError(t, json.Unmarshal(reqs[0].Body, &update))
+		require.NotNil(t, update.ListID)
+		assert.Equal(t, "list-visiting", *update.ListID)
+	})
+
+	t.Run("leaves cards of unmapped statuses in place", func(t *testing.T) {
+		t.Parallel()
+
+		client, requests := trelloStandIn(t, true)
+		sink := newTrelloSink(trelloConfig, client, testPropertyURL)
+
+		_, err := sink.Export(context.Background(), testLead(), "card-1")
+		require.NoError(t, err)
+
+		var update trello.CardUpdate
+		require.NoError(t, json.Unmarshal(requests()[0].Body, &update))
+		assert.Nil(t, update.ListID)
+	})
+
+	t.Run("attaches the properties not attached yet", func(t *testing.T) {
+		t.Parallel()
+
+		client, requests := trelloStandIn(t, true)
+		sink := newTrelloSink(trelloConfig, client, testPropertyURL)
+
+		lead := testLead()
+		lead.ShownProperties = []*ShownProperty{{Reference: "AP001"}, {Reference: "CA002"}}
+
+		_, err := sink.Export(context.Background(), lead, "card-1")
+		require.NoError(t, err)
+
+		var attached []string
+		for _, req := range requests() {
+			if req.Method == http.MethodPost && req.Path == "/cards/card-1/attachments" {
+				var body map[string]string
+				require.NoError(t, json.Unmarshal(req.Body, &body))
+				attached = append(attached, body["url"])
+			}
+		}
+		assert.Equal(t, []string{"https://imolink.test/properties/CA002"}, attached)
+	})
+
+	t.Run("recreates a card deleted from the board", func(t *testing.T) {
+		t.Parallel()
+
+		client, _ := trelloStandIn(t, false)
+		sink := newTrelloSink(trelloConfig, client, testPropertyURL)
+
+		ref, err := sink.Export(context.Background(), testLead(), "card-1")
+		require.NoError(t, err)
+		assert.Equal(t, "card-2", ref)
+	})
+
+	t.Run("fails on an error response", func(t *testing.T) {
+		t.Parallel()
+
+		srv, _ := standIn(t, http.StatusUnauthorized, "invalid token")
+		client := trello.New("key", "token", srv.Client(), trello.WithBaseURL(srv.URL))
+
+		_, err := newTrelloSink(trelloConfig, client, testPropertyURL).Export(context.Background(), testLead(), "")
+		assert.ErrorIs(t, err, trello.ErrUnauthorized)
+	})
+}
+
+func TestWebhookSink(t *testing.T) {
+	t.Parallel()
+
+	t.Run("posts signed lead events", func(t *testing.T) {
+		t.Parallel()
+
+		srv, requests := standIn(t, http.StatusAccepted, "")
+		sink := newWebhookSink("crm", srv.URL, "secret")
+
+		ref, err := sink.Export(context.Background(), testLead(), "")
+		require.NoError(t, err)
+		assert.Equal(t, testLead().ID, ref)
+
+		_, err = sink.Export(context.Background(), testLead(), ref)
+		require.NoError(t, err)
+
+		reqs := requests()
+		require.Len(t, reqs, 2)
+
+		for i, want := range []string{eventLeadCreated, eventLeadUpdated} {
+			req := reqs[i]
+			assert.Equal(t, "application/json", req.Header.Get("Content-Type"))
+
+			timestamp := req.Header.Get(webhookTimestampHeader)
+			require.NotEmpty(t, timestamp)
+			assert.Equal(t,
+				"sha256="+signWebhook([]byte("secret"), timestamp, req.Body),
+				req.Header.Get(webhookSignatureHeader),
+			)
+
+			var event leadEvent
+			require.NoError(t, json.Unmarshal(req.Body, &event))
+			assert.Equal(t, want, event.Event)
+			assert.Equal(t, "Maria", event.Lead.Name)
+		}
+	})
+
+	t.Run("signature depends on the secret", func(t *testing.T) {
+		t.Parallel()
+
+		body := []byte(`{"event":"lead.created"}`)
+		assert.NotEqual(t,
+			signWebhook([]byte("secret"), "1700000000", body),
+			signWebhook([]byte("other"), "1700000000", body),
+		)
+	})
+
+	t.Run("fails on a non 2xx response", func(t *testing.T) {
+		t.Parallel()
+
+		srv, _ := standIn(t, http.StatusInternalServerError, "boom")
+
+		_, err := newWebhookSink("crm", srv.URL, "secret").Export(context.Background(), testLead(), "")
+		assert.ErrorContains(t, err, "status 500")
+	})
+}
+
+func TestFileSink(t *testing.T) {
+	t.Parallel()
+
+	t.Run("jsonl", func(t *testing.T) {
+		t.Parallel()
+
+		path := filepath.Join(t.TempDir(), "exports", "leads.jsonl")
+		sink := newFileSink("file", path, fileFormatJSONL)
+
+		ref, err := sink.Export(context.Background(), testLead(), "")
+		require.NoError(t, err)
+
+		_, err = sink.Export(context.Background(), testLead(), ref)
+		require.NoError(t, err)
+
+		f, err := os.Open(path)
+		require.NoError(t, err)
+		defer f.Close()
+
+		dec := json.NewDecoder(f)
+		for _, want := range []string{eventLeadCreated, eventLeadUpdated} {
+			var event leadEvent
+			require.NoError(t, dec.Decode(&event))
+			assert.Equal(t, want, event.Event)
+			assert.Equal(t, testLead().ID, event.Lead.ID)
+		}
+	})
+
+	t.Run("csv writes the header once", func(t *testing.T) {
+		t.Parallel()
+
+		path := filepath.Join(t.TempDir(), "leads.csv")
+		sink := newFileSink("file", path, fileFormatCSV)
+
+		for range 2 {
+			_, err := sink.Export(context.Background(), testLead(), "")
+			require.NoError(t, err)
+		}
+
+		f, err := os.Open(path)
+		require.NoError(t, err)
+		defer f.Close()
+
+		records, err := csv.NewReader(f).ReadAll()
+		require.NoError(t, err)
+		require.Len(t, records, 3)
+		assert.Equal(t, csvHeader, records[0])
+		assert.Equal(t, "Maria", records[1][3])
+	})
+}
+
+func TestNewSinks(t *testing.T) {
+	t.Parallel()
+
+	deps := sinkDeps{
+		trello:      trello.New("key", "token", http.DefaultClient),
+		propertyURL: testPropertyURL,
+	}
+
+	tests := map[string]struct {
+		configs []SinkConfig
+		secret  string
+		wantErr string
+	}{
+		"several sinks": {
+			configs: []SinkConfig{
+				{Name: "trello", Type: sinkTypeTrello, TrelloListID: "list-1"},
+				{Name: "crm", Type: sinkTypeWebhook, WebhookURL: "http://crm.local/leads"},
+				{Name: "export", Type: sinkTypeFile, FilePath: "leads.csv", FileFormat: fileFormatCSV},
+			},
+			secret: "secret",
+		},
+		"duplicate name": {
+			configs: []SinkConfig{
+				{Name: "trello", Type: sinkTypeTrello, TrelloListID: "list-1"},
+				{Name: "trello", Type: sinkTypeTrello, TrelloListID: "list-2"},
+			},
+			wantErr: "duplicate sink name",
+		},
+		"invalid status list": {
+			configs: []SinkConfig{{
+				Name:              "trello",
+				Type:              sinkTypeTrello,
+				TrelloListID:      "list-1",
+				TrelloStatusLists: map[string]string{"archived": "list-2"},
+			}},
+			wantErr: "invalid status",
+		},
+		"list mapped to several statuses": {
+			configs: []SinkConfig{{
+				Name:         "trello",
+				Type:         sinkTypeTrello,
+				TrelloListID: "list-1",
+				TrelloStatusLists: map[string]string{
+					StatusWon:  "list-closed",
+					StatusLost: "list-closed",
+				},
+			}},
+			wantErr: "several statuses",
+		},
+		"unknown type": {
+			configs: []SinkConfig{{Name: "crm", Type: "salesforce"}},
+			wantErr: "unknown type",
+		},
+		"webhook without secret": {
+			configs: []SinkConfig{{Name: "crm", Type: sinkTypeWebhook, WebhookURL: "http://crm.local"}},
+			wantErr: "CRMWebhookSecret",
+		},
+		"unknown file format": {
+			configs: []SinkConfig{{Name: "export", Type: sinkTypeFile, FilePath: "leads.xml", FileFormat: "xml"}},
+			wantErr: "unknown file format",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			deps := deps
+			deps.webhookSecret = tc.secret
+
+			sinks, err := newSinks(tc.configs, deps)
+			if tc.wantErr != "" {
+				assert.ErrorContains(t, err, tc.wantErr)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Len(t, sinks, len(tc.configs))
+			for i, c := range tc.configs {
+				assert.Equal(t, c.Name, sinks[i].Name())
+			}
+		})
+	}
+}
+
+func TestTrelloSinkStatusForList(t *testing.T) {
+	t.Parallel()
+
+	sink := newTrelloSink(SinkConfig{
+		Name:         "trello",
+		TrelloListID: "list-new",
+		TrelloStatusLists: map[string]string{
+			StatusVisiting: "list-visiting",
+			StatusLost:     "list-lost",
+		},
+	}, nil, testPropertyURL)
+
+	status, ok := sink.statusForList("list-lost")
+	assert.True(t, ok)
+	assert.Equal(t, StatusLost, status)
+
+	_, ok = sink.statusForList("list-new")
+	assert.False(t, ok)
+}
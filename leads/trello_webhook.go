@@ -0,0 +1,200 @@
+package leads
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"encore.app/internal/pkg/apierror"
+	"encore.app/internal/pkg/authz"
+	"encore.app/internal/pkg/trello"
+
+	"encore.dev"
+	"encore.dev/beta/errs"
+	"encore.dev/rlog"
+	"encore.dev/storage/sqldb"
+)
+
+const (
+	trelloWebhookPath = "/leads/trello/webhook/"
+
+	// maxTrelloWebhookBody caps the size of a callback body.
+	maxTrelloWebhookBody = 1 << 20
+)
+
+// TrelloWebhook receives the changes made on the board of a Trello sink.
+// When an agent moves a card to a list mapped to a status, the status of
+// its lead changes too.
+//
+//encore:api public raw method=HEAD,POST path=/leads/trello/webhook/:sink
+func (s *Service) TrelloWebhook(w http.ResponseWriter, req *http.Request) {
+	// Trello checks the callback URL with a HEAD request when the
+	// webhook is created.
+	if req.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	name := strings.TrimPrefix(req.URL.Path, trelloWebhookPath)
+
+	sink, ok := s.sink(name).(*trelloSink)
+	if !ok {
+		http.Error(w, "Trello sink not found", http.StatusNotFound)
+		return
+	}
+
+	if secrets.TrelloAPISecret == "" {
+		rlog.Error("Trello webhook called without TrelloAPISecret set", "sink", name)
+		http.Error(w, "Trello webhook is not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(req.Body, maxTrelloWebhookBody))
+	if err != nil {
+		http.Error(w, "could not read body", http.StatusBadRequest)
+		return
+	}
+
+	if !trello.VerifyWebhook(
+		secrets.TrelloAPISecret,
+		body,
+		trelloWebhookURL(name),
+		req.Header.Get(trello.SignatureHeader),
+	) {
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	var event trello.WebhookEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		http.Error(w, "invalid event", http.StatusBadRequest)
+		return
+	}
+
+	// Trello retries callbacks that fail.
+	if err := s.applyTrelloAction(req.Context(), sink, &event.Action); err != nil {
+		rlog.Error("could not apply Trello action", "sink", name, "action", event.Action.ID, "error", err)
+		http.Error(w, "could not apply action", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// applyTrelloAction updates the status of the lead whose card moved to a
+// list mapped to a status. Other actions are ignored.
+func (s *Service) applyTrelloAction(ctx context.Context, sink *trelloSink, action *trello.Action) error {
+	data := action.Data
+	if action.Type != "updateCard" || data.Card == nil || data.ListAfter == nil {
+		return nil
+	}
+
+	status, ok := sink.statusForList(data.ListAfter.ID)
+	if !ok {
+		return nil
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Nothing changes for a card of no lead, or a lead already in the
+	// status, which is the case when the move was made by the sink itself.
+	var leadID string
+	if err := tx.QueryRow(ctx, `
+		UPDATE leads SET
+			status = $3, status_changed_at = NOW(), updated_at = NOW()
+		WHERE id = (
+			SELECT lead_id FROM lead_crm_refs
+			WHERE sink = $1 AND external_id = $2
+		) AND status <> $3
+		RETURNING id
+	`, sink.Name(), data.Card.ID, status).Scan(&leadID); err != nil {
+		if errors.Is(err, sqldb.ErrNoRows) {
+			return nil
+		}
+		return fmt.Errorf("could not update lead status: %w", err)
+	}
+
+	// The other sinks learn about the new status too.
+	if err := s.enqueueExports(ctx, tx, leadID); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit transaction: %w", err)
+	}
+
+	rlog.Info("lead status changed on Trello",
+		"lead_id", leadID,
+		"status", status,
+		"member", action.MemberCreator.FullName,
+	)
+	s.wakeOutbox()
+	return nil
+}
+
+// RegisterTrelloWebhooks creates the webhook of every Trello sink with a
+// board ID. Webhooks that already exist are left as they are, so it is
+// safe to call again, for instance after adding a sink.
+//
+//encore:api auth method=POST path=/leads/trello/webhooks
+func (s *Service) RegisterTrelloWebhooks(ctx context.Context) (*TrelloWebhooks, error) {
+	if err := authz.Require(authz.ScopeLeads, authz.RoleAdmin); err != nil {
+		return nil, err
+	}
+
+	var existing []*trello.Webhook
+	webhooks := TrelloWebhooks{Webhooks: make([]*TrelloWebhook, 0)}
+
+	for _, sink := range s.sinks {
+		t, ok := sink.(*trelloSink)
+		if !ok || t.boardID == "" {
+			continue
+		}
+
+		// Every Trello sink shares the same credentials.
+		if existing == nil {
+			var err error
+			if existing, err = t.api.ListWebhooks(ctx); err != nil {
+				return nil, apierror.E("could not list Trello webhooks", err, errs.Unavailable)
+			}
+		}
+
+		webhook := TrelloWebhook{
+			Sink:        t.Name(),
+			BoardID:     t.boardID,
+			CallbackURL: trelloWebhookURL(t.Name()),
+		}
+
+		for _, w := range existing {
+			if w.ModelID == webhook.BoardID && w.CallbackURL == webhook.CallbackURL {
+				webhook.WebhookID = w.ID
+			}
+		}
+
+		if webhook.WebhookID == "" {
+			created, err := t.api.CreateWebhook(ctx, webhook.CallbackURL, webhook.BoardID, "Imolink leads: "+t.Name())
+			if err != nil {
+				return nil, apierror.E("could not create Trello webhook", err, errs.Unavailable)
+			}
+			webhook.WebhookID = created.ID
+			webhook.Created = true
+		}
+		webhooks.Webhooks = append(webhooks.Webhooks, &webhook)
+	}
+	return &webhooks, nil
+}
+
+// trelloWebhookURL is the callback URL of the webhook of the sink.
+// Callbacks are signed with it, so it must not change once registered.
+func trelloWebhookURL(sink string) string {
+	base := encore.Meta().APIBaseURL
+	return fmt.Sprintf("%s://%s%s%s", base.Scheme, base.Host, trelloWebhookPath, url.PathEscape(sink))
+}
@@ -0,0 +1,50 @@
+package leads
+
+import (
+	"context"
+	"testing"
+
+	"encore.app/internal/pkg/trello"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyTrelloActionIgnoresOtherActions(t *testing.T) {
+	t.Parallel()
+
+	sink := newTrelloSink(SinkConfig{
+		Name:              "trello",
+		TrelloListID:      "list-new",
+		TrelloStatusLists: map[string]string{StatusLost: "list-lost"},
+	}, nil, testPropertyURL)
+
+	card := &trello.ActionRef{ID: "card-1"}
+
+	// None of these reach the database.
+	tests := map[string]trello.Action{
+		"comment": {
+			Type: "commentCard",
+			Data: trello.ActionData{Card: card},
+		},
+		"card renamed": {
+			Type: "updateCard",
+			Data: trello.ActionData{Card: card},
+		},
+		"card moved to an unmapped list": {
+			Type: "updateCard",
+			Data: trello.ActionData{
+				Card:       card,
+				ListBefore: &trello.ActionRef{ID: "list-lost"},
+				ListAfter:  &trello.ActionRef{ID: "list-new"},
+			},
+		},
+	}
+
+	s := &Service{}
+	for name, action := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			assert.NoError(t, s.applyTrelloAction(context.Background(), sink, &action))
+		})
+	}
+}
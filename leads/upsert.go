@@ -0,0 +1,107 @@
+package leads
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"encore.app/internal/pkg/idutil"
+
+	"encore.dev/storage/sqldb"
+)
+
+// errNoPhone is returned by upsertLead for a phone number without digits.
+var errNoPhone = errors.New("phone number has no digits")
+
+// upsertLead records a contact at the given time from the lead with the
+// normalized phone number, creating the lead on its first contact, and
+// returns the lead ID. A different name renames the lead and is kept in
+// its name history.
+func upsertLead(ctx context.Context, tx *sqldb.Tx, name, phone string, at time.Time) (string, error) {
+	phone = normalizePhone(phone)
+	if phone == "" {
+		return "", errNoPhone
+	}
+
+	id, oldName, err := lockLeadByPhone(ctx, tx, phone)
+	if err != nil {
+		return "", err
+	}
+
+	created := false
+	if id == "" {
+		if id, err = idutil.NewID(); err != nil {
+			return "", fmt.Errorf("could not generate ID: %w", err)
+		}
+
+		result, err := tx.Exec(ctx, `
+			INSERT INTO leads (
+				id, name, phone, status_changed_at,
+				last_contact_at, created_at, updated_at
+			) VALUES ($1, $2, $3, $4, $4, $4, $4)
+			ON CONFLICT (phone) DO NOTHING
+		`, id, name, phone, at)
+		if err != nil {
+			return "", fmt.Errorf("could not insert lead: %w", err)
+		}
+
+		// Another contact from the same phone may have created the lead
+		// first, in which case this one counts as a contact of that lead.
+		created = result.RowsAffected() > 0
+		if created {
+			oldName = name
+		} else if id, oldName, err = lockLeadByPhone(ctx, tx, phone); err != nil {
+			return "", err
+		}
+	}
+
+	if !created {
+		if _, err := tx.Exec(ctx, `
+			UPDATE leads SET
+				contact_count = contact_count + 1,
+				last_contact_at = GREATEST(last_contact_at, $2),
+				updated_at = NOW()
+			WHERE id = $1
+		`, id, at); err != nil {
+			return "", fmt.Errorf("could not update lead contact: %w", err)
+		}
+	}
+
+	if name != "" && name != oldName {
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO lead_name_history (lead_id, old_name, new_name, changed_at)
+			VALUES ($1, $2, $3, $4)
+		`, id, oldName, name, at); err != nil {
+			return "", fmt.Errorf("could not record name change: %w", err)
+		}
+
+		if _, err := tx.Exec(ctx, `UPDATE leads SET name = $2 WHERE id = $1`, id, name); err != nil {
+			return "", fmt.Errorf("could not rename lead: %w", err)
+		}
+	}
+
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO lead_contacts (lead_id, name, contacted_at)
+		VALUES ($1, $2, $3)
+	`, id, name, at); err != nil {
+		return "", fmt.Errorf("could not record contact: %w", err)
+	}
+
+	return id, nil
+}
+
+// lockLeadByPhone returns the ID and name of the lead with the phone, locking
+// it until the transaction ends. The ID is empty if there is no such lead.
+func lockLeadByPhone(ctx context.Context, tx *sqldb.Tx, phone string) (string, string, error) {
+	var id, name string
+	if err := tx.QueryRow(ctx, `
+		SELECT id, name FROM leads WHERE phone = $1 FOR UPDATE
+	`, phone).Scan(&id, &name); err != nil {
+		if errors.Is(err, sqldb.ErrNoRows) {
+			return "", "", nil
+		}
+		return "", "", fmt.Errorf("could not fetch lead by phone: %w", err)
+	}
+	return id, name, nil
+}
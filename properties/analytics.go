@@ -0,0 +1,307 @@
+package properties
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"encore.app/internal/pkg/apierror"
+	"encore.app/internal/pkg/authz"
+
+	"encore.dev/beta/errs"
+)
+
+const (
+	// brTimeZone is the time zone views are counted per day in.
+	brTimeZone = "America/Sao_Paulo"
+
+	defaultAnalyticsPeriod = 30 * 24 * time.Hour
+
+	// chatParam attributes a property page view to the chat the link was
+	// sent in, through a token from SignChat.
+	chatParam  = "chat"
+	maxChatLen = 64
+	// chatMACSize is the number of bytes kept of the MACs of chat tokens.
+	chatMACSize = 16
+)
+
+// SignChat returns the token the links sent in a chat carry, so the views
+// of property pages are attributed to the chat without revealing it.
+//
+//encore:api private method=POST path=/properties/chat-tokens
+func (s *Service) SignChat(ctx context.Context, in *SignChatInput) (*ChatToken, error) {
+	if in.Chat == "" || len(in.Chat) > maxChatLen {
+		return nil, &errs.Error{
+			Code:    errs.InvalidArgument,
+			Message: "invalid chat",
+		}
+	}
+
+	if secrets.ShareLinkSecret == "" {
+		return nil, &errs.Error{
+			Code:    errs.FailedPrecondition,
+			Message: "chat tokens are not configured",
+		}
+	}
+	return &ChatToken{Token: chatToken(secrets.ShareLinkSecret, in.Chat)}, nil
+}
+
+// RecordRecommendations records the properties the chatbot recommended in
+// a chat, for the click-through rate. Unknown references are ignored, and
+// the chat is stored as the ID its tokens carry. Nothing is recorded while
+// chat tokens are not configured.
+//
+//encore:api private method=POST path=/properties/recommendations
+func (s *Service) RecordRecommendations(ctx context.Context, in *RecordRecommendationsInput) error {
+	if in.Chat == "" || len(in.Chat) > maxChatLen {
+		return &errs.Error{
+			Code:    errs.InvalidArgument,
+			Message: "invalid chat",
+		}
+	}
+
+	if len(in.References) == 0 || secrets.ShareLinkSecret == "" {
+		return nil
+	}
+
+	if _, err := db.Exec(ctx, `
+		INSERT INTO property_recommendations (property_id, chat)
+		SELECT id, $1 FROM properties WHERE reference = ANY($2)
+	`, chatID(secrets.ShareLinkSecret, in.Chat), in.References); err != nil {
+		return apierror.E("could not record recommendations", err, errs.Internal)
+	}
+	return nil
+}
+
+// ViewsPerProperty counts the page views of each property.
+//
+//encore:api auth method=GET path=/properties/analytics/views
+func (s *Service) ViewsPerProperty(ctx context.Context, in AnalyticsInput) (*ViewsByProperty, error) {
+	if err := authz.Require(authz.ScopeProperties, authz.RoleReadOnly); err != nil {
+		return nil, err
+	}
+
+	from, to, err := in.period()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(ctx, `
+		SELECT v.property_id, p.reference, COUNT(*),
+			COUNT(DISTINCT COALESCE(v.lead_id, v.chat)), MAX(v.viewed_at)
+		FROM property_views v
+		JOIN properties p ON p.id = v.property_id
+		WHERE v.viewed_at >= $1 AND v.viewed_at < $2 AND ($3 = '' OR v.property_id = $3)
+		GROUP BY v.property_id, p.reference
+		ORDER BY COUNT(*) DESC, p.reference
+	`, from, to, in.PropertyID)
+	if err != nil {
+		return nil, apierror.E("could not count views", err, errs.Internal)
+	}
+	defer rows.Close()
+
+	views := ViewsByProperty{Properties: make([]*PropertyViews, 0)}
+	for rows.Next() {
+		var v PropertyViews
+		if err := rows.Scan(&v.PropertyID, &v.Reference, &v.Views, &v.Viewers, &v.LastViewedAt); err != nil {
+			return nil, apierror.E("could not scan views", err, errs.Internal)
+		}
+		views.Properties = append(views.Properties, &v)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, apierror.E("could not count views", err, errs.Internal)
+	}
+	return &views, nil
+}
+
+// ViewsPerDay counts the page views of each day.
+//
+//encore:api auth method=GET path=/properties/analytics/views/daily
+func (s *Service) ViewsPerDay(ctx context.Context, in AnalyticsInput) (*ViewsByDay, error) {
+	if err := authz.Require(authz.ScopeProperties, authz.RoleReadOnly); err != nil {
+		return nil, err
+	}
+
+	from, to, err := in.period()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(ctx, `
+		SELECT TO_CHAR(viewed_at AT TIME ZONE '`+brTimeZone+`', 'YYYY-MM-DD') AS day, COUNT(*)
+		FROM property_views
+		WHERE viewed_at >= $1 AND viewed_at < $2 AND ($3 = '' OR property_id = $3)
+		GROUP BY day
+		ORDER BY day
+	`, from, to, in.PropertyID)
+	if err != nil {
+		return nil, apierror.E("could not count views", err, errs.Internal)
+	}
+	defer rows.Close()
+
+	views := ViewsByDay{Days: make([]*DayViews, 0)}
+	for rows.Next() {
+		var d DayViews
+		if err := rows.Scan(&d.Day, &d.Views); err != nil {
+			return nil, apierror.E("could not scan views", err, errs.Internal)
+		}
+		views.Days = append(views.Days, &d)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, apierror.E("could not count views", err, errs.Internal)
+	}
+	return &views, nil
+}
+
+// ViewsPerLead counts the page views of each lead, from the share links
+// tied to them.
+//
+//encore:api auth method=GET path=/properties/analytics/views/leads
+func (s *Service) ViewsPerLead(ctx context.Context, in AnalyticsInput) (*ViewsByLead, error) {
+	if err := authz.Require(authz.ScopeProperties, authz.RoleReadOnly); err != nil {
+		return nil, err
+	}
+
+	from, to, err := in.period()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(ctx, `
+		SELECT lead_id, COUNT(*), COUNT(DISTINCT property_id), MAX(viewed_at)
+		FROM property_views
+		WHERE lead_id IS NOT NULL
+			AND viewed_at >= $1 AND viewed_at < $2 AND ($3 = '' OR property_id = $3)
+		GROUP BY lead_id
+		ORDER BY MAX(viewed_at) DESC
+		LIMIT $4
+	`, from, to, in.PropertyID, maxListLimit)
+	if err != nil {
+		return nil, apierror.E("could not count views", err, errs.Internal)
+	}
+	defer rows.Close()
+
+	views := ViewsByLead{Leads: make([]*LeadViews, 0)}
+	for rows.Next() {
+		var l LeadViews
+		if err := rows.Scan(&l.LeadID, &l.Views, &l.Properties, &l.LastViewedAt); err != nil {
+			return nil, apierror.E("could not scan views", err, errs.Internal)
+		}
+		views.Leads = append(views.Leads, &l)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, apierror.E("could not count views", err, errs.Internal)
+	}
+	return &views, nil
+}
+
+// ClickThroughRate returns the share of the properties recommended by the
+// chatbot whose page was then opened from the same chat.
+//
+//encore:api auth method=GET path=/properties/analytics/click-through
+func (s *Service) ClickThroughRate(ctx context.Context, in AnalyticsInput) (*ClickThrough, error) {
+	if err := authz.Require(authz.ScopeProperties, authz.RoleReadOnly); err != nil {
+		return nil, err
+	}
+
+	from, to, err := in.period()
+	if err != nil {
+		return nil, err
+	}
+
+	var ctr ClickThrough
+	if err := db.QueryRow(ctx, `
+		WITH recommended AS (
+			SELECT chat, property_id, MIN(recommended_at) AS first_at
+			FROM property_recommendations
+			WHERE recommended_at >= $1 AND recommended_at < $2 AND ($3 = '' OR property_id = $3)
+			GROUP BY chat, property_id
+		)
+		SELECT COUNT(*), COUNT(*) FILTER (WHERE EXISTS (
+			SELECT 1 FROM property_views v
+			WHERE v.chat = r.chat AND v.property_id = r.property_id AND v.viewed_at >= r.first_at
+		))
+		FROM recommended r
+	`, from, to, in.PropertyID).Scan(&ctr.Recommendations, &ctr.Clicked); err != nil {
+		return nil, apierror.E("could not compute click-through rate", err, errs.Internal)
+	}
+
+	if ctr.Recommendations > 0 {
+		ctr.Rate = float64(ctr.Clicked) / float64(ctr.Recommendations)
+	}
+	return &ctr, nil
+}
+
+// period returns the bounds of the period, defaulting to the last 30 days.
+func (in AnalyticsInput) period() (from, to time.Time, err error) {
+	to = in.To
+	if to.IsZero() {
+		to = time.Now()
+	}
+
+	from = in.From
+	if from.IsZero() {
+		from = to.Add(-defaultAnalyticsPeriod)
+	}
+
+	if !from.Before(to) {
+		return time.Time{}, time.Time{}, &errs.Error{
+			Code:    errs.InvalidArgument,
+			Message: "from must be before to",
+		}
+	}
+	return from, to, nil
+}
+
+// recordView records a view of the page of a property. The lead comes
+// from a valid share link and the chat from the link the chatbot sent.
+func recordView(ctx context.Context, propertyID, leadID, chat, userAgent string) error {
+	if _, err := db.Exec(ctx, `
+		INSERT INTO property_views (property_id, lead_id, chat, user_agent)
+		VALUES ($1, NULLIF($2, ''), NULLIF($3, ''), $4)
+	`, propertyID, leadID, chat, userAgent); err != nil {
+		return fmt.Errorf("could not insert view: %w", err)
+	}
+	return nil
+}
+
+// chatToken returns the ID of the chat followed by its signature. The ID
+// is derived from the chat, so all the links of a chat share it.
+func chatToken(secret, chat string) string {
+	id := chatID(secret, chat)
+	return id + "." + chatMAC(secret, "chat:"+id)
+}
+
+// chatID is the opaque ID views and recommendations of a chat are stored
+// with.
+func chatID(secret, chat string) string {
+	return chatMAC(secret, "chat-id:"+chat)
+}
+
+func chatMAC(secret, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:chatMACSize])
+}
+
+// viewChat returns the ID of the chat a page view is attributed to, or ""
+// if the token is missing or was not signed by SignChat.
+func viewChat(secret, token string) string {
+	// An unset secret would let anyone sign tokens.
+	if secret == "" {
+		return ""
+	}
+
+	id, sig, ok := strings.Cut(strings.TrimSpace(token), ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(chatMAC(secret, "chat:"+id))) {
+		return ""
+	}
+	return id
+}
@@ -0,0 +1,57 @@
+package properties
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChatToken(t *testing.T) {
+	t.Parallel()
+
+	const (
+		secret = "share-secret"
+		phone  = "5579999999999"
+	)
+
+	token := chatToken(secret, phone)
+	id := chatID(secret, phone)
+
+	t.Run("hides the chat", func(t *testing.T) {
+		t.Parallel()
+
+		assert.NotContains(t, token, phone)
+		assert.LessOrEqual(t, len(token), maxChatLen)
+		assert.Equal(t, token, chatToken(secret, phone))
+		assert.NotEqual(t, id, chatID(secret, "5579888888888"))
+	})
+
+	t.Run("views carry the ID of valid tokens", func(t *testing.T) {
+		t.Parallel()
+
+		assert.Equal(t, id, viewChat(secret, token))
+		assert.Equal(t, id, viewChat(secret, " "+token+" "))
+	})
+
+	t.Run("rejects tokens not signed by SignChat", func(t *testing.T) {
+		t.Parallel()
+
+		otherID := chatID(secret, "5579888888888")
+		_, sig, _ := strings.Cut(token, ".")
+
+		for name, tc := range map[string]struct{ secret, token string }{
+			"missing token":  {secret, ""},
+			"phone number":   {secret, phone},
+			"missing sig":    {secret, id},
+			"empty sig":      {secret, id + "."},
+			"swapped ID":     {secret, otherID + "." + sig},
+			"other secret":   {secret, chatToken("other-secret", phone)},
+			"unset secret":   {"", chatToken("", phone)},
+			"tampered sig":   {secret, token + "x"},
+			"extra segments": {secret, token + ".x"},
+		} {
+			assert.Empty(t, viewChat(tc.secret, tc.token), name)
+		}
+	})
+}
@@ -0,0 +1,6 @@
+MediaBackend: *"bucket" | "filesystem"
+MediaDir: ".media"
+
+if #Meta.Environment.Cloud == "local" {
+	MediaBackend: "filesystem"
+}
@@ -0,0 +1,14 @@
+package properties
+
+import "encore.dev/config"
+
+// Config is the properties service configuration, loaded from config.cue.
+type Config struct {
+	// MediaBackend is where property media is stored:
+	// "bucket" for object storage or "filesystem" for local development.
+	MediaBackend string
+	// MediaDir is the directory used by the filesystem backend.
+	MediaDir string
+}
+
+var cfg = config.Load[*Config]()
@@ -0,0 +1,40 @@
+package properties
+
+import (
+	"context"
+	"fmt"
+
+	"encore.dev/pubsub"
+)
+
+// Kinds of property changes published on PropertyChanges.
+const (
+	ChangeCreated = "created"
+	ChangeUpdated = "updated"
+	ChangeDeleted = "deleted"
+)
+
+// PropertyChangedEvent is published whenever a property is created, updated or deleted.
+type PropertyChangedEvent struct {
+	Kind       string `json:"kind"`
+	PropertyID string `json:"propertyId" pubsub-attr:"property_id"`
+	Reference  string `json:"reference"`
+}
+
+// PropertyChanges carries every change made to the properties table.
+// Events for the same property are delivered in order.
+var PropertyChanges = pubsub.NewTopic[*PropertyChangedEvent]("property-changes", pubsub.TopicConfig{
+	DeliveryGuarantee: pubsub.AtLeastOnce,
+	OrderingAttribute: "property_id",
+})
+
+func publishChange(ctx context.Context, kind, id, ref string) error {
+	if _, err := PropertyChanges.Publish(ctx, &PropertyChangedEvent{
+		Kind:       kind,
+		PropertyID: id,
+		Reference:  ref,
+	}); err != nil {
+		return fmt.Errorf("could not publish %s event for property %s: %w", kind, id, err)
+	}
+	return nil
+}
@@ -0,0 +1,706 @@
+package properties
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"encore.app/internal/pkg/apierror"
+	"encore.app/internal/pkg/authz"
+	"encore.app/internal/pkg/idutil"
+	"encore.app/internal/pkg/imaging"
+
+	"encore.dev"
+	"encore.dev/beta/errs"
+	"encore.dev/rlog"
+	"encore.dev/storage/sqldb"
+)
+
+const (
+	// maxMediaBytes caps the size of a single uploaded image.
+	maxMediaBytes = 10 << 20
+	// maxBatchImages caps how many images UploadMediaBatch accepts at once.
+	maxBatchImages = 30
+
+	// Derivative sizes, as the longest side in pixels.
+	thumbnailSize = 320
+	mediumSize    = 1280
+
+	mediaPathPrefix = "/properties/media/"
+
+	// legacyMigrationBatch is how many properties are moved to
+	// the media store per query by migrateLegacyMedia.
+	legacyMigrationBatch = 20
+	mediaBackfillTimeout = 30 * time.Minute
+	// mediaBackfillLockKey is the advisory lock that keeps instances
+	// from backfilling media at the same time.
+	mediaBackfillLockKey = 7_341_201
+
+	// contentTypeJPEG is the content type of derivatives.
+	contentTypeJPEG = "image/jpeg"
+)
+
+var (
+	errUnsupportedMedia = errors.New("media must be a JPEG, PNG, GIF or WebP image")
+	errMediaTooLarge    = fmt.Errorf("media must have at most %d pixels", imaging.MaxPixels)
+)
+
+// imagingError turns the errors of the images sent by the client into
+// errUnsupportedMedia or errMediaTooLarge.
+func imagingError(action string, err error) error {
+	switch {
+	case errors.Is(err, imaging.ErrUnsupportedFormat):
+		return fmt.Errorf("%w: %v", errUnsupportedMedia, err)
+	case errors.Is(err, imaging.ErrTooLarge):
+		return fmt.Errorf("%w: %v", errMediaTooLarge, err)
+	}
+	return fmt.Errorf("could not %s: %w", action, err)
+}
+
+//encore:api auth method=POST path=/properties/:id/media
+func (s *Service) UploadMedia(ctx context.Context, id string, in *UploadMediaInput) (*Media, error) {
+	if err := authz.Require(authz.ScopeProperties, authz.RoleAgent); err != nil {
+		return nil, err
+	}
+
+	list, err := s.UploadMediaBatch(ctx, id, &UploadMediaBatchInput{
+		Images: []*UploadMediaInput{in},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return list.Media[0], nil
+}
+
+// UploadMediaBatch attaches several images to a property, in order.
+// Images are stored one by one, so the ones before a failing image are kept.
+//
+//encore:api auth method=POST path=/properties/:id/media/batch
+func (s *Service) UploadMediaBatch(ctx context.Context, id string, in *UploadMediaBatchInput) (*MediaList, error) {
+	if err := authz.Require(authz.ScopeProperties, authz.RoleAgent); err != nil {
+		return nil, err
+	}
+
+	if len(in.Images) == 0 || len(in.Images) > maxBatchImages {
+		return nil, &errs.Error{
+			Code:    errs.InvalidArgument,
+			Message: fmt.Sprintf("between 1 and %d images are required", maxBatchImages),
+		}
+	}
+
+	for i, img := range in.Images {
+		if err := img.validate(); err != nil {
+			return nil, &errs.Error{
+				Code:    errs.InvalidArgument,
+				Message: fmt.Sprintf("image %d: %v", i, err),
+			}
+		}
+	}
+
+	exists, err := propertyExists(ctx, id)
+	if err != nil {
+		return nil, apierror.E("could not check property existence", err, errs.Internal)
+	}
+
+	if !exists {
+		return nil, &errs.Error{
+			Code:    errs.NotFound,
+			Message: "property not found",
+		}
+	}
+
+	list := MediaList{Media: make([]*Media, 0, len(in.Images))}
+	for i, img := range in.Images {
+		m, err := s.storeMedia(ctx, id, img.Kind, img.Caption, img.Position, img.Data)
+		if err != nil {
+			if errors.Is(err, errUnsupportedMedia) || errors.Is(err, errMediaTooLarge) {
+				return nil, &errs.Error{
+					Code:    errs.InvalidArgument,
+					Message: fmt.Sprintf("image %d: %v", i, err),
+				}
+			}
+			return nil, apierror.E("could not store media", err, errs.Internal)
+		}
+		list.Media = append(list.Media, m)
+	}
+	return &list, nil
+}
+
+// ListMedia lists the media of a property. Like Get, it treats off-market
+// properties as missing unless the caller is authenticated.
+//
+//encore:api public method=GET path=/properties/id/:id/media
+func (s *Service) ListMedia(ctx context.Context, id string) (*MediaList, error) {
+	var offMarket bool
+	if err := db.QueryRow(ctx, `
+		SELECT off_market FROM properties WHERE id = $1
+	`, id).Scan(&offMarket); err != nil && !errors.Is(err, sqldb.ErrNoRows) {
+		return nil, apierror.E("could not fetch property", err, errs.Internal)
+	} else if err != nil || (offMarket && !canViewOffMarket()) {
+		return nil, &errs.Error{
+			Code:    errs.NotFound,
+			Message: "property not found",
+		}
+	}
+
+	media, err := listMedia(ctx, id)
+	if err != nil {
+		return nil, apierror.E("could not list media", err, errs.Internal)
+	}
+
+	list := MediaList{Media: media[id]}
+	if list.Media == nil {
+		list.Media = make([]*Media, 0)
+	}
+	return &list, nil
+}
+
+//encore:api auth method=DELETE path=/properties/:id/media/:mediaID
+func (s *Service) DeleteMedia(ctx context.Context, id, mediaID string) error {
+	if err := authz.Require(authz.ScopeProperties, authz.RoleAgent); err != nil {
+		return err
+	}
+
+	var (
+		key                  string
+		thumbnailKey, medKey *string
+	)
+	if err := db.QueryRow(ctx, `
+		DELETE FROM property_media WHERE id = $1 AND property_id = $2
+		RETURNING object_key, thumbnail_key, medium_key
+	`, mediaID, id).Scan(&key, &thumbnailKey, &medKey); err != nil {
+		if errors.Is(err, sqldb.ErrNoRows) {
+			return &errs.Error{
+				Code:    errs.NotFound,
+				Message: "media not found",
+			}
+		}
+		return apierror.E("could not delete media", err, errs.Internal)
+	}
+
+	keys := []string{key}
+	for _, k := range []*string{thumbnailKey, medKey} {
+		if k != nil {
+			keys = append(keys, *k)
+		}
+	}
+
+	s.removeMediaObjects(ctx, keys)
+	return nil
+}
+
+// GetMediaContent returns the binary content of a media, for services
+// that need to send the image itself rather than a link to it.
+//
+//encore:api private method=GET path=/properties/media-content/:id
+func (s *Service) GetMediaContent(ctx context.Context, id string) (*MediaContent, error) {
+	var key, contentType string
+	if err := db.QueryRow(ctx, `
+		SELECT object_key, content_type FROM property_media WHERE id = $1
+	`, id).Scan(&key, &contentType); err != nil {
+		if errors.Is(err, sqldb.ErrNoRows) {
+			return nil, &errs.Error{
+				Code:    errs.NotFound,
+				Message: "media not found",
+			}
+		}
+		return nil, apierror.E("could not fetch media", err, errs.Internal)
+	}
+
+	r, err := s.media.Open(ctx, key)
+	if err != nil {
+		return nil, apierror.E("could not open media", err, errs.Internal)
+	}
+	defer r.Close()
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, apierror.E("could not read media", err, errs.Internal)
+	}
+	return &MediaContent{ContentType: contentType, Data: data}, nil
+}
+
+// ServeMedia serves the content of a media from the media store. The media
+// of off-market properties are only served to authenticated callers and
+// through the signed URLs of their share link page.
+//
+//encore:api public raw method=GET path=/properties/media/*key
+func (s *Service) ServeMedia(w http.ResponseWriter, req *http.Request) {
+	key := strings.TrimPrefix(req.URL.Path, mediaPathPrefix)
+
+	// Derivatives are always JPEG.
+	var (
+		contentType string
+		offMarket   bool
+	)
+	if err := db.QueryRow(req.Context(), `
+		SELECT CASE WHEN m.object_key = $1 THEN m.content_type ELSE 'image/jpeg' END, p.off_market
+		FROM property_media m
+		JOIN properties p ON p.id = m.property_id
+		WHERE m.object_key = $1 OR m.thumbnail_key = $1 OR m.medium_key = $1
+	`, key).Scan(&contentType, &offMarket); err != nil {
+		if errors.Is(err, sqldb.ErrNoRows) {
+			http.Error(w, "media not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, fmt.Sprintf("could not fetch media: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	if offMarket && !canViewOffMarket() && !verifyMediaURL(secrets.ShareLinkSecret, key, req.URL.Query(), time.Now()) {
+		http.Error(w, "media not found", http.StatusNotFound)
+		return
+	}
+
+	r, err := s.media.Open(req.Context(), key)
+	if err != nil {
+		if errors.Is(err, errMediaNotFound) {
+			http.Error(w, "media not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, fmt.Sprintf("could not open media: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer r.Close()
+
+	// Object keys are never reused, so the content can be cached for good,
+	// but only by the browser for off-market properties.
+	cacheControl := "public, max-age=31536000, immutable"
+	if offMarket {
+		cacheControl = "private, max-age=31536000, immutable"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", cacheControl)
+	if _, err := io.Copy(w, r); err != nil {
+		rlog.Error("could not write media", "key", key, "error", err)
+	}
+}
+
+// validate checks the input and defaults the kind to photo.
+func (in *UploadMediaInput) validate() error {
+	if in.Kind == "" {
+		in.Kind = MediaKindPhoto
+	}
+
+	if in.Kind != MediaKindPhoto && in.Kind != MediaKindBlueprint {
+		return errors.New("kind must be photo or blueprint")
+	}
+
+	if len(in.Data) == 0 || len(in.Data) > maxMediaBytes {
+		return fmt.Errorf("data must have between 1 and %d bytes", maxMediaBytes)
+	}
+	return nil
+}
+
+// storeMedia writes the image and its derivatives to the media store
+// and records them as a media of the property. GPS metadata is removed
+// from the image before it is stored.
+func (s *Service) storeMedia(ctx context.Context, propertyID, kind, caption string, position *int, data []byte) (*Media, error) {
+	data, contentType, err := imaging.Sanitize(data)
+	if err != nil {
+		return nil, imagingError("sanitize image", err)
+	}
+
+	derived, err := imaging.Derive(data, thumbnailSize, mediumSize)
+	if err != nil {
+		return nil, imagingError("derive images", err)
+	}
+
+	id, err := idutil.NewID()
+	if err != nil {
+		return nil, fmt.Errorf("could not generate media ID: %w", err)
+	}
+
+	m := Media{
+		ID:          id,
+		PropertyID:  propertyID,
+		Kind:        kind,
+		ContentType: contentType,
+		SizeBytes:   int64(len(data)),
+		Caption:     caption,
+		CreatedAt:   time.Now(),
+	}
+
+	if position != nil {
+		m.Position = *position
+	} else if err := db.QueryRow(ctx, `
+		SELECT COALESCE(MAX(position) + 1, 0) FROM property_media WHERE property_id = $1
+	`, propertyID).Scan(&m.Position); err != nil {
+		return nil, fmt.Errorf("could not compute media position: %w", err)
+	}
+
+	var (
+		key          = propertyID + "/" + id
+		thumbnailKey = key + "_thumb.jpg"
+		medKey       = key + "_medium.jpg"
+	)
+
+	objects := []struct {
+		key         string
+		contentType string
+		data        []byte
+	}{
+		{key, contentType, data},
+		{thumbnailKey, contentTypeJPEG, derived[0]},
+		{medKey, contentTypeJPEG, derived[1]},
+	}
+
+	var stored []string
+	for _, obj := range objects {
+		if err := s.media.Put(ctx, obj.key, obj.contentType, obj.data); err != nil {
+			s.removeMediaObjects(ctx, stored)
+			return nil, fmt.Errorf("could not store media content: %w", err)
+		}
+		stored = append(stored, obj.key)
+	}
+
+	if _, err := db.Exec(ctx, `
+		INSERT INTO property_media (
+			id, property_id, kind, object_key, content_type,
+			size_bytes, caption, position, created_at,
+			thumbnail_key, medium_key
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+	`,
+		m.ID, m.PropertyID, m.Kind, key, m.ContentType,
+		m.SizeBytes, m.Caption, m.Position, m.CreatedAt,
+		thumbnailKey, medKey,
+	); err != nil {
+		s.removeMediaObjects(ctx, stored)
+		return nil, fmt.Errorf("could not insert media: %w", err)
+	}
+
+	m.setURLs(key, &thumbnailKey, &medKey)
+	return &m, nil
+}
+
+// setURLs sets the media URLs from its object keys. Media stored before
+// derivatives existed fall back to the original image until they are backfilled.
+func (m *Media) setURLs(key string, thumbnailKey, medKey *string) {
+	m.URL = mediaURL(key)
+	m.ThumbnailURL = m.URL
+	m.MediumURL = m.URL
+
+	if thumbnailKey != nil {
+		m.ThumbnailURL = mediaURL(*thumbnailKey)
+	}
+	if medKey != nil {
+		m.MediumURL = mediaURL(*medKey)
+	}
+}
+
+// listMedia returns the media of the given properties, keyed by property ID.
+func listMedia(ctx context.Context, propertyIDs ...string) (map[string][]*Media, error) {
+	rows, err := db.Query(ctx, `
+		SELECT id, property_id, kind, object_key, content_type,
+			size_bytes, caption, position, created_at,
+			thumbnail_key, medium_key
+		FROM property_media
+		WHERE property_id = ANY($1)
+		ORDER BY property_id, position, created_at
+	`, propertyIDs)
+	if err != nil {
+		return nil, fmt.Errorf("could not query media: %w", err)
+	}
+	defer rows.Close()
+
+	media := make(map[string][]*Media)
+	for rows.Next() {
+		var (
+			m                    Media
+			key                  string
+			thumbnailKey, medKey *string
+		)
+		if err := rows.Scan(
+			&m.ID, &m.PropertyID, &m.Kind, &key, &m.ContentType,
+			&m.SizeBytes, &m.Caption, &m.Position, &m.CreatedAt,
+			&thumbnailKey, &medKey,
+		); err != nil {
+			return nil, fmt.Errorf("could not scan media: %w", err)
+		}
+		m.setURLs(key, thumbnailKey, medKey)
+		media[m.PropertyID] = append(media[m.PropertyID], &m)
+	}
+	return media, rows.Err()
+}
+
+// mediaKeys returns the object keys of the media of the given properties,
+// derivatives included, or of every property if none is given.
+func mediaKeys(ctx context.Context, propertyIDs ...string) ([]string, error) {
+	query := `
+		SELECT k FROM property_media,
+		LATERAL unnest(ARRAY[object_key, thumbnail_key, medium_key]) AS k
+		WHERE k IS NOT NULL`
+	var args []any
+	if len(propertyIDs) > 0 {
+		query += ` AND property_id = ANY($1)`
+		args = append(args, propertyIDs)
+	}
+
+	rows, err := db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("could not query media keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, fmt.Errorf("could not scan media key: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// removeMediaObjects deletes objects from the media store. Failures are only
+// logged since the media rows referencing them are already gone.
+func (s *Service) removeMediaObjects(ctx context.Context, keys []string) {
+	for _, key := range keys {
+		if err := s.media.Delete(ctx, key); err != nil {
+			rlog.Error("could not remove media object", "key", key, "error", err)
+		}
+	}
+}
+
+// rejectLegacyImages refuses base64 images sent for a property that already
+// exists, instead of silently keeping its current media.
+func rejectLegacyImages(prop *Property) error {
+	if !hasImageData(prop.PhotoBase64Data) && !hasImageData(prop.BlueprintBase64Data) {
+		return nil
+	}
+	return &errs.Error{
+		Code:    errs.InvalidArgument,
+		Message: fmt.Sprintf("property %s already exists: send its images to POST /properties/%s/media instead of photoBase64Data and blueprintBase64Data", prop.ID, prop.ID),
+	}
+}
+
+func hasImageData(data *string) bool {
+	return data != nil && *data != ""
+}
+
+// importLegacyImages moves base64 images, as sent to Create for a new property
+// or stored in the legacy columns, into the media store. An image is skipped
+// when the property already has media of its kind, which keeps the startup
+// migration idempotent.
+func (s *Service) importLegacyImages(ctx context.Context, propertyID string, photo, blueprint *string) error {
+	images := []struct {
+		kind string
+		data *string
+	}{
+		{MediaKindPhoto, photo},
+		{MediaKindBlueprint, blueprint},
+	}
+
+	for _, img := range images {
+		if !hasImageData(img.data) {
+			continue
+		}
+
+		var exists bool
+		if err := db.QueryRow(ctx, `
+			SELECT EXISTS(SELECT 1 FROM property_media WHERE property_id = $1 AND kind = $2)
+		`, propertyID, img.kind).Scan(&exists); err != nil {
+			return fmt.Errorf("could not check existing media: %w", err)
+		}
+
+		if exists {
+			continue
+		}
+
+		data, err := base64.StdEncoding.DecodeString(*img.data)
+		if err != nil {
+			return fmt.Errorf("could not decode %s: %w", img.kind, err)
+		}
+
+		if _, err := s.storeMedia(ctx, propertyID, img.kind, "", nil, data); err != nil {
+			return fmt.Errorf("could not store %s: %w", img.kind, err)
+		}
+	}
+	return nil
+}
+
+// backfillMedia moves the images left in the legacy base64 columns into the
+// media store, then renders the derivatives of media uploaded before they
+// existed. It returns right away when there is nothing to backfill, or when
+// another instance is already doing it.
+func (s *Service) backfillMedia(ctx context.Context) error {
+	var pending bool
+	if err := db.QueryRow(ctx, `
+		SELECT EXISTS(SELECT 1 FROM properties WHERE photo_base64_data IS NOT NULL OR blueprint_base64_data IS NOT NULL)
+			OR EXISTS(SELECT 1 FROM property_media WHERE thumbnail_key IS NULL OR medium_key IS NULL)
+	`).Scan(&pending); err != nil {
+		return fmt.Errorf("could not check media to backfill: %w", err)
+	}
+
+	if !pending {
+		return nil
+	}
+
+	// The transaction holds the lock until the backfill is done, and
+	// releases it even if the instance dies.
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var locked bool
+	if err := tx.QueryRow(ctx, `SELECT pg_try_advisory_xact_lock($1)`, mediaBackfillLockKey).Scan(&locked); err != nil {
+		return fmt.Errorf("could not lock media backfill: %w", err)
+	}
+
+	if !locked {
+		rlog.Info("media backfill already running on another instance")
+		return nil
+	}
+
+	if err := s.migrateLegacyMedia(ctx); err != nil {
+		return fmt.Errorf("could not migrate legacy media: %w", err)
+	}
+	if err := s.generateMissingDerivatives(ctx); err != nil {
+		return fmt.Errorf("could not generate media derivatives: %w", err)
+	}
+	return nil
+}
+
+// migrateLegacyMedia moves the images still stored in the base64 columns of
+// the properties table into the media store, clearing the columns as it goes.
+// Properties whose images cannot be moved are logged and left untouched. The
+// caller must hold the backfill lock, since importing is check-then-insert.
+func (s *Service) migrateLegacyMedia(ctx context.Context) error {
+	var after string
+	for {
+		rows, err := db.Query(ctx, `
+			SELECT id, photo_base64_data, blueprint_base64_data
+			FROM properties
+			WHERE id > $1 AND (photo_base64_data IS NOT NULL OR blueprint_base64_data IS NOT NULL)
+			ORDER BY id
+			LIMIT $2
+		`, after, legacyMigrationBatch)
+		if err != nil {
+			return fmt.Errorf("could not query legacy images: %w", err)
+		}
+
+		var batch []*Property
+		for rows.Next() {
+			var p Property
+			if err := rows.Scan(&p.ID, &p.PhotoBase64Data, &p.BlueprintBase64Data); err != nil {
+				rows.Close()
+				return fmt.Errorf("could not scan legacy images: %w", err)
+			}
+			batch = append(batch, &p)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("could not iterate legacy images: %w", err)
+		}
+
+		if len(batch) == 0 {
+			return nil
+		}
+
+		for _, p := range batch {
+			after = p.ID
+
+			if err := s.importLegacyImages(ctx, p.ID, p.PhotoBase64Data, p.BlueprintBase64Data); err != nil {
+				rlog.Error("could not migrate property images", "property_id", p.ID, "error", err)
+				continue
+			}
+
+			if _, err := db.Exec(ctx, `
+				UPDATE properties
+				SET photo_base64_data = NULL, blueprint_base64_data = NULL
+				WHERE id = $1
+			`, p.ID); err != nil {
+				return fmt.Errorf("could not clear legacy images: %w", err)
+			}
+		}
+	}
+}
+
+// generateMissingDerivatives renders the derivatives of the media stored
+// before they existed. Media that cannot be processed are logged and skipped.
+func (s *Service) generateMissingDerivatives(ctx context.Context) error {
+	var after string
+	for {
+		rows, err := db.Query(ctx, `
+			SELECT id, object_key
+			FROM property_media
+			WHERE id > $1 AND (thumbnail_key IS NULL OR medium_key IS NULL)
+			ORDER BY id
+			LIMIT $2
+		`, after, legacyMigrationBatch)
+		if err != nil {
+			return fmt.Errorf("could not query media without derivatives: %w", err)
+		}
+
+		type pending struct{ id, key string }
+		var batch []pending
+		for rows.Next() {
+			var p pending
+			if err := rows.Scan(&p.id, &p.key); err != nil {
+				rows.Close()
+				return fmt.Errorf("could not scan media: %w", err)
+			}
+			batch = append(batch, p)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("could not iterate media: %w", err)
+		}
+
+		if len(batch) == 0 {
+			return nil
+		}
+
+		for _, p := range batch {
+			after = p.id
+			if err := s.deriveStoredMedia(ctx, p.id, p.key); err != nil {
+				rlog.Error("could not generate media derivatives", "media_id", p.id, "error", err)
+			}
+		}
+	}
+}
+
+func (s *Service) deriveStoredMedia(ctx context.Context, id, key string) error {
+	r, err := s.media.Open(ctx, key)
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		return fmt.Errorf("could not read media: %w", err)
+	}
+
+	derived, err := imaging.Derive(data, thumbnailSize, mediumSize)
+	if err != nil {
+		return fmt.Errorf("could not derive images: %w", err)
+	}
+
+	thumbnailKey, medKey := key+"_thumb.jpg", key+"_medium.jpg"
+	if err := s.media.Put(ctx, thumbnailKey, contentTypeJPEG, derived[0]); err != nil {
+		return err
+	}
+	if err := s.media.Put(ctx, medKey, contentTypeJPEG, derived[1]); err != nil {
+		return err
+	}
+
+	if _, err := db.Exec(ctx, `
+		UPDATE property_media SET thumbnail_key = $1, medium_key = $2 WHERE id = $3
+	`, thumbnailKey, medKey, id); err != nil {
+		return fmt.Errorf("could not update media derivatives: %w", err)
+	}
+	return nil
+}
+
+func mediaURL(key string) string {
+	base := encore.Meta().APIBaseURL
+	return fmt.Sprintf("%s://%s%s%s", base.Scheme, base.Host, mediaPathPrefix, key)
+}
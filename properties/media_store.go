@@ -0,0 +1,136 @@
+package properties
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"encore.dev/storage/objects"
+)
+
+const (
+	mediaBackendBucket     = "bucket"
+	mediaBackendFilesystem = "filesystem"
+)
+
+var mediaBucket = objects.NewBucket("property-media", objects.BucketConfig{})
+
+var errMediaNotFound = errors.New("media object not found")
+
+// mediaStore stores the binary content of property media.
+type mediaStore interface {
+	Put(ctx context.Context, key, contentType string, data []byte) error
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+func newMediaStore() (mediaStore, error) {
+	switch cfg.MediaBackend {
+	case mediaBackendBucket:
+		return &bucketStore{bucket: mediaBucket}, nil
+	case mediaBackendFilesystem:
+		return &fsStore{dir: cfg.MediaDir}, nil
+	}
+	return nil, fmt.Errorf("unknown media backend %q", cfg.MediaBackend)
+}
+
+// bucketStore keeps media in the object storage bucket.
+type bucketStore struct {
+	bucket *objects.Bucket
+}
+
+func (b *bucketStore) Put(ctx context.Context, key, contentType string, data []byte) error {
+	w := b.bucket.Upload(ctx, key, objects.WithUploadAttrs(objects.UploadAttrs{
+		ContentType: contentType,
+	}))
+
+	if _, err := w.Write(data); err != nil {
+		w.Abort(err)
+		return fmt.Errorf("could not write object: %w", err)
+	}
+
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("could not upload object: %w", err)
+	}
+	return nil
+}
+
+func (b *bucketStore) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	r := b.bucket.Download(ctx, key)
+	if err := r.Err(); err != nil {
+		if errors.Is(err, objects.ErrObjectNotFound) {
+			return nil, errMediaNotFound
+		}
+		return nil, fmt.Errorf("could not download object: %w", err)
+	}
+	return r, nil
+}
+
+func (b *bucketStore) Delete(ctx context.Context, key string) error {
+	if err := b.bucket.Remove(ctx, key); err != nil && !errors.Is(err, objects.ErrObjectNotFound) {
+		return fmt.Errorf("could not remove object: %w", err)
+	}
+	return nil
+}
+
+// fsStore keeps media on the local filesystem. It stands in
+// for the bucket during local development.
+type fsStore struct {
+	dir string
+}
+
+func (f *fsStore) path(key string) (string, error) {
+	p := filepath.Join(f.dir, filepath.FromSlash(key))
+	if !strings.HasPrefix(p, filepath.Clean(f.dir)+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid media key %q", key)
+	}
+	return p, nil
+}
+
+func (f *fsStore) Put(_ context.Context, key, _ string, data []byte) error {
+	p, err := f.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return fmt.Errorf("could not create media directory: %w", err)
+	}
+
+	if err := os.WriteFile(p, data, 0o644); err != nil {
+		return fmt.Errorf("could not write media file: %w", err)
+	}
+	return nil
+}
+
+func (f *fsStore) Open(_ context.Context, key string) (io.ReadCloser, error) {
+	p, err := f.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(p)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, errMediaNotFound
+		}
+		return nil, fmt.Errorf("could not open media file: %w", err)
+	}
+	return file, nil
+}
+
+func (f *fsStore) Delete(_ context.Context, key string) error {
+	p, err := f.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("could not remove media file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,7 @@
+CREATE INDEX idx_properties_price ON properties (price, id);
+CREATE INDEX idx_properties_area ON properties (area, id);
+CREATE INDEX idx_properties_created_at ON properties (created_at, id);
+CREATE INDEX idx_properties_district ON properties (LOWER(district));
+CREATE INDEX idx_properties_city ON properties (LOWER(city));
+CREATE INDEX idx_properties_property_type ON properties (LOWER(property_type));
+CREATE INDEX idx_properties_features ON properties USING GIN (features);
@@ -0,0 +1,13 @@
+CREATE TABLE property_media (
+    id VARCHAR(255) PRIMARY KEY,
+    property_id VARCHAR(255) NOT NULL REFERENCES properties (id) ON DELETE CASCADE,
+    kind VARCHAR(32) NOT NULL,
+    object_key VARCHAR(512) NOT NULL UNIQUE,
+    content_type VARCHAR(128) NOT NULL,
+    size_bytes BIGINT NOT NULL,
+    caption TEXT NOT NULL DEFAULT '',
+    position INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_property_media_property_id ON property_media (property_id, position);
@@ -0,0 +1,3 @@
+ALTER TABLE property_media
+    ADD COLUMN thumbnail_key VARCHAR(512) UNIQUE,
+    ADD COLUMN medium_key VARCHAR(512) UNIQUE;
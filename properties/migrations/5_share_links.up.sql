@@ -0,0 +1,13 @@
+ALTER TABLE properties ADD COLUMN off_market BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE property_link_opens (
+    id BIGSERIAL PRIMARY KEY,
+    property_id VARCHAR(255) NOT NULL REFERENCES properties (id) ON DELETE CASCADE,
+    lead_id VARCHAR(255),
+    user_agent TEXT NOT NULL DEFAULT '',
+    link_expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    opened_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_property_link_opens_property ON property_link_opens (property_id, opened_at);
+CREATE INDEX idx_property_link_opens_lead ON property_link_opens (lead_id, opened_at) WHERE lead_id IS NOT NULL;
@@ -0,0 +1,23 @@
+CREATE TABLE property_views (
+    id BIGSERIAL PRIMARY KEY,
+    property_id VARCHAR(255) NOT NULL REFERENCES properties (id) ON DELETE CASCADE,
+    lead_id VARCHAR(255),
+    chat VARCHAR(64),
+    user_agent TEXT NOT NULL DEFAULT '',
+    viewed_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_property_views_viewed_at ON property_views (viewed_at);
+CREATE INDEX idx_property_views_property ON property_views (property_id, viewed_at);
+CREATE INDEX idx_property_views_lead ON property_views (lead_id, viewed_at) WHERE lead_id IS NOT NULL;
+CREATE INDEX idx_property_views_chat ON property_views (chat, property_id) WHERE chat IS NOT NULL;
+
+CREATE TABLE property_recommendations (
+    id BIGSERIAL PRIMARY KEY,
+    property_id VARCHAR(255) NOT NULL REFERENCES properties (id) ON DELETE CASCADE,
+    chat VARCHAR(64) NOT NULL,
+    recommended_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_property_recommendations_recommended_at ON property_recommendations (recommended_at);
+CREATE INDEX idx_property_recommendations_chat ON property_recommendations (chat, property_id);
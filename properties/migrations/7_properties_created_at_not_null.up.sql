@@ -0,0 +1,7 @@
+-- Keyset pagination on created_at skips or repeats rows where it is NULL.
+UPDATE properties SET created_at = COALESCE(updated_at, NOW()) WHERE created_at IS NULL;
+UPDATE properties SET updated_at = created_at WHERE updated_at IS NULL;
+
+ALTER TABLE properties
+    ALTER COLUMN created_at SET NOT NULL,
+    ALTER COLUMN updated_at SET NOT NULL;
@@ -0,0 +1,4 @@
+-- Chats were stored as phone numbers. They are now opaque IDs signed with a
+-- secret the database doesn't have, so the phone numbers are dropped.
+UPDATE property_views SET chat = NULL WHERE chat IS NOT NULL;
+DELETE FROM property_recommendations;
@@ -8,9 +8,18 @@ import (
 // Properties represents a list of real estate properties.
 type Properties struct {
 	Properties []*Property `json:"properties"`
+	// NextCursor is set by List when more results are available.
+	NextCursor string `json:"nextCursor,omitempty"`
 }
 
 // Property represents a real estate property.
+//
+// The base64 photo and blueprint fields are only kept for backwards
+// compatibility: images sent through them are moved into the media
+// store and listed in Media.
+//
+// OffMarket properties are left out of the listings and the assistant
+// knowledge, and their page can only be opened through a share link.
 type Property struct {
 	ID                  string     `json:"id"`
 	Name                string     `json:"name"`
@@ -30,16 +39,38 @@ type Property struct {
 	YearBuilt           int        `json:"yearBuilt"`
 	Builder             *string    `json:"builder"`
 	Features            []string   `json:"features"`
+	OffMarket           bool       `json:"offMarket"`
 	PhotoBase64Data     *string    `json:"photoBase64Data,omitempty"`
 	PhotoFormat         *string    `json:"photoFormat,omitempty"`
 	PhotoUploadDate     *time.Time `json:"photoUploadDate,omitempty"`
 	BlueprintBase64Data *string    `json:"blueprintBase64Data,omitempty"`
 	BlueprintFormat     *string    `json:"blueprintFormat,omitempty"`
 	BlueprintUploadDate *time.Time `json:"blueprintUploadDate,omitempty"`
+	Media               []*Media   `json:"media,omitempty"`
 	CreatedAt           time.Time  `json:"createdAt"`
 	UpdatedAt           time.Time  `json:"updatedAt"`
 }
 
+// Photos returns the photos of the property, in display order.
+func (p *Property) Photos() []*Media {
+	return p.mediaOfKind(MediaKindPhoto)
+}
+
+// Blueprints returns the blueprints of the property, in display order.
+func (p *Property) Blueprints() []*Media {
+	return p.mediaOfKind(MediaKindBlueprint)
+}
+
+func (p *Property) mediaOfKind(kind string) []*Media {
+	var media []*Media
+	for _, m := range p.Media {
+		if m.Kind == kind {
+			media = append(media, m)
+		}
+	}
+	return media
+}
+
 // String returns a string representation of a property in Portuguese.
 func (p *Property) String() string {
 	description := "Não informado"
@@ -73,6 +104,308 @@ func (p *Property) String() string {
 	)
 }
 
+// Media kinds.
+const (
+	MediaKindPhoto     = "photo"
+	MediaKindBlueprint = "blueprint"
+)
+
+// Media is an image attached to a property.
+type Media struct {
+	ID          string `json:"id"`
+	PropertyID  string `json:"propertyId"`
+	Kind        string `json:"kind"`
+	ContentType string `json:"contentType"`
+	SizeBytes   int64  `json:"sizeBytes"`
+	Caption     string `json:"caption"`
+	Position    int    `json:"position"`
+	// URL serves the image as uploaded, without its GPS metadata.
+	URL string `json:"url"`
+	// ThumbnailURL and MediumURL serve JPEG derivatives sized for
+	// gallery thumbnails and for the web page respectively.
+	ThumbnailURL string    `json:"thumbnailUrl"`
+	MediumURL    string    `json:"mediumUrl"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// MediaList is a list of property media.
+type MediaList struct {
+	Media []*Media `json:"media"`
+}
+
+// UploadMediaInput is an image to attach to a property.
+type UploadMediaInput struct {
+	// Kind is photo (default) or blueprint.
+	Kind    string `json:"kind"`
+	Caption string `json:"caption"`
+	// Position orders the media of the same property.
+	// Defaults to after the last one.
+	Position *int `json:"position,omitempty"`
+	// Data is the image content, base64 encoded in JSON.
+	Data []byte `json:"data"`
+}
+
+// UploadMediaBatchInput holds several images to attach to a property.
+type UploadMediaBatchInput struct {
+	Images []*UploadMediaInput `json:"images"`
+}
+
+// MediaContent is the binary content of a property media.
+type MediaContent struct {
+	ContentType string `json:"contentType"`
+	Data        []byte `json:"data"`
+}
+
+// ListInput holds the filters, sorting and pagination options for List.
+// Zero values mean the filter is not applied.
 type ListInput struct {
+	// WithBase64Images is deprecated: images live in the media store
+	// and are listed in Media instead.
 	WithBase64Images bool `query:"with_base64_images"`
+
+	PropertyType   string   `query:"type"`
+	District       string   `query:"district"`
+	City           string   `query:"city"`
+	MinPrice       float64  `query:"min_price"`
+	MaxPrice       float64  `query:"max_price"`
+	MinBedrooms    int      `query:"min_bedrooms"`
+	MinBathrooms   int      `query:"min_bathrooms"`
+	MinGarageSpots int      `query:"min_garage_spots"`
+	MinArea        float64  `query:"min_area"`
+	MaxArea        float64  `query:"max_area"`
+	Features       []string `query:"features"`
+	// Text is matched against the name and description.
+	Text string `query:"q"`
+
+	// SortBy is one of price, area or created_at (default).
+	SortBy string `query:"sort_by"`
+	// Order is asc or desc (default).
+	Order string `query:"order"`
+	// Cursor is the NextCursor returned by a previous call.
+	Cursor string `query:"cursor"`
+	// Limit caps the page size, defaulting to 50 and capped at 200.
+	Limit int `query:"limit"`
+	// IncludeOffMarket lists the off-market properties too. It requires
+	// an authenticated caller.
+	IncludeOffMarket bool `query:"include_off_market"`
+}
+
+// UpdateInput holds a partial update of a property.
+// Only non-nil fields are written.
+type UpdateInput struct {
+	// UpdatedAt must match the stored updatedAt of the property,
+	// otherwise the update is rejected as a concurrent modification.
+	UpdatedAt time.Time `json:"updatedAt"`
+
+	Name           *string   `json:"name,omitempty"`
+	Area           *float64  `json:"area,omitempty"`
+	NumBedrooms    *int      `json:"numBedrooms,omitempty"`
+	NumBathrooms   *int      `json:"numBathrooms,omitempty"`
+	NumGarageSpots *int      `json:"numGarageSpots,omitempty"`
+	Price          *float64  `json:"price,omitempty"`
+	Street         *string   `json:"street,omitempty"`
+	Number         *int      `json:"number,omitempty"`
+	District       *string   `json:"district,omitempty"`
+	City           *string   `json:"city,omitempty"`
+	State          *string   `json:"state,omitempty"`
+	PropertyType   *string   `json:"propertyType,omitempty"`
+	Reference      *string   `json:"reference,omitempty"`
+	Description    *string   `json:"description,omitempty"`
+	YearBuilt      *int      `json:"yearBuilt,omitempty"`
+	Builder        *string   `json:"builder,omitempty"`
+	Features       *[]string `json:"features,omitempty"`
+	OffMarket      *bool     `json:"offMarket,omitempty"`
+}
+
+// assignments returns the column/value pairs set by the update.
+func (in *UpdateInput) assignments() ([]string, []any) {
+	var (
+		cols []string
+		vals []any
+	)
+
+	set := func(col string, v any) {
+		cols = append(cols, col)
+		vals = append(vals, v)
+	}
+
+	if in.Name != nil {
+		set("name", *in.Name)
+	}
+	if in.Area != nil {
+		set("area", *in.Area)
+	}
+	if in.NumBedrooms != nil {
+		set("num_bedrooms", *in.NumBedrooms)
+	}
+	if in.NumBathrooms != nil {
+		set("num_bathrooms", *in.NumBathrooms)
+	}
+	if in.NumGarageSpots != nil {
+		set("num_garage_spots", *in.NumGarageSpots)
+	}
+	if in.Price != nil {
+		set("price", *in.Price)
+	}
+	if in.Street != nil {
+		set("street", *in.Street)
+	}
+	if in.Number != nil {
+		set("number", *in.Number)
+	}
+	if in.District != nil {
+		set("district", *in.District)
+	}
+	if in.City != nil {
+		set("city", *in.City)
+	}
+	if in.State != nil {
+		set("state", *in.State)
+	}
+	if in.PropertyType != nil {
+		set("property_type", *in.PropertyType)
+	}
+	if in.Reference != nil {
+		set("reference", *in.Reference)
+	}
+	if in.Description != nil {
+		set("description", *in.Description)
+	}
+	if in.YearBuilt != nil {
+		set("year_built", *in.YearBuilt)
+	}
+	if in.Builder != nil {
+		set("builder", *in.Builder)
+	}
+	if in.Features != nil {
+		set("features", *in.Features)
+	}
+	if in.OffMarket != nil {
+		set("off_market", *in.OffMarket)
+	}
+	return cols, vals
+}
+
+// ShareLinkInput holds the options of a share link.
+type ShareLinkInput struct {
+	// LeadID ties the link to the lead it is sent to, so its opens are
+	// logged for that lead.
+	LeadID string `json:"leadId,omitempty"`
+	// ExpiresIn is how long the link works, such as "72h".
+	// It defaults to 7 days and is capped at 90 days.
+	ExpiresIn string `json:"expiresIn,omitempty"`
+}
+
+// ShareLink is a signed link to the page of a property.
+type ShareLink struct {
+	URL        string    `json:"url"`
+	PropertyID string    `json:"propertyId"`
+	LeadID     string    `json:"leadId,omitempty"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+}
+
+// LinkOpen is a share link being opened.
+type LinkOpen struct {
+	ID            int64     `json:"id"`
+	PropertyID    string    `json:"propertyId"`
+	Reference     string    `json:"reference"`
+	LeadID        *string   `json:"leadId,omitempty"`
+	UserAgent     string    `json:"userAgent"`
+	LinkExpiresAt time.Time `json:"linkExpiresAt"`
+	OpenedAt      time.Time `json:"openedAt"`
+}
+
+// LinkOpens is a list of share link opens, most recent first.
+type LinkOpens struct {
+	Opens []*LinkOpen `json:"opens"`
+}
+
+// ListLinkOpensInput filters the opens returned by ListLinkOpens.
+// Zero values mean the filter is not applied.
+type ListLinkOpensInput struct {
+	PropertyID string `query:"property_id"`
+	LeadID     string `query:"lead_id"`
+	// Limit caps the number of opens, defaulting to 50 and capped at 200.
+	Limit int `query:"limit"`
+}
+
+// RecordRecommendationsInput holds the properties the chatbot recommended
+// in a chat.
+type RecordRecommendationsInput struct {
+	// Chat identifies the chat, as given to SignChat.
+	Chat       string   `json:"chat"`
+	References []string `json:"references"`
+}
+
+// SignChatInput holds the chat to sign a token for, such as the phone
+// number of a WhatsApp contact.
+type SignChatInput struct {
+	Chat string `json:"chat"`
+}
+
+// ChatToken is added as the chat parameter of the property links sent in
+// a chat. It doesn't reveal the chat.
+type ChatToken struct {
+	Token string `json:"token"`
+}
+
+// AnalyticsInput is the period of an analytics query. It defaults to the
+// last 30 days.
+type AnalyticsInput struct {
+	PropertyID string    `query:"property_id"`
+	From       time.Time `query:"from"`
+	To         time.Time `query:"to"`
+}
+
+// PropertyViews counts the views of a property page.
+type PropertyViews struct {
+	PropertyID string `json:"propertyId"`
+	Reference  string `json:"reference"`
+	Views      int    `json:"views"`
+	// Viewers counts the distinct leads and chats the views came from.
+	Viewers      int        `json:"viewers"`
+	LastViewedAt *time.Time `json:"lastViewedAt,omitempty"`
+}
+
+// ViewsByProperty lists the views of each property, most viewed first.
+type ViewsByProperty struct {
+	Properties []*PropertyViews `json:"properties"`
+}
+
+// DayViews counts the views of a day, in the Brazilian time zone.
+type DayViews struct {
+	// Day is formatted as 2006-01-02.
+	Day   string `json:"day"`
+	Views int    `json:"views"`
+}
+
+// ViewsByDay lists the views of each day with any, in order.
+type ViewsByDay struct {
+	Days []*DayViews `json:"days"`
+}
+
+// LeadViews counts the property pages a lead viewed.
+type LeadViews struct {
+	LeadID       string    `json:"leadId"`
+	Views        int       `json:"views"`
+	Properties   int       `json:"properties"`
+	LastViewedAt time.Time `json:"lastViewedAt"`
+}
+
+// ViewsByLead lists the views of each lead, most recent first.
+type ViewsByLead struct {
+	Leads []*LeadViews `json:"leads"`
+}
+
+// ClickThrough compares the properties the chatbot recommended in chats
+// with the pages viewed from those chats.
+type ClickThrough struct {
+	// Recommendations counts the distinct chat and property pairs
+	// recommended in the period.
+	Recommendations int `json:"recommendations"`
+	// Clicked counts the ones whose page was viewed from the chat after
+	// the property was first recommended.
+	Clicked int `json:"clicked"`
+	// Rate is Clicked over Recommendations, or 0 without recommendations.
+	Rate float64 `json:"rate"`
 }
@@ -8,11 +8,14 @@ import (
 	"fmt"
 	"html/template"
 	"net/http"
+	"strings"
 	"time"
 
 	"encore.app/internal/pkg/apierror"
+	"encore.app/internal/pkg/authz"
 
 	"encore.dev/beta/errs"
+	"encore.dev/rlog"
 	"encore.dev/storage/sqldb"
 )
 
@@ -27,12 +30,16 @@ var (
 	secrets struct {
 		OpenAIKey   string
 		BearerToken string
+		// ShareLinkSecret signs the share links of property pages and
+		// the chat tokens of the links sent by the chatbot.
+		ShareLinkSecret string
 	}
 )
 
 //encore:service
 type Service struct {
 	templ *template.Template
+	media mediaStore
 }
 
 func initService() (*Service, error) {
@@ -40,11 +47,45 @@ func initService() (*Service, error) {
 	if err != nil {
 		return nil, fmt.Errorf("could not parse templates: %w", err)
 	}
-	return &Service{templ: tmpl}, nil
+
+	media, err := newMediaStore()
+	if err != nil {
+		return nil, fmt.Errorf("could not create media store: %w", err)
+	}
+
+	s := &Service{templ: tmpl, media: media}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), mediaBackfillTimeout)
+		defer cancel()
+
+		if err := s.backfillMedia(ctx); err != nil {
+			rlog.Error("could not backfill media", "error", err)
+		}
+	}()
+	return s, nil
 }
 
-//encore:api public method=POST path=/properties
+//encore:api auth method=POST path=/properties
 func (s *Service) Create(ctx context.Context, in *Properties) error {
+	if err := authz.Require(authz.ScopeProperties, authz.RoleAgent); err != nil {
+		return err
+	}
+
+	// Images of an existing property are managed through the media endpoints.
+	// The batch is checked up front so a rejection leaves nothing half written.
+	for _, prop := range in.Properties {
+		exists, err := propertyExists(ctx, prop.ID)
+		if err != nil {
+			return fmt.Errorf("could not check property existence: %w", err)
+		}
+		if exists {
+			if err := rejectLegacyImages(prop); err != nil {
+				return err
+			}
+		}
+	}
+
 	for _, prop := range in.Properties {
 		exists, err := propertyExists(ctx, prop.ID)
 		if err != nil {
@@ -52,36 +93,48 @@ func (s *Service) Create(ctx context.Context, in *Properties) error {
 		}
 
 		if exists {
+			// A property repeated within the batch only exists by now.
+			if err := rejectLegacyImages(prop); err != nil {
+				return err
+			}
 			if err := updateProperty(ctx, prop); err != nil {
 				return fmt.Errorf("could not update property: %w", err)
 			}
+			if err := publishChange(ctx, ChangeUpdated, prop.ID, prop.Reference); err != nil {
+				return apierror.E("could not publish property change", err, errs.Internal)
+			}
 			continue
 		}
 		if err := insertProperty(ctx, prop); err != nil {
 			return fmt.Errorf("could not store property: %w", err)
 		}
+		if err := s.importLegacyImages(ctx, prop.ID, prop.PhotoBase64Data, prop.BlueprintBase64Data); err != nil {
+			return fmt.Errorf("could not store property images: %w", err)
+		}
+		if err := publishChange(ctx, ChangeCreated, prop.ID, prop.Reference); err != nil {
+			return apierror.E("could not publish property change", err, errs.Internal)
+		}
 	}
 	return nil
 }
 
 //encore:api public method=GET path=/properties
 func (s *Service) List(ctx context.Context, in ListInput) (*Properties, error) {
-	query := `SELECT 
-        id, name, area, num_bedrooms, num_bathrooms, num_garage_spots, 
-        price, street, number, district, city, state, property_type,
-        reference, description, year_built, builder, features`
-
-	if in.WithBase64Images {
-		query += `,
-        photo_base64_data, photo_format, photo_upload_date,
-        blueprint_base64_data, blueprint_format, blueprint_upload_date`
+	if in.IncludeOffMarket {
+		if err := authz.Require(authz.ScopeProperties, authz.RoleReadOnly); err != nil {
+			return nil, err
+		}
 	}
 
-	query += `,
-        created_at, updated_at
-        FROM properties`
+	q, err := buildListQuery(in)
+	if err != nil {
+		return nil, &errs.Error{
+			Code:    errs.InvalidArgument,
+			Message: err.Error(),
+		}
+	}
 
-	rows, err := db.Query(ctx, query)
+	rows, err := db.Query(ctx, q.sql, q.args...)
 	if err != nil {
 		return nil, apierror.E("could not fetch properties", err, errs.Internal)
 	}
@@ -97,7 +150,7 @@ func (s *Service) List(ctx context.Context, in ListInput) (*Properties, error) {
 			if err := rows.Scan(
 				&p.ID, &p.Name, &p.Area, &p.NumBedrooms, &p.NumBathrooms, &p.NumGarageSpots,
 				&p.Price, &p.Street, &p.Number, &p.District, &p.City, &p.State, &p.PropertyType,
-				&p.Reference, &p.Description, &p.YearBuilt, &p.Builder, &p.Features,
+				&p.Reference, &p.Description, &p.YearBuilt, &p.Builder, &p.Features, &p.OffMarket,
 				&p.PhotoBase64Data, &p.PhotoFormat, &p.PhotoUploadDate,
 				&p.BlueprintBase64Data, &p.BlueprintFormat, &p.BlueprintUploadDate,
 				&p.CreatedAt, &p.UpdatedAt,
@@ -108,7 +161,7 @@ func (s *Service) List(ctx context.Context, in ListInput) (*Properties, error) {
 			if err := rows.Scan(
 				&p.ID, &p.Name, &p.Area, &p.NumBedrooms, &p.NumBathrooms, &p.NumGarageSpots,
 				&p.Price, &p.Street, &p.Number, &p.District, &p.City, &p.State, &p.PropertyType,
-				&p.Reference, &p.Description, &p.YearBuilt, &p.Builder, &p.Features,
+				&p.Reference, &p.Description, &p.YearBuilt, &p.Builder, &p.Features, &p.OffMarket,
 				&p.CreatedAt, &p.UpdatedAt,
 			); err != nil {
 				return nil, apierror.E("could not scan properties", err, errs.Internal)
@@ -116,10 +169,178 @@ func (s *Service) List(ctx context.Context, in ListInput) (*Properties, error) {
 		}
 		props.Properties = append(props.Properties, &p)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, apierror.E("could not iterate properties", err, errs.Internal)
+	}
+
+	if len(props.Properties) > q.limit {
+		props.Properties = props.Properties[:q.limit]
+		props.NextCursor = q.cursorFor(props.Properties[q.limit-1])
+	}
+
+	ids := make([]string, 0, len(props.Properties))
+	for _, p := range props.Properties {
+		ids = append(ids, p.ID)
+	}
+
+	media, err := listMedia(ctx, ids...)
+	if err != nil {
+		return nil, apierror.E("could not fetch property media", err, errs.Internal)
+	}
+
+	for _, p := range props.Properties {
+		p.Media = media[p.ID]
+	}
 	return &props, nil
 }
 
-//encore:api public raw path=/properties/:ref
+// Get returns the property with the given ID, including its media.
+// Off-market properties are only returned to authenticated callers.
+//
+//encore:api public method=GET path=/properties/id/:id
+func (s *Service) Get(ctx context.Context, id string) (*Property, error) {
+	prop, err := s.fetchPropertyByID(ctx, id)
+	if err != nil {
+		return nil, apierror.E("could not fetch property", err, errs.Internal)
+	}
+
+	// Off-market properties are reported as missing, so their IDs
+	// don't reveal that they exist.
+	if prop == nil || (prop.OffMarket && !canViewOffMarket()) {
+		return nil, &errs.Error{
+			Code:    errs.NotFound,
+			Message: "property not found",
+		}
+	}
+	return prop, nil
+}
+
+// canViewOffMarket reports whether the caller may see off-market
+// properties through the public endpoints.
+func canViewOffMarket() bool {
+	return authz.Require(authz.ScopeProperties, authz.RoleReadOnly) == nil
+}
+
+// GetByReference returns the property with the given reference, including its media.
+//
+//encore:api private method=GET path=/properties/ref/:ref
+func (s *Service) GetByReference(ctx context.Context, ref string) (*Property, error) {
+	prop, err := s.fetchProperty(ctx, ref)
+	if err != nil {
+		return nil, apierror.E("could not fetch property", err, errs.Internal)
+	}
+
+	if prop == nil {
+		return nil, &errs.Error{
+			Code:    errs.NotFound,
+			Message: "property not found",
+		}
+	}
+	return prop, nil
+}
+
+//encore:api auth method=PATCH path=/properties/:id
+func (s *Service) Update(ctx context.Context, id string, in *UpdateInput) (*Property, error) {
+	if err := authz.Require(authz.ScopeProperties, authz.RoleAgent); err != nil {
+		return nil, err
+	}
+
+	if in.UpdatedAt.IsZero() {
+		return nil, &errs.Error{
+			Code:    errs.InvalidArgument,
+			Message: "updatedAt is required",
+		}
+	}
+
+	cols, vals := in.assignments()
+	if len(cols) == 0 {
+		return nil, &errs.Error{
+			Code:    errs.InvalidArgument,
+			Message: "no fields to update",
+		}
+	}
+
+	sets := make([]string, 0, len(cols)+1)
+	for i, col := range cols {
+		sets = append(sets, fmt.Sprintf("%s = $%d", col, i+1))
+	}
+	sets = append(sets, fmt.Sprintf("updated_at = $%d", len(vals)+1))
+	vals = append(vals, time.Now(), id, in.UpdatedAt)
+
+	result, err := db.Exec(ctx, fmt.Sprintf(`
+		UPDATE properties SET %s
+		WHERE id = $%d AND updated_at = $%d
+	`, strings.Join(sets, ", "), len(vals)-1, len(vals)), vals...)
+	if err != nil {
+		return nil, apierror.E("could not update property", err, errs.Internal)
+	}
+
+	if result.RowsAffected() == 0 {
+		exists, err := propertyExists(ctx, id)
+		if err != nil {
+			return nil, apierror.E("could not check property existence", err, errs.Internal)
+		}
+
+		if !exists {
+			return nil, &errs.Error{
+				Code:    errs.NotFound,
+				Message: "property not found",
+			}
+		}
+		return nil, &errs.Error{
+			Code:    errs.FailedPrecondition,
+			Message: "property was modified since updatedAt",
+		}
+	}
+
+	prop, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := publishChange(ctx, ChangeUpdated, prop.ID, prop.Reference); err != nil {
+		return nil, apierror.E("could not publish property change", err, errs.Internal)
+	}
+	return prop, nil
+}
+
+//encore:api auth method=DELETE path=/properties/:id
+func (s *Service) DeleteByID(ctx context.Context, id string) error {
+	if err := authz.Require(authz.ScopeProperties, authz.RoleAdmin); err != nil {
+		return err
+	}
+
+	keys, err := mediaKeys(ctx, id)
+	if err != nil {
+		return apierror.E("could not fetch property media", err, errs.Internal)
+	}
+
+	var ref string
+	if err := db.QueryRow(ctx, `
+		DELETE FROM properties WHERE id = $1 RETURNING reference
+	`, id).Scan(&ref); err != nil {
+		if errors.Is(err, sqldb.ErrNoRows) {
+			return &errs.Error{
+				Code:    errs.NotFound,
+				Message: "property not found",
+			}
+		}
+		return apierror.E("could not delete property", err, errs.Internal)
+	}
+
+	s.removeMediaObjects(ctx, keys)
+
+	if err := publishChange(ctx, ChangeDeleted, id, ref); err != nil {
+		return apierror.E("could not publish property change", err, errs.Internal)
+	}
+	return nil
+}
+
+// Serve renders the page of a property and records the view. Off-market
+// properties are only shown through a valid share link, and the opens of
+// share links are logged.
+//
+//encore:api public raw method=GET path=/properties/:ref
 func (s *Service) Serve(w http.ResponseWriter, req *http.Request) {
 	ref := req.URL.Path[len("/properties/"):]
 
@@ -134,6 +355,28 @@ func (s *Service) Serve(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	link, ok := serveShareLink(w, req, prop)
+	if !ok {
+		return
+	}
+
+	var leadID string
+	if link != nil {
+		leadID = link.leadID
+	}
+
+	// Visitors of an off-market property only get to its media through
+	// URLs signed for as long as their share link.
+	if prop.OffMarket {
+		signMediaURLs(secrets.ShareLinkSecret, prop.Media, link.expiresAt)
+	}
+
+	// The page is still shown when the view can't be recorded.
+	chat := viewChat(secrets.ShareLinkSecret, req.URL.Query().Get(chatParam))
+	if err := recordView(req.Context(), prop.ID, leadID, chat, req.UserAgent()); err != nil {
+		rlog.Error("could not record property view", "property_id", prop.ID, "error", err)
+	}
+
 	w.Header().Set("Content-Type", "text/html")
 	if err := s.templ.ExecuteTemplate(w, "property", prop); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -141,12 +384,38 @@ func (s *Service) Serve(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
-//encore:api public method=DELETE path=/properties
+//encore:api auth method=DELETE path=/properties
 func (s *Service) Delete(ctx context.Context) error {
+	if err := authz.Require(authz.ScopeProperties, authz.RoleAdmin); err != nil {
+		return err
+	}
+
+	keys, err := mediaKeys(ctx)
+	if err != nil {
+		return apierror.E("could not fetch property media", err, errs.Internal)
+	}
+
 	// Use DELETE instead of TRUNCATE since we don't have TRUNCATE permissions
-	if _, err := db.Exec(ctx, `DELETE FROM properties`); err != nil {
+	rows, err := db.Query(ctx, `DELETE FROM properties RETURNING id, reference`)
+	if err != nil {
 		return fmt.Errorf("could not delete properties: %w", err)
 	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id, ref string
+		if err := rows.Scan(&id, &ref); err != nil {
+			return fmt.Errorf("could not scan deleted property: %w", err)
+		}
+		if err := publishChange(ctx, ChangeDeleted, id, ref); err != nil {
+			return apierror.E("could not publish property change", err, errs.Internal)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("could not iterate deleted properties: %w", err)
+	}
+
+	s.removeMediaObjects(ctx, keys)
 	return nil
 }
 
@@ -169,14 +438,14 @@ func updateProperty(ctx context.Context, prop *Property) error {
 			num_garage_spots = $5, price = $6, street = $7, number = $8,
 			district = $9, city = $10, state = $11, property_type = $12,
 			reference = $13, description = $14, year_built = $15,
-			builder = $16, features = $17, updated_at = $18
-		WHERE id = $19
+			builder = $16, features = $17, off_market = $18, updated_at = $19
+		WHERE id = $20
 	`,
 		prop.Name, prop.Area, prop.NumBedrooms, prop.NumBathrooms,
 		prop.NumGarageSpots, prop.Price, prop.Street, prop.Number,
 		prop.District, prop.City, prop.State, prop.PropertyType,
 		prop.Reference, prop.Description, prop.YearBuilt,
-		prop.Builder, prop.Features, time.Now(), prop.ID,
+		prop.Builder, prop.Features, prop.OffMarket, time.Now(), prop.ID,
 	)
 	return err
 }
@@ -189,18 +458,18 @@ func insertProperty(ctx context.Context, prop *Property) error {
 			num_garage_spots, price, street, number,
 			district, city, state, property_type,
 			reference, description, year_built,
-			builder, features, created_at, updated_at
+			builder, features, off_market, created_at, updated_at
 		) VALUES (
 			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10,
 			$11, $12, $13, $14, $15, $16, $17, $18,
-			$19, $20
+			$19, $20, $21
 		)
 	`,
 		prop.ID, prop.Name, prop.Area, prop.NumBedrooms, prop.NumBathrooms,
 		prop.NumGarageSpots, prop.Price, prop.Street, prop.Number,
 		prop.District, prop.City, prop.State, prop.PropertyType,
 		prop.Reference, prop.Description, prop.YearBuilt,
-		prop.Builder, prop.Features, now, now,
+		prop.Builder, prop.Features, prop.OffMarket, now, now,
 	); err != nil {
 		return fmt.Errorf("could not insert property: %w", err)
 	}
@@ -208,27 +477,33 @@ func insertProperty(ctx context.Context, prop *Property) error {
 }
 
 func (s *Service) fetchProperty(ctx context.Context, ref string) (*Property, error) {
+	return s.fetchPropertyBy(ctx, "reference", ref)
+}
+
+func (s *Service) fetchPropertyByID(ctx context.Context, id string) (*Property, error) {
+	return s.fetchPropertyBy(ctx, "id", id)
+}
+
+// fetchPropertyBy returns the property whose column matches value,
+// or nil if there is none. The column must be a trusted constant.
+func (s *Service) fetchPropertyBy(ctx context.Context, column, value string) (*Property, error) {
 	query := `
         SELECT 
             id, name, area, num_bedrooms, num_bathrooms, num_garage_spots, 
             price, street, number, district, city, state, property_type,
-            reference, description, year_built, builder, features,
-            photo_base64_data, photo_format, photo_upload_date,
-            blueprint_base64_data, blueprint_format, blueprint_upload_date,
+            reference, description, year_built, builder, features, off_market,
             created_at, updated_at
         FROM properties
-        WHERE reference = $1
+        WHERE ` + column + ` = $1
         LIMIT 1`
 
 	var p Property
 
-	row := db.QueryRow(ctx, query, ref)
+	row := db.QueryRow(ctx, query, value)
 	if err := row.Scan(
 		&p.ID, &p.Name, &p.Area, &p.NumBedrooms, &p.NumBathrooms, &p.NumGarageSpots,
 		&p.Price, &p.Street, &p.Number, &p.District, &p.City, &p.State, &p.PropertyType,
-		&p.Reference, &p.Description, &p.YearBuilt, &p.Builder, &p.Features,
-		&p.PhotoBase64Data, &p.PhotoFormat, &p.PhotoUploadDate,
-		&p.BlueprintBase64Data, &p.BlueprintFormat, &p.BlueprintUploadDate,
+		&p.Reference, &p.Description, &p.YearBuilt, &p.Builder, &p.Features, &p.OffMarket,
 		&p.CreatedAt, &p.UpdatedAt,
 	); err != nil {
 		if errors.Is(err, sqldb.ErrNoRows) {
@@ -237,6 +512,12 @@ func (s *Service) fetchProperty(ctx context.Context, ref string) (*Property, err
 		return nil, fmt.Errorf("could not scan property: %w", err)
 	}
 
+	media, err := listMedia(ctx, p.ID)
+	if err != nil {
+		return nil, err
+	}
+	p.Media = media[p.ID]
+
 	return &p, nil
 }
 
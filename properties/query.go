@@ -0,0 +1,217 @@
+package properties
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultListLimit = 50
+	maxListLimit     = 200
+
+	sortByPrice     = "price"
+	sortByArea      = "area"
+	sortByCreatedAt = "created_at"
+
+	orderAsc  = "asc"
+	orderDesc = "desc"
+)
+
+// listCursor is the position of the last row of a page, encoded
+// as an opaque string in Properties.NextCursor.
+type listCursor struct {
+	Value string `json:"v"`
+	ID    string `json:"id"`
+}
+
+func (c listCursor) encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (*listCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor encoding: %w", err)
+	}
+
+	var c listCursor
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("invalid cursor payload: %w", err)
+	}
+	return &c, nil
+}
+
+// listQuery is a SELECT over properties built from a ListInput.
+type listQuery struct {
+	sql    string
+	args   []any
+	sortBy string
+	limit  int
+}
+
+// cursorFor returns the cursor pointing after p for this query's sort order.
+func (q *listQuery) cursorFor(p *Property) string {
+	var value string
+	switch q.sortBy {
+	case sortByPrice:
+		value = strconv.FormatFloat(p.Price, 'f', -1, 64)
+	case sortByArea:
+		value = strconv.FormatFloat(p.Area, 'f', -1, 64)
+	default:
+		value = p.CreatedAt.Format(time.RFC3339Nano)
+	}
+	return listCursor{Value: value, ID: p.ID}.encode()
+}
+
+func buildListQuery(in ListInput) (*listQuery, error) {
+	sortBy := in.SortBy
+	if sortBy == "" {
+		sortBy = sortByCreatedAt
+	}
+	if sortBy != sortByPrice && sortBy != sortByArea && sortBy != sortByCreatedAt {
+		return nil, fmt.Errorf("invalid sort_by %q: must be one of price, area, created_at", in.SortBy)
+	}
+
+	order := strings.ToLower(in.Order)
+	if order == "" {
+		order = orderDesc
+	}
+	if order != orderAsc && order != orderDesc {
+		return nil, fmt.Errorf("invalid order %q: must be asc or desc", in.Order)
+	}
+
+	limit := in.Limit
+	if limit <= 0 {
+		limit = defaultListLimit
+	}
+	if limit > maxListLimit {
+		limit = maxListLimit
+	}
+
+	if in.MinPrice > 0 && in.MaxPrice > 0 && in.MinPrice > in.MaxPrice {
+		return nil, fmt.Errorf("min_price must not be greater than max_price")
+	}
+	if in.MinArea > 0 && in.MaxArea > 0 && in.MinArea > in.MaxArea {
+		return nil, fmt.Errorf("min_area must not be greater than max_area")
+	}
+
+	var args []any
+
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	// Off-market properties are only shown through share links, unless
+	// an authenticated caller asks for them.
+	var conds []string
+	if !in.IncludeOffMarket {
+		conds = append(conds, "NOT off_market")
+	}
+
+	if in.PropertyType != "" {
+		conds = append(conds, "LOWER(property_type) = LOWER("+arg(in.PropertyType)+")")
+	}
+	if in.District != "" {
+		conds = append(conds, "LOWER(district) = LOWER("+arg(in.District)+")")
+	}
+	if in.City != "" {
+		conds = append(conds, "LOWER(city) = LOWER("+arg(in.City)+")")
+	}
+	if in.MinPrice > 0 {
+		conds = append(conds, "price >= "+arg(in.MinPrice))
+	}
+	if in.MaxPrice > 0 {
+		conds = append(conds, "price <= "+arg(in.MaxPrice))
+	}
+	if in.MinBedrooms > 0 {
+		conds = append(conds, "num_bedrooms >= "+arg(in.MinBedrooms))
+	}
+	if in.MinBathrooms > 0 {
+		conds = append(conds, "num_bathrooms >= "+arg(in.MinBathrooms))
+	}
+	if in.MinGarageSpots > 0 {
+		conds = append(conds, "num_garage_spots >= "+arg(in.MinGarageSpots))
+	}
+	if in.MinArea > 0 {
+		conds = append(conds, "area >= "+arg(in.MinArea))
+	}
+	if in.MaxArea > 0 {
+		conds = append(conds, "area <= "+arg(in.MaxArea))
+	}
+	if len(in.Features) > 0 {
+		conds = append(conds, "features @> "+arg(in.Features)+"::VARCHAR[]")
+	}
+	if text := strings.TrimSpace(in.Text); text != "" {
+		pattern := "%" + escapeLike(text) + "%"
+		p := arg(pattern)
+		conds = append(conds, "(name ILIKE "+p+" OR description ILIKE "+p+")")
+	}
+
+	if in.Cursor != "" {
+		cursor, err := decodeCursor(in.Cursor)
+		if err != nil {
+			return nil, err
+		}
+
+		var value any
+		switch sortBy {
+		case sortByPrice, sortByArea:
+			f, err := strconv.ParseFloat(cursor.Value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("cursor does not match sort_by %q", sortBy)
+			}
+			value = f
+		default:
+			t, err := time.Parse(time.RFC3339Nano, cursor.Value)
+			if err != nil {
+				return nil, fmt.Errorf("cursor does not match sort_by %q", sortBy)
+			}
+			value = t
+		}
+
+		cmp := "<"
+		if order == orderAsc {
+			cmp = ">"
+		}
+		conds = append(conds, fmt.Sprintf("(%s, id) %s (%s, %s)", sortBy, cmp, arg(value), arg(cursor.ID)))
+	}
+
+	query := `SELECT 
+        id, name, area, num_bedrooms, num_bathrooms, num_garage_spots, 
+        price, street, number, district, city, state, property_type,
+        reference, description, year_built, builder, features, off_market`
+
+	if in.WithBase64Images {
+		query += `,
+        photo_base64_data, photo_format, photo_upload_date,
+        blueprint_base64_data, blueprint_format, blueprint_upload_date`
+	}
+
+	query += `,
+        created_at, updated_at
+        FROM properties`
+
+	if len(conds) > 0 {
+		query += "\n        WHERE " + strings.Join(conds, " AND ")
+	}
+
+	// We fetch one extra row to know whether there is a next page.
+	query += fmt.Sprintf("\n        ORDER BY %s %s, id %s\n        LIMIT %d", sortBy, order, order, limit+1)
+
+	return &listQuery{
+		sql:    query,
+		args:   args,
+		sortBy: sortBy,
+		limit:  limit,
+	}, nil
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,133 @@
+package properties
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildListQuery(t *testing.T) {
+	t.Parallel()
+
+	t.Run("defaults", func(t *testing.T) {
+		t.Parallel()
+
+		q, err := buildListQuery(ListInput{})
+		require.NoError(t, err)
+
+		assert.Equal(t, sortByCreatedAt, q.sortBy)
+		assert.Equal(t, defaultListLimit, q.limit)
+		assert.Empty(t, q.args)
+		assert.Contains(t, q.sql, "ORDER BY created_at desc, id desc")
+		assert.Contains(t, q.sql, "LIMIT 51")
+		assert.NotContains(t, q.sql, "photo_base64_data")
+		assert.Contains(t, q.sql, "WHERE NOT off_market")
+	})
+
+	t.Run("off-market properties on request", func(t *testing.T) {
+		t.Parallel()
+
+		q, err := buildListQuery(ListInput{IncludeOffMarket: true})
+		require.NoError(t, err)
+		assert.NotContains(t, q.sql, "NOT off_market")
+		assert.NotContains(t, q.sql, "WHERE")
+
+		q, err = buildListQuery(ListInput{IncludeOffMarket: true, City: "Aracaju"})
+		require.NoError(t, err)
+		assert.Contains(t, q.sql, "WHERE LOWER(city) = LOWER($1)")
+	})
+
+	t.Run("limit is capped", func(t *testing.T) {
+		t.Parallel()
+
+		q, err := buildListQuery(ListInput{Limit: 1000})
+		require.NoError(t, err)
+
+		assert.Equal(t, maxListLimit, q.limit)
+		assert.Contains(t, q.sql, "LIMIT 201")
+	})
+
+	t.Run("filters", func(t *testing.T) {
+		t.Parallel()
+
+		q, err := buildListQuery(ListInput{
+			City:        "Aracaju",
+			MinPrice:    100000,
+			MaxPrice:    500000,
+			MinBedrooms: 2,
+			Features:    []string{"piscina"},
+			Text:        "50%_off",
+		})
+		require.NoError(t, err)
+
+		assert.Contains(t, q.sql, "LOWER(city) = LOWER($1)")
+		assert.Contains(t, q.sql, "price >= $2")
+		assert.Contains(t, q.sql, "price <= $3")
+		assert.Contains(t, q.sql, "num_bedrooms >= $4")
+		assert.Contains(t, q.sql, "features @> $5::VARCHAR[]")
+		assert.Contains(t, q.sql, "(name ILIKE $6 OR description ILIKE $6)")
+		assert.Equal(t, []any{"Aracaju", 100000.0, 500000.0, 2, []string{"piscina"}, `%50\%\_off%`}, q.args)
+	})
+
+	t.Run("cursor continues after the last row", func(t *testing.T) {
+		t.Parallel()
+
+		first, err := buildListQuery(ListInput{SortBy: sortByPrice, Order: "ASC"})
+		require.NoError(t, err)
+
+		cursor := first.cursorFor(&Property{ID: "p2", Price: 350000.5})
+
+		q, err := buildListQuery(ListInput{SortBy: sortByPrice, Order: "asc", Cursor: cursor})
+		require.NoError(t, err)
+
+		assert.Contains(t, q.sql, "(price, id) > ($1, $2)")
+		assert.Contains(t, q.sql, "ORDER BY price asc, id asc")
+		assert.Equal(t, []any{350000.5, "p2"}, q.args)
+	})
+
+	t.Run("created_at cursor", func(t *testing.T) {
+		t.Parallel()
+
+		createdAt := time.Date(2024, 12, 20, 15, 4, 5, 123456789, time.UTC)
+
+		first, err := buildListQuery(ListInput{})
+		require.NoError(t, err)
+
+		q, err := buildListQuery(ListInput{Cursor: first.cursorFor(&Property{ID: "p1", CreatedAt: createdAt})})
+		require.NoError(t, err)
+
+		assert.Contains(t, q.sql, "(created_at, id) < ($1, $2)")
+		require.Len(t, q.args, 2)
+		assert.True(t, createdAt.Equal(q.args[0].(time.Time)))
+	})
+
+	t.Run("invalid input", func(t *testing.T) {
+		t.Parallel()
+
+		priceCursor := listCursor{Value: "100", ID: "p1"}.encode()
+
+		for name, in := range map[string]ListInput{
+			"sort_by":         {SortBy: "name"},
+			"order":           {Order: "up"},
+			"price range":     {MinPrice: 10, MaxPrice: 5},
+			"area range":      {MinArea: 10, MaxArea: 5},
+			"cursor encoding": {Cursor: "not base64!"},
+			"cursor payload":  {Cursor: "bm90IGpzb24"},
+			"time cursor":     {Cursor: priceCursor},
+			"area cursor":     {SortBy: sortByArea, Cursor: listCursor{Value: "yesterday", ID: "p1"}.encode()},
+		} {
+			_, err := buildListQuery(in)
+			assert.Error(t, err, name)
+		}
+	})
+}
+
+func TestEscapeLike(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, `100\% \_a\\b`, escapeLike(`100% _a\b`))
+	assert.False(t, strings.ContainsAny(escapeLike("plain"), `\`))
+}
@@ -0,0 +1,283 @@
+package properties
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"encore.app/internal/pkg/apierror"
+	"encore.app/internal/pkg/authz"
+
+	"encore.dev"
+	"encore.dev/beta/errs"
+	"encore.dev/rlog"
+)
+
+const (
+	defaultShareLinkTTL = 7 * 24 * time.Hour
+	maxShareLinkTTL     = 90 * 24 * time.Hour
+
+	// Query parameters of share links.
+	shareLeadParam      = "lead"
+	shareExpiresParam   = "exp"
+	shareSignatureParam = "sig"
+)
+
+var (
+	errShareLinkInvalid = errors.New("invalid share link")
+	errShareLinkExpired = errors.New("share link expired")
+)
+
+// CreateShareLink returns a signed link to the page of a property, which
+// stops working once it expires. Off-market properties can only be opened
+// through such links.
+//
+//encore:api auth method=POST path=/properties/:id/share-link
+func (s *Service) CreateShareLink(ctx context.Context, id string, in *ShareLinkInput) (*ShareLink, error) {
+	if err := authz.Require(authz.ScopeProperties, authz.RoleAgent); err != nil {
+		return nil, err
+	}
+
+	if secrets.ShareLinkSecret == "" {
+		return nil, &errs.Error{
+			Code:    errs.FailedPrecondition,
+			Message: "share links are not configured",
+		}
+	}
+
+	ttl := defaultShareLinkTTL
+	if in.ExpiresIn != "" {
+		var err error
+		if ttl, err = time.ParseDuration(in.ExpiresIn); err != nil || ttl <= 0 {
+			return nil, &errs.Error{
+				Code:    errs.InvalidArgument,
+				Message: fmt.Sprintf("invalid expiresIn %q", in.ExpiresIn),
+			}
+		}
+		ttl = min(ttl, maxShareLinkTTL)
+	}
+
+	prop, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	expiresAt := time.Now().Add(ttl).Truncate(time.Second)
+	query := shareLinkQuery(secrets.ShareLinkSecret, prop.ID, in.LeadID, expiresAt)
+
+	base := encore.Meta().APIBaseURL
+	return &ShareLink{
+		URL:        fmt.Sprintf("%s://%s/properties/%s?%s", base.Scheme, base.Host, url.PathEscape(prop.Reference), query.Encode()),
+		PropertyID: prop.ID,
+		LeadID:     in.LeadID,
+		ExpiresAt:  expiresAt,
+	}, nil
+}
+
+// ListLinkOpens lists the opens of share links, so agents know which lead
+// viewed which property.
+//
+//encore:api auth method=GET path=/properties/share-links/opens
+func (s *Service) ListLinkOpens(ctx context.Context, in ListLinkOpensInput) (*LinkOpens, error) {
+	if err := authz.Require(authz.ScopeProperties, authz.RoleReadOnly); err != nil {
+		return nil, err
+	}
+
+	limit := in.Limit
+	if limit <= 0 {
+		limit = defaultListLimit
+	}
+	limit = min(limit, maxListLimit)
+
+	rows, err := db.Query(ctx, `
+		SELECT o.id, o.property_id, p.reference, o.lead_id, o.user_agent, o.link_expires_at, o.opened_at
+		FROM property_link_opens o
+		JOIN properties p ON p.id = o.property_id
+		WHERE ($1 = '' OR o.property_id = $1) AND ($2 = '' OR o.lead_id = $2)
+		ORDER BY o.opened_at DESC, o.id DESC
+		LIMIT $3
+	`, in.PropertyID, in.LeadID, limit)
+	if err != nil {
+		return nil, apierror.E("could not list link opens", err, errs.Internal)
+	}
+	defer rows.Close()
+
+	opens := LinkOpens{Opens: make([]*LinkOpen, 0)}
+	for rows.Next() {
+		var o LinkOpen
+		if err := rows.Scan(&o.ID, &o.PropertyID, &o.Reference, &o.LeadID, &o.UserAgent, &o.LinkExpiresAt, &o.OpenedAt); err != nil {
+			return nil, apierror.E("could not scan link open", err, errs.Internal)
+		}
+		opens.Opens = append(opens.Opens, &o)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, apierror.E("could not list link opens", err, errs.Internal)
+	}
+	return &opens, nil
+}
+
+// shareLink is the part of a share link that is signed.
+type shareLink struct {
+	leadID    string
+	expiresAt time.Time
+}
+
+// shareLinkQuery returns the query parameters of a share link.
+func shareLinkQuery(secret, propertyID, leadID string, expiresAt time.Time) url.Values {
+	q := url.Values{}
+	if leadID != "" {
+		q.Set(shareLeadParam, leadID)
+	}
+	q.Set(shareExpiresParam, strconv.FormatInt(expiresAt.Unix(), 10))
+	q.Set(shareSignatureParam, signShareLink(secret, propertyID, leadID, expiresAt))
+	return q
+}
+
+// isShareLink reports whether the query is the one of a share link, even
+// an invalid one.
+func isShareLink(q url.Values) bool {
+	return q.Has(shareSignatureParam) || q.Has(shareExpiresParam) || q.Has(shareLeadParam)
+}
+
+// verifyShareLink checks the signature and expiry of the share link of a
+// property, and returns what it was signed for.
+func verifyShareLink(secret, propertyID string, q url.Values, now time.Time) (*shareLink, error) {
+	// An unset secret would let anyone sign links.
+	if secret == "" {
+		return nil, errShareLinkInvalid
+	}
+
+	exp, err := strconv.ParseInt(q.Get(shareExpiresParam), 10, 64)
+	if err != nil {
+		return nil, errShareLinkInvalid
+	}
+
+	link := shareLink{
+		leadID:    q.Get(shareLeadParam),
+		expiresAt: time.Unix(exp, 0),
+	}
+
+	expected := signShareLink(secret, propertyID, link.leadID, link.expiresAt)
+	if !hmac.Equal([]byte(expected), []byte(q.Get(shareSignatureParam))) {
+		return nil, errShareLinkInvalid
+	}
+
+	// Checked after the signature so tampered links are never reported
+	// as expired.
+	if !now.Before(link.expiresAt) {
+		return nil, errShareLinkExpired
+	}
+	return &link, nil
+}
+
+// signShareLink signs the property ID, rather than its reference, so
+// links stop working if the reference moves to another property.
+func signShareLink(secret, propertyID, leadID string, expiresAt time.Time) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s\n%s\n%d", propertyID, leadID, expiresAt.Unix())
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// signMediaURLs signs the URLs of the media until the given time, so
+// ServeMedia serves them even though their property is off-market.
+func signMediaURLs(secret string, media []*Media, expiresAt time.Time) {
+	for _, m := range media {
+		for _, u := range []*string{&m.URL, &m.ThumbnailURL, &m.MediumURL} {
+			*u = signMediaURL(secret, *u, expiresAt)
+		}
+	}
+}
+
+func signMediaURL(secret, rawURL string, expiresAt time.Time) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+
+	q := url.Values{}
+	q.Set(shareExpiresParam, strconv.FormatInt(expiresAt.Unix(), 10))
+	q.Set(shareSignatureParam, signMedia(secret, strings.TrimPrefix(u.Path, mediaPathPrefix), expiresAt))
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// verifyMediaURL reports whether the query signs the media object key and
+// has not expired.
+func verifyMediaURL(secret, key string, q url.Values, now time.Time) bool {
+	if secret == "" {
+		return false
+	}
+
+	exp, err := strconv.ParseInt(q.Get(shareExpiresParam), 10, 64)
+	if err != nil {
+		return false
+	}
+
+	expected := signMedia(secret, key, time.Unix(exp, 0))
+	return hmac.Equal([]byte(expected), []byte(q.Get(shareSignatureParam))) && now.Before(time.Unix(exp, 0))
+}
+
+// signMedia signs a media object key. Its two lines can't be mistaken for
+// the three lines signed for share links.
+func signMedia(secret, key string, expiresAt time.Time) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "media:%s\n%d", key, expiresAt.Unix())
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// serveShareLink checks the share link a property page is opened with, if
+// any, and logs the open. It writes an error and returns false if the page
+// must not be shown.
+func serveShareLink(w http.ResponseWriter, req *http.Request, prop *Property) (*shareLink, bool) {
+	q := req.URL.Query()
+
+	if !isShareLink(q) {
+		if prop.OffMarket {
+			http.Error(w, "property not found", http.StatusNotFound)
+			return nil, false
+		}
+		return nil, true
+	}
+
+	link, err := verifyShareLink(secrets.ShareLinkSecret, prop.ID, q, time.Now())
+	if err != nil {
+		if errors.Is(err, errShareLinkExpired) {
+			http.Error(w, "this link has expired", http.StatusGone)
+			return nil, false
+		}
+		http.Error(w, "invalid link", http.StatusForbidden)
+		return nil, false
+	}
+
+	// The page is still shown when the open can't be logged.
+	if err := recordLinkOpen(req.Context(), prop.ID, link, req.UserAgent()); err != nil {
+		rlog.Error("could not record share link open", "property_id", prop.ID, "lead_id", link.leadID, "error", err)
+	}
+	return link, true
+}
+
+func recordLinkOpen(ctx context.Context, propertyID string, link *shareLink, userAgent string) error {
+	var leadID *string
+	if link.leadID != "" {
+		leadID = &link.leadID
+	}
+
+	if _, err := db.Exec(ctx, `
+		INSERT INTO property_link_opens (property_id, lead_id, user_agent, link_expires_at)
+		VALUES ($1, $2, $3, $4)
+	`, propertyID, leadID, userAgent, link.expiresAt); err != nil {
+		return fmt.Errorf("could not insert link open: %w", err)
+	}
+
+	rlog.Info("share link opened", "property_id", propertyID, "lead_id", link.leadID)
+	return nil
+}
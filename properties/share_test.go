@@ -0,0 +1,148 @@
+package properties
+
+import (
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyShareLink(t *testing.T) {
+	t.Parallel()
+
+	const (
+		secret     = "share-secret"
+		propertyID = "01JFKQ3Z8V6Y2T5N4M3K2J1H0G"
+		leadID     = "01JFKQ4A2B3C4D5E6F7G8H9J0K"
+	)
+
+	now := time.Date(2025, 1, 10, 12, 0, 0, 0, time.UTC)
+	expiresAt := now.Add(time.Hour)
+
+	// verifyInput is what verifyShareLink is called with.
+	type verifyInput struct {
+		secret, propertyID string
+		q                  url.Values
+	}
+
+	tests := map[string]struct {
+		change func(in *verifyInput)
+		want   error
+	}{
+		"valid link": {
+			change: func(*verifyInput) {},
+		},
+		"valid link without lead": {
+			change: func(in *verifyInput) { in.q = shareLinkQuery(secret, propertyID, "", expiresAt) },
+		},
+		"tampered lead": {
+			change: func(in *verifyInput) { in.q.Set(shareLeadParam, "01JFKQ4A2B3C4D5E6F7G8H9J0L") },
+			want:   errShareLinkInvalid,
+		},
+		"removed lead": {
+			change: func(in *verifyInput) { in.q.Del(shareLeadParam) },
+			want:   errShareLinkInvalid,
+		},
+		"tampered exp": {
+			change: func(in *verifyInput) {
+				in.q.Set(shareExpiresParam, strconv.FormatInt(expiresAt.Add(24*time.Hour).Unix(), 10))
+			},
+			want: errShareLinkInvalid,
+		},
+		"malformed exp": {
+			change: func(in *verifyInput) { in.q.Set(shareExpiresParam, "tomorrow") },
+			want:   errShareLinkInvalid,
+		},
+		"tampered sig": {
+			change: func(in *verifyInput) {
+				in.q.Set(shareSignatureParam, signShareLink("other-secret", propertyID, leadID, expiresAt))
+			},
+			want: errShareLinkInvalid,
+		},
+		"missing sig": {
+			change: func(in *verifyInput) { in.q.Del(shareSignatureParam) },
+			want:   errShareLinkInvalid,
+		},
+		"expired link": {
+			change: func(in *verifyInput) { in.q = shareLinkQuery(secret, propertyID, leadID, now.Add(-time.Second)) },
+			want:   errShareLinkExpired,
+		},
+		"link expiring now": {
+			change: func(in *verifyInput) { in.q = shareLinkQuery(secret, propertyID, leadID, now) },
+			want:   errShareLinkExpired,
+		},
+		"wrong property ID": {
+			change: func(in *verifyInput) { in.propertyID = "01JFKQ3Z8V6Y2T5N4M3K2J1H0H" },
+			want:   errShareLinkInvalid,
+		},
+		"empty secret": {
+			change: func(in *verifyInput) {
+				in.secret = ""
+				in.q = shareLinkQuery("", propertyID, leadID, expiresAt)
+			},
+			want: errShareLinkInvalid,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			in := verifyInput{
+				secret:     secret,
+				propertyID: propertyID,
+				q:          shareLinkQuery(secret, propertyID, leadID, expiresAt),
+			}
+			tc.change(&in)
+
+			link, err := verifyShareLink(in.secret, in.propertyID, in.q, now)
+			if tc.want != nil {
+				assert.ErrorIs(t, err, tc.want)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, in.q.Get(shareLeadParam), link.leadID)
+			assert.True(t, expiresAt.Equal(link.expiresAt))
+		})
+	}
+}
+
+func TestSignMediaURLs(t *testing.T) {
+	t.Parallel()
+
+	const secret = "share-secret"
+
+	now := time.Date(2025, 1, 10, 12, 0, 0, 0, time.UTC)
+	expiresAt := now.Add(time.Hour)
+
+	m := &Media{
+		URL:          "https://api.example.com/properties/media/p1/m1.png",
+		ThumbnailURL: "https://api.example.com/properties/media/p1/m1.png_thumb.jpg",
+		MediumURL:    "https://api.example.com/properties/media/p1/m1.png_medium.jpg",
+	}
+	signMediaURLs(secret, []*Media{m}, expiresAt)
+
+	for key, rawURL := range map[string]string{
+		"p1/m1.png":            m.URL,
+		"p1/m1.png_thumb.jpg":  m.ThumbnailURL,
+		"p1/m1.png_medium.jpg": m.MediumURL,
+	} {
+		u, err := url.Parse(rawURL)
+		require.NoError(t, err)
+
+		assert.Equal(t, mediaPathPrefix+key, u.Path)
+		assert.True(t, verifyMediaURL(secret, key, u.Query(), now), key)
+		assert.False(t, verifyMediaURL(secret, key, u.Query(), expiresAt), "%s expired", key)
+		assert.False(t, verifyMediaURL(secret, "p1/m2.png", u.Query(), now), "%s for another key", key)
+		assert.False(t, verifyMediaURL("", key, u.Query(), now), "%s without secret", key)
+	}
+
+	// A share link signature does not sign media.
+	q := shareLinkQuery(secret, "media", "p1/m1.png", expiresAt)
+	q.Del(shareLeadParam)
+	assert.False(t, verifyMediaURL(secret, "p1/m1.png", q, now))
+}
@@ -0,0 +1,69 @@
+package session
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+var _ Store = (*MemoryStore)(nil)
+
+// MemoryStore is an in-memory Store. Sessions are lost on restart,
+// so it is meant for tests and local experiments.
+type MemoryStore struct {
+	mu       sync.RWMutex
+	sessions map[string]*Session
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		sessions: make(map[string]*Session),
+	}
+}
+
+func (m *MemoryStore) Get(_ context.Context, userID string) (*Session, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	sess, ok := m.sessions[userID]
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+
+	cp := *sess
+	return &cp, nil
+}
+
+func (m *MemoryStore) GetByThreadID(_ context.Context, threadID string) (*Session, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, sess := range m.sessions {
+		if sess.ThreadID == threadID {
+			cp := *sess
+			return &cp, nil
+		}
+	}
+	return nil, ErrSessionNotFound
+}
+
+func (m *MemoryStore) Save(_ context.Context, sess *Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cp := *sess
+	m.sessions[sess.UserID] = &cp
+	return nil
+}
+
+func (m *MemoryStore) DeleteExpired(_ context.Context, threshold time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for userID, sess := range m.sessions {
+		if sess.LastAccessedAt.Before(threshold) {
+			delete(m.sessions, userID)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"encore.dev/storage/sqldb"
+)
+
+var _ Store = (*PostgresStore)(nil)
+
+// PostgresStore is a Store backed by the sessions table, holding the
+// sessions of a single WhatsApp device.
+type PostgresStore struct {
+	db       *sqldb.Database
+	deviceID string
+}
+
+func NewPostgresStore(db *sqldb.Database, deviceID string) *PostgresStore {
+	return &PostgresStore{db: db, deviceID: deviceID}
+}
+
+func (p *PostgresStore) Get(ctx context.Context, userID string) (*Session, error) {
+	return p.scanOne(ctx, `
+		SELECT user_id, thread_id, last_accessed_at, name_collected, collected_name
+		FROM sessions
+		WHERE device_id = $1 AND user_id = $2
+	`, userID)
+}
+
+func (p *PostgresStore) GetByThreadID(ctx context.Context, threadID string) (*Session, error) {
+	return p.scanOne(ctx, `
+		SELECT user_id, thread_id, last_accessed_at, name_collected, collected_name
+		FROM sessions
+		WHERE device_id = $1 AND thread_id = $2
+	`, threadID)
+}
+
+func (p *PostgresStore) Save(ctx context.Context, sess *Session) error {
+	if _, err := p.db.Exec(ctx, `
+		INSERT INTO sessions (device_id, user_id, thread_id, last_accessed_at, name_collected, collected_name)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (device_id, user_id) DO UPDATE SET
+			thread_id = EXCLUDED.thread_id,
+			last_accessed_at = EXCLUDED.last_accessed_at,
+			name_collected = EXCLUDED.name_collected,
+			collected_name = EXCLUDED.collected_name
+	`,
+		p.deviceID, sess.UserID, sess.ThreadID, sess.LastAccessedAt,
+		sess.NameCollected, sess.CollectedName,
+	); err != nil {
+		return fmt.Errorf("could not save session: %w", err)
+	}
+	return nil
+}
+
+func (p *PostgresStore) DeleteExpired(ctx context.Context, threshold time.Time) error {
+	if _, err := p.db.Exec(ctx, `
+		DELETE FROM sessions WHERE device_id = $1 AND last_accessed_at < $2
+	`, p.deviceID, threshold); err != nil {
+		return fmt.Errorf("could not delete expired sessions: %w", err)
+	}
+	return nil
+}
+
+func (p *PostgresStore) scanOne(ctx context.Context, query string, arg string) (*Session, error) {
+	var sess Session
+	if err := p.db.QueryRow(ctx, query, p.deviceID, arg).Scan(
+		&sess.UserID, &sess.ThreadID, &sess.LastAccessedAt,
+		&sess.NameCollected, &sess.CollectedName,
+	); err != nil {
+		if errors.Is(err, sqldb.ErrNoRows) {
+			return nil, ErrSessionNotFound
+		}
+		return nil, fmt.Errorf("could not scan session: %w", err)
+	}
+	return &sess, nil
+}
@@ -0,0 +1,66 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+const maxSearchResults = 10
+
+// SearchCriteria are the typed arguments of the search_properties tool.
+type SearchCriteria struct {
+	PropertyType   string   `json:"property_type,omitempty"`
+	District       string   `json:"district,omitempty"`
+	City           string   `json:"city,omitempty"`
+	MinPrice       float64  `json:"min_price,omitempty"`
+	MaxPrice       float64  `json:"max_price,omitempty"`
+	MinBedrooms    int      `json:"min_bedrooms,omitempty"`
+	MinBathrooms   int      `json:"min_bathrooms,omitempty"`
+	MinGarageSpots int      `json:"min_garage_spots,omitempty"`
+	Features       []string `json:"features,omitempty"`
+	Text           string   `json:"text,omitempty"`
+}
+
+// PropertySummary is what the assistant gets back for each matching property.
+type PropertySummary struct {
+	Reference    string   `json:"referencia"`
+	Name         string   `json:"nome"`
+	PropertyType string   `json:"tipo_imovel"`
+	Price        float64  `json:"preco"`
+	District     string   `json:"bairro"`
+	City         string   `json:"cidade"`
+	Area         float64  `json:"area"`
+	NumBedrooms  int      `json:"quartos"`
+	NumBathrooms int      `json:"banheiros"`
+	Features     []string `json:"caracteristicas,omitempty"`
+}
+
+// PropertySearcher runs search_properties calls against the properties database.
+type PropertySearcher interface {
+	SearchProperties(ctx context.Context, criteria SearchCriteria, limit int) ([]PropertySummary, error)
+}
+
+func (sm *SessionManager) searchProperties(ctx context.Context, arguments string) (string, error) {
+	var criteria SearchCriteria
+	if err := json.Unmarshal([]byte(arguments), &criteria); err != nil {
+		return "", fmt.Errorf("could not parse search_properties arguments: %w", err)
+	}
+
+	results, err := sm.searcher.SearchProperties(ctx, criteria, maxSearchResults)
+	if err != nil {
+		return "", fmt.Errorf("could not search properties: %w", err)
+	}
+
+	// The results are capped, so their number is not the number of
+	// matching properties and is left out.
+	out, err := json.Marshal(struct {
+		Imoveis []PropertySummary `json:"imoveis"`
+	}{
+		Imoveis: results,
+	})
+	if err != nil {
+		return "", fmt.Errorf("could not marshal search results: %w", err)
+	}
+	return string(out), nil
+}
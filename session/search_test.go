@@ -0,0 +1,69 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"encore.app/internal/pkg/openaicli"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeSearcher struct {
+	criteria SearchCriteria
+	limit    int
+	results  []PropertySummary
+	err      error
+}
+
+func (f *fakeSearcher) SearchProperties(_ context.Context, criteria SearchCriteria, limit int) ([]PropertySummary, error) {
+	f.criteria = criteria
+	f.limit = limit
+	return f.results, f.err
+}
+
+func TestSearchProperties(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Passes the criteria and returns the results", func(t *testing.T) {
+		t.Parallel()
+
+		searcher := &fakeSearcher{results: []PropertySummary{{Reference: "REF123", Name: "Casa", Price: 890000}}}
+		sm := NewSessionManager(&openaicli.Assistant{}, &fakeOpenAICli{}, NewMemoryStore(), searcher)
+		defer sm.Close()
+
+		out, err := sm.searchProperties(context.Background(), `{"city": "Aracaju", "min_bedrooms": 3, "features": ["piscina"]}`)
+		require.NoError(t, err)
+
+		assert.Equal(t, SearchCriteria{City: "Aracaju", MinBedrooms: 3, Features: []string{"piscina"}}, searcher.criteria)
+		assert.Equal(t, maxSearchResults, searcher.limit)
+		assert.JSONEq(t, `{"imoveis": [{
+			"referencia": "REF123", "nome": "Casa", "tipo_imovel": "", "preco": 890000,
+			"bairro": "", "cidade": "", "area": 0, "quartos": 0, "banheiros": 0
+		}]}`, out)
+	})
+
+	t.Run("Rejects invalid arguments", func(t *testing.T) {
+		t.Parallel()
+
+		searcher := &fakeSearcher{}
+		sm := NewSessionManager(&openaicli.Assistant{}, &fakeOpenAICli{}, NewMemoryStore(), searcher)
+		defer sm.Close()
+
+		_, err := sm.searchProperties(context.Background(), `{"min_bedrooms": "three"}`)
+		assert.Error(t, err)
+		assert.Zero(t, searcher.limit)
+	})
+
+	t.Run("Returns search errors", func(t *testing.T) {
+		t.Parallel()
+
+		searcher := &fakeSearcher{err: errors.New("database down")}
+		sm := NewSessionManager(&openaicli.Assistant{}, &fakeOpenAICli{}, NewMemoryStore(), searcher)
+		defer sm.Close()
+
+		_, err := sm.searchProperties(context.Background(), `{}`)
+		assert.ErrorIs(t, err, searcher.err)
+	})
+}
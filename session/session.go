@@ -3,15 +3,15 @@ package session
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
 	"sync"
 	"time"
 
 	"encore.app/internal/pkg/openaicli"
-	"encore.app/internal/pkg/trello"
 	"encore.app/leads"
-	"encore.dev/storage/sqldb"
+	"encore.dev/rlog"
 )
 
 const (
@@ -32,50 +32,76 @@ type openaiCli interface {
 	GetRunSteps(ctx context.Context, threadID, runID string) (*openaicli.RunSteps, error)
 }
 
+// Option configures a SessionManager.
+type Option func(*SessionManager)
+
+// WithLeadAgent assigns the leads created in the sessions to an agent,
+// unless they are assigned already.
+func WithLeadAgent(agent string) Option {
+	return func(sm *SessionManager) {
+		sm.leadAgent = agent
+	}
+}
+
 type SessionManager struct {
-	mu              sync.RWMutex
-	sessions        map[string]*Session
+	mu              sync.Mutex // serializes session creation
+	store           Store
+	searcher        PropertySearcher
 	assistant       *openaicli.Assistant
 	openaiCli       openaiCli
+	leadAgent       string
 	cleanupInterval time.Duration
 	sessionTimeout  time.Duration
+	closeOnce       sync.Once
+	done            chan struct{}
 }
 
-func NewSessionManager(assistant *openaicli.Assistant, openaiCli openaiCli) *SessionManager {
+func NewSessionManager(assistant *openaicli.Assistant, openaiCli openaiCli, store Store, searcher PropertySearcher, opts ...Option) *SessionManager {
 	sm := &SessionManager{
-		sessions:        make(map[string]*Session),
+		store:           store,
+		searcher:        searcher,
 		assistant:       assistant,
 		openaiCli:       openaiCli,
-		cleanupInterval: 1 * time.Hour,
-		sessionTimeout:  24 * time.Hour,
+		cleanupInterval: cleanupInterval,
+		sessionTimeout:  sessionTimeout,
+		done:            make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(sm)
 	}
 
 	go sm.cleanupLoop()
 	return sm
 }
 
+// Close stops the cleanup of expired sessions. Messages can still be sent.
+func (sm *SessionManager) Close() {
+	sm.closeOnce.Do(func() { close(sm.done) })
+}
+
 func (sm *SessionManager) cleanupLoop() {
 	ticker := time.NewTicker(sm.cleanupInterval)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		sm.cleanup()
+	for {
+		select {
+		case <-sm.done:
+			return
+		case <-ticker.C:
+			sm.cleanup()
+		}
 	}
 }
 
 func (sm *SessionManager) cleanup() {
-	sm.mu.Lock()
-	defer sm.mu.Unlock()
-
 	threshold := time.Now().Add(-sm.sessionTimeout)
-	for userID, session := range sm.sessions {
-		if session.LastAccessedAt.Before(threshold) {
-			delete(sm.sessions, userID)
-		}
+	if err := sm.store.DeleteExpired(context.Background(), threshold); err != nil {
+		rlog.Error("could not clean up expired sessions", "error", err)
 	}
 }
 
-func (sm *SessionManager) SendMessage(ctx context.Context, db *sqldb.Database, trelloAPI *trello.TrelloAPI, userID, message string) (string, error) {
+func (sm *SessionManager) SendMessage(ctx context.Context, userID, message string) (string, error) {
 	session, err := sm.getOrCreateSession(ctx, userID)
 	if err != nil {
 		return "", fmt.Errorf("could not get or create session: %w", err)
@@ -101,13 +127,13 @@ func (sm *SessionManager) SendMessage(ctx context.Context, db *sqldb.Database, t
 		return "", fmt.Errorf("could not run thread: %w", err)
 	}
 
-	if err := sm.processRun(ctx, db, trelloAPI, session.ThreadID, run.ID); err != nil {
+	if err := sm.processRun(ctx, session.ThreadID, run.ID); err != nil {
 		return "", err
 	}
 	return sm.getAssistantResponse(ctx, session.ThreadID)
 }
 
-func (sm *SessionManager) processRun(ctx context.Context, db *sqldb.Database, trelloAPI *trello.TrelloAPI, threadID, runID string) error {
+func (sm *SessionManager) processRun(ctx context.Context, threadID, runID string) error {
 	for {
 		currentRun, err := sm.openaiCli.GetRun(ctx, threadID, runID)
 		if err != nil {
@@ -121,7 +147,7 @@ func (sm *SessionManager) processRun(ctx context.Context, db *sqldb.Database, tr
 			if currentRun.RequiredAction == nil {
 				return fmt.Errorf("invalid state: requires_action but no action specified")
 			}
-			if err := sm.handleFunctionCalling(ctx, db, trelloAPI, threadID, currentRun); err != nil {
+			if err := sm.handleFunctionCalling(ctx, threadID, currentRun); err != nil {
 				return fmt.Errorf("could not handle function calling: %w", err)
 			}
 			time.Sleep(1 * time.Second)
@@ -163,7 +189,7 @@ func (sm *SessionManager) getAssistantResponse(ctx context.Context, threadID str
 	return finalResponse.String(), nil
 }
 
-func (sm *SessionManager) handleFunctionCalling(ctx context.Context, db *sqldb.Database, trelloAPI *trello.TrelloAPI, threadID string, run *openaicli.Run) error {
+func (sm *SessionManager) handleFunctionCalling(ctx context.Context, threadID string, run *openaicli.Run) error {
 	if run.RequiredAction == nil {
 		return nil
 	}
@@ -200,20 +226,16 @@ func (sm *SessionManager) handleFunctionCalling(ctx context.Context, db *sqldb.D
 				return fmt.Errorf("could not parse lead arguments: %w", err)
 			}
 
-			// Find session by threadID
-			sm.mu.Lock()
-			var userPhone string
-			var session *Session
-			for _, sess := range sm.sessions {
-				if sess.ThreadID == threadID {
-					userPhone = sess.UserID // UserID contains the WhatsApp number
-					session = sess
-					break
+			session, err := sm.store.GetByThreadID(ctx, threadID)
+			if err != nil {
+				if errors.Is(err, ErrSessionNotFound) {
+					return fmt.Errorf("no session found for thread %s", threadID)
 				}
+				return fmt.Errorf("could not get session: %w", err)
 			}
-			sm.mu.Unlock()
 
-			if session == nil {
+			userPhone := session.UserID // UserID contains the WhatsApp number
+			if userPhone == "" {
 				return fmt.Errorf("no session found for thread %s", threadID)
 			}
 
@@ -221,21 +243,39 @@ func (sm *SessionManager) handleFunctionCalling(ctx context.Context, db *sqldb.D
 			session.NameCollected = true
 			session.CollectedName = args.Name
 
-			if userPhone == "" {
-				return fmt.Errorf("no session found for thread %s", threadID)
+			if err := sm.store.Save(ctx, session); err != nil {
+				return fmt.Errorf("could not save session: %w", err)
 			}
 
-			if err := leads.CreateLead(ctx, db, trelloAPI, &leads.CreateLeadInput{
+			output := "Lead created successfully"
+			if _, err := leads.CreateLead(ctx, &leads.CreateLeadInput{
 				Name: args.Name,
 				// Clean up the phone number by removing the WhatsApp suffix.
-				Phone: strings.Split(strings.Split(userPhone, "@")[0], ":")[0],
+				Phone:         strings.Split(strings.Split(userPhone, "@")[0], ":")[0],
+				AssignedAgent: sm.leadAgent,
 			}); err != nil {
-				return fmt.Errorf("could not create lead: %w", err)
+				// As for search_properties, the run must still receive an
+				// output, and the assistant must not claim the lead was saved.
+				rlog.Error("could not create lead", "error", err)
+				output = `{"erro": "não foi possível registrar o contato no momento"}`
+			}
+
+			toolOutputs = append(toolOutputs, openaicli.ToolOutput{
+				ToolCallID: toolCall.ID,
+				Output:     output,
+			})
+		case "search_properties":
+			output, err := sm.searchProperties(ctx, toolCall.Function.Arguments)
+			if err != nil {
+				// The run must still receive an output, so we report
+				// the failure to the assistant instead of aborting it.
+				rlog.Error("could not run search_properties", "error", err)
+				output = `{"erro": "não foi possível buscar imóveis no momento"}`
 			}
 
 			toolOutputs = append(toolOutputs, openaicli.ToolOutput{
 				ToolCallID: toolCall.ID,
-				Output:     "Lead created successfully",
+				Output:     output,
 			})
 		}
 	}
@@ -252,9 +292,20 @@ func (sm *SessionManager) getOrCreateSession(ctx context.Context, userID string)
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
-	if session, exists := sm.sessions[userID]; exists {
-		session.LastAccessedAt = time.Now()
-		return session, nil
+	session, err := sm.store.Get(ctx, userID)
+	switch {
+	case err == nil:
+		// Sessions past the timeout are treated as gone even if
+		// the cleanup loop has not removed them yet.
+		if time.Since(session.LastAccessedAt) < sm.sessionTimeout {
+			session.LastAccessedAt = time.Now()
+			if err := sm.store.Save(ctx, session); err != nil {
+				return nil, fmt.Errorf("could not save session: %w", err)
+			}
+			return session, nil
+		}
+	case !errors.Is(err, ErrSessionNotFound):
+		return nil, fmt.Errorf("could not get session: %w", err)
 	}
 
 	thread, err := sm.openaiCli.CreateThread(ctx)
@@ -267,7 +318,9 @@ func (sm *SessionManager) getOrCreateSession(ctx context.Context, userID string)
 		UserID:         userID,
 		LastAccessedAt: time.Now(),
 	}
-	sm.sessions[userID] = &sess
 
+	if err := sm.store.Save(ctx, &sess); err != nil {
+		return nil, fmt.Errorf("could not save session: %w", err)
+	}
 	return &sess, nil
 }
@@ -0,0 +1,112 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"encore.app/internal/pkg/openaicli"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeOpenAICli struct {
+	openaiCli
+	threads int
+}
+
+func (f *fakeOpenAICli) CreateThread(_ context.Context) (*openaicli.Thread, error) {
+	f.threads++
+	return &openaicli.Thread{ID: fmt.Sprintf("thread_%d", f.threads)}, nil
+}
+
+func TestGetOrCreateSession(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Reuses session across managers sharing a store", func(t *testing.T) {
+		t.Parallel()
+
+		store := NewMemoryStore()
+		cli := &fakeOpenAICli{}
+
+		first := NewSessionManager(&openaicli.Assistant{}, cli, store, nil)
+		sess, err := first.getOrCreateSession(context.Background(), "5579999999999@s.whatsapp.net")
+		require.NoError(t, err)
+
+		sess.NameCollected = true
+		sess.CollectedName = "Maria"
+		require.NoError(t, store.Save(context.Background(), sess))
+
+		// A new manager simulates a restart.
+		second := NewSessionManager(&openaicli.Assistant{}, cli, store, nil)
+		got, err := second.getOrCreateSession(context.Background(), "5579999999999@s.whatsapp.net")
+		require.NoError(t, err)
+
+		assert.Equal(t, sess.ThreadID, got.ThreadID)
+		assert.True(t, got.NameCollected)
+		assert.Equal(t, "Maria", got.CollectedName)
+		assert.Equal(t, 1, cli.threads)
+	})
+
+	t.Run("Updates last accessed time", func(t *testing.T) {
+		t.Parallel()
+
+		store := NewMemoryStore()
+		lastAccess := time.Now().Add(-time.Hour)
+		require.NoError(t, store.Save(context.Background(), &Session{
+			ThreadID:       "thread_old",
+			UserID:         "user",
+			LastAccessedAt: lastAccess,
+		}))
+
+		sm := NewSessionManager(&openaicli.Assistant{}, &fakeOpenAICli{}, store, nil)
+		_, err := sm.getOrCreateSession(context.Background(), "user")
+		require.NoError(t, err)
+
+		stored, err := store.Get(context.Background(), "user")
+		require.NoError(t, err)
+		assert.True(t, stored.LastAccessedAt.After(lastAccess))
+	})
+
+	t.Run("Replaces expired session", func(t *testing.T) {
+		t.Parallel()
+
+		store := NewMemoryStore()
+		require.NoError(t, store.Save(context.Background(), &Session{
+			ThreadID:       "thread_old",
+			UserID:         "user",
+			LastAccessedAt: time.Now().Add(-2 * sessionTimeout),
+			NameCollected:  true,
+			CollectedName:  "João",
+		}))
+
+		cli := &fakeOpenAICli{}
+		sm := NewSessionManager(&openaicli.Assistant{}, cli, store, nil)
+		sess, err := sm.getOrCreateSession(context.Background(), "user")
+		require.NoError(t, err)
+
+		assert.Equal(t, "thread_1", sess.ThreadID)
+		assert.False(t, sess.NameCollected)
+		assert.Equal(t, 1, cli.threads)
+	})
+}
+
+func TestMemoryStoreDeleteExpired(t *testing.T) {
+	t.Parallel()
+
+	store := NewMemoryStore()
+	ctx := context.Background()
+
+	require.NoError(t, store.Save(ctx, &Session{UserID: "old", ThreadID: "t1", LastAccessedAt: time.Now().Add(-48 * time.Hour)}))
+	require.NoError(t, store.Save(ctx, &Session{UserID: "new", ThreadID: "t2", LastAccessedAt: time.Now()}))
+
+	require.NoError(t, store.DeleteExpired(ctx, time.Now().Add(-sessionTimeout)))
+
+	_, err := store.Get(ctx, "old")
+	assert.ErrorIs(t, err, ErrSessionNotFound)
+
+	got, err := store.GetByThreadID(ctx, "t2")
+	require.NoError(t, err)
+	assert.Equal(t, "new", got.UserID)
+}
@@ -0,0 +1,22 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrSessionNotFound is returned by a Store when no session matches the lookup.
+var ErrSessionNotFound = errors.New("session not found")
+
+// Store persists chat sessions so that they survive restarts.
+type Store interface {
+	// Get returns the session for the given user ID.
+	Get(ctx context.Context, userID string) (*Session, error)
+	// GetByThreadID returns the session bound to the given OpenAI thread.
+	GetByThreadID(ctx context.Context, threadID string) (*Session, error)
+	// Save inserts or replaces the session.
+	Save(ctx context.Context, sess *Session) error
+	// DeleteExpired removes every session last accessed before the threshold.
+	DeleteExpired(ctx context.Context, threshold time.Time) error
+}
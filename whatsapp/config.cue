@@ -0,0 +1,3 @@
+MaxWorkers: 8
+MaxPendingMessages: 256
+MessageQuietWindowMillis: 3000
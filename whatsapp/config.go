@@ -0,0 +1,18 @@
+package whatsapp
+
+import "encore.dev/config"
+
+// Config is the whatsapp service configuration, loaded from config.cue.
+type Config struct {
+	// MaxWorkers is how many chats are processed concurrently.
+	MaxWorkers int
+	// MaxPendingMessages is how many messages may be queued across all
+	// chats before new ones are rejected.
+	MaxPendingMessages int
+	// MessageQuietWindowMillis is how long a chat must stay quiet before
+	// its burst of messages is sent to the assistant as a single turn.
+	// Zero answers every message on its own.
+	MessageQuietWindowMillis int
+}
+
+var cfg = config.Load[*Config]()
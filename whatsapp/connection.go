@@ -0,0 +1,332 @@
+package whatsapp
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"encore.app/internal/pkg/authz"
+
+	"encore.dev"
+	"encore.dev/beta/errs"
+	"encore.dev/rlog"
+	"go.mau.fi/whatsmeow"
+	walog "go.mau.fi/whatsmeow/util/log"
+	"rsc.io/qr"
+)
+
+// errAlreadyLoggedIn is returned when pairing while a device is linked.
+var errAlreadyLoggedIn = errors.New("already logged in to WhatsApp")
+
+// pairing is a QR code login in progress.
+type pairing struct {
+	code      string
+	expiresAt time.Time
+}
+
+// Status returns the state of the WhatsApp connection of a device.
+//
+//encore:api auth method=GET path=/whatsapp/devices/:id/status
+func (s *Service) Status(ctx context.Context, id string) (*ConnectionStatus, error) {
+	if err := authz.Require(authz.ScopeWhatsApp, authz.RoleReadOnly); err != nil {
+		return nil, err
+	}
+
+	d, err := s.device(id)
+	if err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.status(), nil
+}
+
+// Logout unlinks a device from its WhatsApp account and deletes it from
+// the store. Connecting again requires scanning a new QR code.
+//
+//encore:api auth method=POST path=/whatsapp/devices/:id/logout
+func (s *Service) Logout(ctx context.Context, id string) (*ConnectionStatus, error) {
+	if err := authz.Require(authz.ScopeWhatsApp, authz.RoleAdmin); err != nil {
+		return nil, err
+	}
+
+	d, err := s.device(id)
+	if err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	client := d.whatsappCli
+	if client == nil || d.deviceStore == nil || d.deviceStore.ID == nil {
+		d.mu.Unlock()
+		return nil, &errs.Error{
+			Code:    errs.FailedPrecondition,
+			Message: "no WhatsApp number is linked to the device",
+		}
+	}
+	jid := d.deviceStore.ID.String()
+	d.mu.Unlock()
+
+	// Logout only deletes the device once WhatsApp unlinked it, which
+	// needs a connection. It waits for WhatsApp, so d.mu is not held.
+	if err := client.Logout(); err != nil {
+		rlog.Error("could not log out of WhatsApp", "device", d.id, "jid", jid, "error", err)
+		return nil, &errs.Error{
+			Code:    errs.Unavailable,
+			Message: "could not unlink the device, reconnect and try again",
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// A pairing may have replaced the client in the meantime.
+	if d.whatsappCli == client {
+		d.whatsappCli = nil
+		d.deviceStore = nil
+		d.pairing = nil
+	}
+
+	rlog.Info("logged out of WhatsApp", "device", d.id, "jid", jid)
+	go d.setState(&ConnectionStateEvent{State: StateLoggedOut, Reason: "logged out through the API", JID: jid})
+	return d.status(), nil
+}
+
+// Reconnect connects a device again with its stored WhatsApp device,
+// without scanning a QR code.
+//
+//encore:api auth method=POST path=/whatsapp/devices/:id/reconnect
+func (s *Service) Reconnect(ctx context.Context, id string) (*ConnectionStatus, error) {
+	if err := authz.Require(authz.ScopeWhatsApp, authz.RoleAdmin); err != nil {
+		return nil, err
+	}
+
+	d, err := s.device(id)
+	if err != nil {
+		return nil, err
+	}
+
+	deviceStore, err := s.storedDevice(ctx, d.id)
+	if err != nil {
+		rlog.Error("could not get WhatsApp device", "device", d.id, "error", err)
+		return nil, &errs.Error{
+			Code:    errs.Internal,
+			Message: "could not get WhatsApp device",
+		}
+	}
+
+	if deviceStore == nil {
+		return nil, &errs.Error{
+			Code:    errs.FailedPrecondition,
+			Message: "no WhatsApp number is linked to the device, connect with a QR code first",
+		}
+	}
+
+	if err := s.connect(d, deviceStore); err != nil {
+		rlog.Error("could not reconnect to WhatsApp", "device", d.id, "error", err)
+		return nil, &errs.Error{
+			Code:    errs.Unavailable,
+			Message: "could not reconnect to WhatsApp",
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.status(), nil
+}
+
+// QR returns the QR code to scan to link a device to a number, starting a
+// pairing unless one is in progress. It is a PNG image by default, or a JSON data
+// URL with format=dataurl, for web pages.
+//
+//encore:api auth raw method=GET path=/whatsapp/devices/:id/qr
+func (s *Service) QR(w http.ResponseWriter, req *http.Request) {
+	if err := authz.Require(authz.ScopeWhatsApp, authz.RoleAdmin); err != nil {
+		errs.HTTPError(w, err)
+		return
+	}
+
+	format := req.URL.Query().Get("format")
+	if format != "" && format != "png" && format != "dataurl" {
+		errs.HTTPError(w, &errs.Error{
+			Code:    errs.InvalidArgument,
+			Message: fmt.Sprintf("invalid format %q: must be png or dataurl", format),
+		})
+		return
+	}
+
+	d, err := s.device(encore.CurrentRequest().PathParams.Get("id"))
+	if err != nil {
+		errs.HTTPError(w, err)
+		return
+	}
+
+	p, err := s.pair(d)
+	if err != nil {
+		if errors.Is(err, errAlreadyLoggedIn) {
+			errs.HTTPError(w, &errs.Error{
+				Code:    errs.FailedPrecondition,
+				Message: "a WhatsApp number is already linked to the device; log out first to pair it again",
+			})
+			return
+		}
+		rlog.Error("could not start WhatsApp pairing", "device", d.id, "error", err)
+		errs.HTTPError(w, &errs.Error{
+			Code:    errs.Unavailable,
+			Message: "could not start WhatsApp pairing",
+		})
+		return
+	}
+
+	code, err := qr.Encode(p.code, qr.L)
+	if err != nil {
+		rlog.Error("could not encode QR code", "error", err)
+		errs.HTTPError(w, &errs.Error{
+			Code:    errs.Internal,
+			Message: "could not encode QR code",
+		})
+		return
+	}
+	png := code.PNG()
+
+	// The code changes every few seconds.
+	w.Header().Set("Cache-Control", "no-store")
+
+	if format == "dataurl" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(&QRCode{
+			DataURL:   "data:image/png;base64," + base64.StdEncoding.EncodeToString(png),
+			ExpiresAt: p.expiresAt,
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(png)
+}
+
+// status returns the state of the connection of the device. The caller
+// must hold d.mu.
+func (d *device) status() *ConnectionStatus {
+	status := ConnectionStatus{
+		Device:  d.id,
+		Label:   d.label,
+		Pairing: d.pairing != nil,
+	}
+
+	d.stateLock.Lock()
+	status.State = d.state
+	d.stateLock.Unlock()
+
+	if cli := d.whatsappCli; cli != nil {
+		status.Connected = cli.IsConnected()
+		status.LoggedIn = cli.IsLoggedIn()
+		if cli.Store.ID != nil {
+			status.JID = cli.Store.ID.String()
+		}
+		status.PushName = cli.Store.PushName
+	}
+
+	if nanos := d.lastEventAt.Load(); nanos != 0 {
+		t := time.Unix(0, nanos)
+		status.LastEventAt = &t
+	}
+	return &status
+}
+
+// pair returns the current QR code to link the device to a number with,
+// starting a pairing unless one is in progress. The new client is swapped
+// in under d.mu, while connecting and waiting for the first code happen
+// outside of it.
+func (s *Service) pair(d *device) (*pairing, error) {
+	d.mu.Lock()
+	// A linked device keeps its session even while the supervisor is
+	// reconnecting it; only a logout lets it be paired again.
+	if d.deviceStore != nil && d.deviceStore.ID != nil {
+		d.mu.Unlock()
+		return nil, errAlreadyLoggedIn
+	}
+	old := d.whatsappCli
+	if old != nil && old.IsConnected() {
+		if p := d.pairing; p != nil && time.Now().Before(p.expiresAt) {
+			d.mu.Unlock()
+			return p, nil
+		}
+	}
+
+	// A client that is not linked is started over.
+	deviceStore := s.container.NewDevice()
+	client := s.newClient(d, deviceStore, walog.Stdout("Client", "DEBUG", true))
+
+	d.whatsappCli = client
+	d.deviceStore = deviceStore
+	d.pairing = nil
+	d.mu.Unlock()
+
+	if old != nil {
+		old.Disconnect()
+	}
+
+	qrChan, err := client.GetQRChannel(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("could not get QR channel: %w", err)
+	}
+
+	if err := d.connectClient(client); err != nil {
+		return nil, fmt.Errorf("could not connect: %w", err)
+	}
+
+	for evt := range qrChan {
+		if evt.Event == whatsmeow.QRChannelEventCode {
+			p := &pairing{code: evt.Code, expiresAt: time.Now().Add(evt.Timeout)}
+
+			d.mu.Lock()
+			current := d.whatsappCli == client
+			if current {
+				d.pairing = p
+			}
+			d.mu.Unlock()
+
+			// Another pairing or a logout replaced the client while it
+			// was waiting for the code.
+			if !current {
+				client.Disconnect()
+				return nil, errors.New("pairing was replaced by another one")
+			}
+
+			go d.followPairing(client, qrChan)
+			return p, nil
+		}
+		rlog.Info("WhatsApp login event", "device", d.id, "event", evt.Event)
+	}
+
+	if client.IsLoggedIn() {
+		return nil, errAlreadyLoggedIn
+	}
+	return nil, errors.New("pairing ended without a QR code")
+}
+
+// followPairing keeps the QR code of a pairing up to date until the device
+// is linked or the pairing fails.
+func (d *device) followPairing(client *whatsmeow.Client, qrChan <-chan whatsmeow.QRChannelItem) {
+	for evt := range qrChan {
+		d.mu.Lock()
+		// A newer pairing or a logout may have replaced the client.
+		if d.whatsappCli == client {
+			d.pairing = nil
+			if evt.Event == whatsmeow.QRChannelEventCode {
+				d.pairing = &pairing{code: evt.Code, expiresAt: time.Now().Add(evt.Timeout)}
+			}
+		}
+		d.mu.Unlock()
+
+		if evt.Event != whatsmeow.QRChannelEventCode {
+			rlog.Info("WhatsApp pairing ended", "device", d.id, "event", evt.Event, "error", evt.Error)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package whatsapp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/store"
+	"go.mau.fi/whatsmeow/types"
+)
+
+func TestPairLinkedDevice(t *testing.T) {
+	t.Parallel()
+
+	// The client is disconnected, as while the supervisor waits to
+	// reconnect it, but the device is still linked to a number.
+	jid := types.NewJID("5579999999999", types.DefaultUserServer)
+	deviceStore := &store.Device{ID: &jid}
+	client := &whatsmeow.Client{Store: deviceStore}
+	d := &device{id: "default", whatsappCli: client, deviceStore: deviceStore}
+
+	s := &Service{}
+	p, err := s.pair(d)
+	assert.ErrorIs(t, err, errAlreadyLoggedIn)
+	assert.Nil(t, p)
+	assert.Same(t, client, d.whatsappCli)
+	assert.Same(t, deviceStore, d.deviceStore)
+}
@@ -0,0 +1,415 @@
+package whatsapp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"encore.app/imolink"
+	"encore.app/internal/pkg/apierror"
+	"encore.app/internal/pkg/authz"
+	"encore.app/internal/pkg/openaicli"
+	"encore.app/session"
+
+	"encore.dev/beta/errs"
+	"encore.dev/rlog"
+	"encore.dev/storage/sqldb"
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/store"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+var (
+	deviceIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,31}$`)
+
+	errDeviceNotFound = errors.New("device not found")
+)
+
+// device is a WhatsApp number managed by the service, with its own client,
+// supervisor and sessions.
+type device struct {
+	id string
+
+	// mu guards the fields below. The supervisor fields are set once.
+	mu          sync.Mutex
+	label       string
+	sessionMgr  *session.SessionManager
+	whatsappCli *whatsmeow.Client
+	deviceStore *store.Device
+	pairing     *pairing
+	// lastEventAt is when the client last received an event, in Unix
+	// nanoseconds.
+	lastEventAt atomic.Int64
+
+	// The supervisor owns the reconnections. state is the last
+	// connection state it published.
+	connEvents     chan connectionEvent
+	supervisorStop chan struct{}
+	supervisorDone chan struct{}
+	stateLock      sync.Mutex
+	state          string
+}
+
+// ListDevices lists the WhatsApp devices.
+//
+//encore:api auth method=GET path=/whatsapp/devices
+func (s *Service) ListDevices(ctx context.Context) (*Devices, error) {
+	if err := authz.Require(authz.ScopeWhatsApp, authz.RoleReadOnly); err != nil {
+		return nil, err
+	}
+
+	devices, err := loadDevices(ctx)
+	if err != nil {
+		return nil, apierror.E("could not list devices", err, errs.Internal)
+	}
+	return &Devices{Devices: devices}, nil
+}
+
+// CreateDevice adds a WhatsApp device, to be linked to a number with its
+// QR code.
+//
+//encore:api auth method=POST path=/whatsapp/devices
+func (s *Service) CreateDevice(ctx context.Context, in *CreateDeviceInput) (*Device, error) {
+	if err := authz.Require(authz.ScopeWhatsApp, authz.RoleAdmin); err != nil {
+		return nil, err
+	}
+
+	if !deviceIDPattern.MatchString(in.ID) {
+		return nil, &errs.Error{
+			Code:    errs.InvalidArgument,
+			Message: "invalid id: use up to 32 lowercase letters, digits and dashes",
+		}
+	}
+
+	label := strings.TrimSpace(in.Label)
+	if label == "" {
+		return nil, &errs.Error{
+			Code:    errs.InvalidArgument,
+			Message: "label is required",
+		}
+	}
+
+	if err := s.checkAssistant(ctx, in.AssistantID); err != nil {
+		return nil, err
+	}
+
+	cfg := Device{
+		ID:          in.ID,
+		Label:       label,
+		AssistantID: in.AssistantID,
+		Agent:       strings.TrimSpace(in.Agent),
+	}
+
+	s.devicesLock.Lock()
+	defer s.devicesLock.Unlock()
+
+	if err := db.QueryRow(ctx, `
+		INSERT INTO devices (id, label, assistant_id, agent)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (id) DO NOTHING
+		RETURNING created_at, updated_at
+	`, cfg.ID, cfg.Label, cfg.AssistantID, cfg.Agent).Scan(&cfg.CreatedAt, &cfg.UpdatedAt); err != nil {
+		if errors.Is(err, sqldb.ErrNoRows) {
+			return nil, &errs.Error{
+				Code:    errs.AlreadyExists,
+				Message: fmt.Sprintf("device %q already exists", cfg.ID),
+			}
+		}
+		return nil, apierror.E("could not create device", err, errs.Internal)
+	}
+
+	s.devices[cfg.ID] = s.startDevice(&cfg)
+
+	rlog.Info("WhatsApp device created", "device", cfg.ID)
+	return &cfg, nil
+}
+
+// UpdateDevice changes the label, assistant or agent of a device. Messages
+// being answered finish with the previous assistant.
+//
+//encore:api auth method=PATCH path=/whatsapp/devices/:id
+func (s *Service) UpdateDevice(ctx context.Context, id string, in *UpdateDeviceInput) (*Device, error) {
+	if err := authz.Require(authz.ScopeWhatsApp, authz.RoleAdmin); err != nil {
+		return nil, err
+	}
+
+	if in.Label == nil && in.AssistantID == nil && in.Agent == nil {
+		return nil, &errs.Error{
+			Code:    errs.InvalidArgument,
+			Message: "no fields to update",
+		}
+	}
+
+	if in.Label != nil {
+		label := strings.TrimSpace(*in.Label)
+		if label == "" {
+			return nil, &errs.Error{
+				Code:    errs.InvalidArgument,
+				Message: "label cannot be empty",
+			}
+		}
+		in.Label = &label
+	}
+
+	if in.AssistantID != nil {
+		if err := s.checkAssistant(ctx, *in.AssistantID); err != nil {
+			return nil, err
+		}
+	}
+
+	if in.Agent != nil {
+		agent := strings.TrimSpace(*in.Agent)
+		in.Agent = &agent
+	}
+
+	d, err := s.device(id)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := scanDevice(db.QueryRow(ctx, `
+		UPDATE devices SET
+			label = COALESCE($2, label),
+			assistant_id = COALESCE($3, assistant_id),
+			agent = COALESCE($4, agent),
+			updated_at = NOW()
+		WHERE id = $1
+		RETURNING `+deviceColumns,
+		id, in.Label, in.AssistantID, in.Agent,
+	))
+	if err != nil {
+		if errors.Is(err, errDeviceNotFound) {
+			return nil, &errs.Error{
+				Code:    errs.NotFound,
+				Message: "device not found",
+			}
+		}
+		return nil, apierror.E("could not update device", err, errs.Internal)
+	}
+
+	s.configureDevice(d, cfg)
+	return cfg, nil
+}
+
+// DeleteDevice deletes a device and the sessions of its contacts. A linked
+// device must be logged out first.
+//
+//encore:api auth method=DELETE path=/whatsapp/devices/:id
+func (s *Service) DeleteDevice(ctx context.Context, id string) error {
+	if err := authz.Require(authz.ScopeWhatsApp, authz.RoleAdmin); err != nil {
+		return err
+	}
+
+	s.devicesLock.Lock()
+	defer s.devicesLock.Unlock()
+
+	d, ok := s.devices[id]
+	if !ok {
+		return &errs.Error{
+			Code:    errs.NotFound,
+			Message: "device not found",
+		}
+	}
+
+	// The row is only deleted while unlinked, so no number is left
+	// connected without a device.
+	result, err := db.Exec(ctx, `DELETE FROM devices WHERE id = $1 AND jid IS NULL`, id)
+	if err != nil {
+		return apierror.E("could not delete device", err, errs.Internal)
+	}
+
+	if result.RowsAffected() == 0 {
+		return &errs.Error{
+			Code:    errs.FailedPrecondition,
+			Message: "a number is linked to the device, log out first",
+		}
+	}
+
+	delete(s.devices, id)
+	d.stop()
+
+	rlog.Info("WhatsApp device deleted", "device", id)
+	return nil
+}
+
+// device returns the running device with the ID.
+func (s *Service) device(id string) (*device, error) {
+	s.devicesLock.RLock()
+	defer s.devicesLock.RUnlock()
+
+	d, ok := s.devices[id]
+	if !ok {
+		return nil, &errs.Error{
+			Code:    errs.NotFound,
+			Message: "device not found",
+		}
+	}
+	return d, nil
+}
+
+// startDevice starts the supervisor of a device, without connecting it.
+func (s *Service) startDevice(cfg *Device) *device {
+	d := &device{
+		id:             cfg.ID,
+		connEvents:     make(chan connectionEvent, 64),
+		supervisorStop: make(chan struct{}),
+		supervisorDone: make(chan struct{}),
+	}
+	s.configureDevice(d, cfg)
+
+	go d.supervise()
+	return d
+}
+
+// configureDevice applies the configuration of a device, replacing its
+// session manager.
+func (s *Service) configureDevice(d *device, cfg *Device) {
+	assistant := imolink.Assistant
+	if cfg.AssistantID != "" {
+		assistant = &openaicli.Assistant{ID: cfg.AssistantID}
+	}
+
+	sessionMgr := session.NewSessionManager(
+		assistant,
+		s.openAICli,
+		session.NewPostgresStore(db, cfg.ID),
+		propertySearcher{},
+		session.WithLeadAgent(cfg.Agent),
+	)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.sessionMgr != nil {
+		d.sessionMgr.Close()
+	}
+	d.sessionMgr = sessionMgr
+	d.label = cfg.Label
+}
+
+// conversation returns the client and session manager replies go
+// through. The client is nil while the device is not linked.
+func (d *device) conversation() (*whatsmeow.Client, *session.SessionManager) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.whatsappCli, d.sessionMgr
+}
+
+// stop stops the supervisor of a removed device and disconnects it.
+func (d *device) stop() {
+	close(d.supervisorStop)
+	<-d.supervisorDone
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.whatsappCli != nil {
+		d.whatsappCli.Disconnect()
+	}
+	d.sessionMgr.Close()
+}
+
+// checkAssistant checks that an assistant that will answer a number exists.
+// An empty ID stands for the imolink assistant.
+func (s *Service) checkAssistant(ctx context.Context, assistantID string) error {
+	if assistantID == "" {
+		return nil
+	}
+
+	if _, err := s.openAICli.GetAssistant(ctx, assistantID); err != nil {
+		if errors.Is(err, openaicli.ErrNotFound) {
+			return &errs.Error{
+				Code:    errs.InvalidArgument,
+				Message: fmt.Sprintf("assistant %q does not exist", assistantID),
+			}
+		}
+		return apierror.E("could not get assistant", err, errs.Unavailable)
+	}
+	return nil
+}
+
+// storedDevice returns the whatsmeow device linked to a device, or nil if
+// the device is not linked.
+func (s *Service) storedDevice(ctx context.Context, id string) (*store.Device, error) {
+	var jid *string
+	if err := db.QueryRow(ctx, `SELECT jid FROM devices WHERE id = $1`, id).Scan(&jid); err != nil {
+		if errors.Is(err, sqldb.ErrNoRows) {
+			return nil, errDeviceNotFound
+		}
+		return nil, fmt.Errorf("could not get device: %w", err)
+	}
+
+	if jid == nil {
+		return nil, nil
+	}
+
+	parsed, err := types.ParseJID(*jid)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse device JID: %w", err)
+	}
+
+	deviceStore, err := s.container.GetDevice(parsed)
+	if err != nil {
+		return nil, fmt.Errorf("could not get WhatsApp device: %w", err)
+	}
+	return deviceStore, nil
+}
+
+// linkDevice records the number a device was paired with.
+func (s *Service) linkDevice(d *device, evt *events.PairSuccess) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := db.Exec(ctx, `
+		UPDATE devices SET jid = $2, updated_at = NOW() WHERE id = $1
+	`, d.id, evt.ID.String()); err != nil {
+		rlog.Error("could not link WhatsApp device", "device", d.id, "jid", evt.ID, "error", err)
+		return
+	}
+	rlog.Info("WhatsApp device linked", "device", d.id, "jid", evt.ID)
+}
+
+const deviceColumns = `id, label, COALESCE(jid, ''), assistant_id, agent, created_at, updated_at`
+
+func loadDevices(ctx context.Context) ([]*Device, error) {
+	rows, err := db.Query(ctx, `SELECT `+deviceColumns+` FROM devices ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("could not query devices: %w", err)
+	}
+	defer rows.Close()
+
+	devices := make([]*Device, 0)
+	for rows.Next() {
+		d, err := scanDevice(rows)
+		if err != nil {
+			return nil, err
+		}
+		devices = append(devices, d)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not query devices: %w", err)
+	}
+	return devices, nil
+}
+
+type scannable interface {
+	Scan(dest ...any) error
+}
+
+func scanDevice(row scannable) (*Device, error) {
+	var d Device
+	if err := row.Scan(&d.ID, &d.Label, &d.JID, &d.AssistantID, &d.Agent, &d.CreatedAt, &d.UpdatedAt); err != nil {
+		if errors.Is(err, sqldb.ErrNoRows) {
+			return nil, errDeviceNotFound
+		}
+		return nil, fmt.Errorf("could not scan device: %w", err)
+	}
+	return &d, nil
+}
@@ -0,0 +1,19 @@
+package whatsapp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeviceIDPattern(t *testing.T) {
+	t.Parallel()
+
+	for _, id := range []string{"default", "sales", "rentals-2", "0"} {
+		assert.True(t, deviceIDPattern.MatchString(id), id)
+	}
+
+	for _, id := range []string{"", "Sales", "-sales", "sales/rentals", "vendas sp", "a23456789012345678901234567890123"} {
+		assert.False(t, deviceIDPattern.MatchString(id), id)
+	}
+}
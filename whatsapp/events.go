@@ -0,0 +1,47 @@
+package whatsapp
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"encore.dev/pubsub"
+)
+
+// Connection states published on ConnectionStates.
+const (
+	StateConnected    = "connected"
+	StateDisconnected = "disconnected"
+	StateReconnecting = "reconnecting"
+	// StateLoggedOut means the device was unlinked and must be paired
+	// again with a QR code.
+	StateLoggedOut = "logged_out"
+	// StateReplaced means another client connected with the same device.
+	// The service does not reconnect, so the two don't take turns.
+	StateReplaced = "replaced"
+)
+
+// ConnectionStateEvent is published whenever the WhatsApp connection of a
+// device changes state, for alerting.
+type ConnectionStateEvent struct {
+	Device string `json:"device"`
+	State  string `json:"state"`
+	// Reason explains the change, such as "keepalive timed out".
+	Reason string `json:"reason,omitempty"`
+	JID    string `json:"jid,omitempty"`
+	// Attempt counts the reconnection attempts in the reconnecting state.
+	Attempt   int       `json:"attempt,omitempty"`
+	ChangedAt time.Time `json:"changedAt"`
+}
+
+// ConnectionStates carries the state changes of the WhatsApp connections.
+var ConnectionStates = pubsub.NewTopic[*ConnectionStateEvent]("whatsapp-connection-states", pubsub.TopicConfig{
+	DeliveryGuarantee: pubsub.AtLeastOnce,
+})
+
+func publishState(ctx context.Context, evt *ConnectionStateEvent) error {
+	if _, err := ConnectionStates.Publish(ctx, evt); err != nil {
+		return fmt.Errorf("could not publish %s connection state of device %s: %w", evt.State, evt.Device, err)
+	}
+	return nil
+}
@@ -0,0 +1,26 @@
+package whatsapp
+
+import (
+	"context"
+
+	"encore.app/leads"
+	"encore.dev/beta/errs"
+	"encore.dev/rlog"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// recordShownProperties links the properties recommended in a reply to the
+// sender's lead, so agents know what each lead has already seen. Senders
+// who have not left their name yet have no lead and are skipped.
+func (s *Service) recordShownProperties(ctx context.Context, sender types.JID, refs []string) {
+	if len(refs) == 0 {
+		return
+	}
+
+	if err := leads.RecordShownProperties(ctx, &leads.RecordShownPropertiesInput{
+		Phone:      sender.User,
+		References: refs,
+	}); err != nil && errs.Code(err) != errs.NotFound {
+		rlog.Error("could not record shown properties", "sender", sender, "error", err)
+	}
+}
@@ -0,0 +1,189 @@
+package whatsapp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"encore.app/properties"
+	"encore.dev/beta/errs"
+	"encore.dev/rlog"
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+)
+
+// maxPropertiesWithImages caps how many recommended properties get
+// their pictures sent after a reply, to avoid flooding the chat.
+const maxPropertiesWithImages = 3
+
+var (
+	// propertyLinkPattern matches the property links the assistant is instructed to send,
+	// with their query if any.
+	propertyLinkPattern = regexp.MustCompile(`/properties/([A-Za-z0-9_-]+)(\?\S*)?`)
+	// propertyRefPattern matches bare property references such as REF123.
+	propertyRefPattern = regexp.MustCompile(`\bREF\d+\b`)
+)
+
+// referencedProperties returns the property references mentioned in the
+// assistant response, without duplicates. The linked properties come first,
+// since they are the ones recommended, then the bare references, each in
+// order of appearance.
+func referencedProperties(response string) []string {
+	var (
+		refs []string
+		seen = make(map[string]bool)
+	)
+
+	add := func(ref string) {
+		if !seen[ref] {
+			seen[ref] = true
+			refs = append(refs, ref)
+		}
+	}
+
+	for _, m := range propertyLinkPattern.FindAllStringSubmatch(response, -1) {
+		add(m[1])
+	}
+	for _, ref := range propertyRefPattern.FindAllString(response, -1) {
+		add(ref)
+	}
+	return refs
+}
+
+// attributeLinks adds the chat token to the property links of a response,
+// so the views of the pages are attributed to the chat. Links with a query,
+// such as share links, are left as they are, and so is every link without
+// a token.
+func attributeLinks(response, token string) string {
+	if token == "" {
+		return response
+	}
+
+	return propertyLinkPattern.ReplaceAllStringFunc(response, func(link string) string {
+		if strings.Contains(link, "?") {
+			return link
+		}
+		return link + "?chat=" + url.QueryEscape(token)
+	})
+}
+
+// chatToken returns the token of the chat with the sender for the property
+// links, or "" if it can't be signed.
+func (s *Service) chatToken(ctx context.Context, sender types.JID) string {
+	token, err := properties.SignChat(ctx, &properties.SignChatInput{Chat: sender.User})
+	if err != nil {
+		rlog.Warn("could not sign chat token", "sender", sender, "error", err)
+		return ""
+	}
+	return token.Token
+}
+
+// recordRecommendations records the properties recommended in a reply for
+// the click-through rate of the property pages.
+func (s *Service) recordRecommendations(ctx context.Context, sender types.JID, refs []string) {
+	if len(refs) == 0 {
+		return
+	}
+
+	if err := properties.RecordRecommendations(ctx, &properties.RecordRecommendationsInput{
+		Chat:       sender.User,
+		References: refs,
+	}); err != nil {
+		rlog.Error("could not record recommendations", "sender", sender, "error", err)
+	}
+}
+
+// sendPropertyImages sends the first photo and blueprint of every
+// referenced property as WhatsApp image messages.
+func (s *Service) sendPropertyImages(ctx context.Context, client *whatsmeow.Client, to types.JID, refs []string) {
+	if len(refs) > maxPropertiesWithImages {
+		refs = refs[:maxPropertiesWithImages]
+	}
+
+	for _, ref := range refs {
+		prop, err := properties.GetByReference(ctx, ref)
+		if err != nil {
+			if errs.Code(err) != errs.NotFound {
+				rlog.Error("could not get property", "reference", ref, "error", err)
+			}
+			continue
+		}
+
+		caption := fmt.Sprintf("%s - %s", prop.Name, formatBRL(prop.Price))
+
+		if photos := prop.Photos(); len(photos) > 0 {
+			if err := s.sendMedia(ctx, client, to, photos[0], caption); err != nil {
+				rlog.Error("could not send property photo", "reference", ref, "error", err)
+			}
+		}
+
+		if blueprints := prop.Blueprints(); len(blueprints) > 0 {
+			if err := s.sendMedia(ctx, client, to, blueprints[0], "Planta: "+caption); err != nil {
+				rlog.Error("could not send property blueprint", "reference", ref, "error", err)
+			}
+		}
+	}
+}
+
+func (s *Service) sendMedia(ctx context.Context, client *whatsmeow.Client, to types.JID, media *properties.Media, caption string) error {
+	content, err := properties.GetMediaContent(ctx, media.ID)
+	if err != nil {
+		return fmt.Errorf("could not get media content: %w", err)
+	}
+	return s.sendImage(ctx, client, to, content.Data, caption)
+}
+
+func (s *Service) sendImage(ctx context.Context, client *whatsmeow.Client, to types.JID, data []byte, caption string) error {
+	mimeType := http.DetectContentType(data)
+	if !strings.HasPrefix(mimeType, "image/") {
+		return fmt.Errorf("unsupported image type %q", mimeType)
+	}
+
+	uploaded, err := client.Upload(ctx, data, whatsmeow.MediaImage)
+	if err != nil {
+		return fmt.Errorf("could not upload image: %w", err)
+	}
+
+	if _, err := client.SendMessage(ctx, to, &waE2E.Message{
+		ImageMessage: &waE2E.ImageMessage{
+			Caption:       proto.String(caption),
+			Mimetype:      proto.String(mimeType),
+			URL:           proto.String(uploaded.URL),
+			DirectPath:    proto.String(uploaded.DirectPath),
+			MediaKey:      uploaded.MediaKey,
+			FileEncSHA256: uploaded.FileEncSHA256,
+			FileSHA256:    uploaded.FileSHA256,
+			FileLength:    proto.Uint64(uploaded.FileLength),
+		},
+	}); err != nil {
+		return fmt.Errorf("could not send image: %w", err)
+	}
+	return nil
+}
+
+// formatBRL formats a price the way it is written in Brazil, e.g. R$ 3.850.000,00.
+func formatBRL(price float64) string {
+	s := fmt.Sprintf("%.2f", price)
+	intPart, decPart := s[:len(s)-3], s[len(s)-2:]
+
+	negative := strings.HasPrefix(intPart, "-")
+	intPart = strings.TrimPrefix(intPart, "-")
+
+	var b strings.Builder
+	for i, r := range intPart {
+		if i > 0 && (len(intPart)-i)%3 == 0 {
+			b.WriteByte('.')
+		}
+		b.WriteRune(r)
+	}
+
+	if negative {
+		return "R$ -" + b.String() + "," + decPart
+	}
+	return "R$ " + b.String() + "," + decPart
+}
@@ -0,0 +1,51 @@
+package whatsapp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReferencedProperties(t *testing.T) {
+	t.Parallel()
+
+	response := `Encontrei estas opções:
+
+1. Residência Praia de Atalaia (REF978): Valor: R$ 3.200.000.
+
+2. Casa nos Jardins: Valor: R$ 890.000.
+
+Para saber mais, confira os links:
+http://localhost:4000/properties/REF123
+http://localhost:4000/properties/REF978`
+
+	assert.Equal(t, []string{"REF123", "REF978"}, referencedProperties(response))
+
+	// Links come before bare references, even when mentioned later.
+	assert.Equal(t, []string{"REF123", "REF555"}, referencedProperties(
+		"Também temos o REF555, e este aqui: http://localhost:4000/properties/REF123",
+	))
+	assert.Empty(t, referencedProperties("Olá! Como posso chamá-lo(a)?"))
+}
+
+func TestAttributeLinks(t *testing.T) {
+	t.Parallel()
+
+	response := `Confira os links:
+http://localhost:4000/properties/REF123
+http://localhost:4000/properties/REF978?lead=01J&exp=1&sig=abc`
+
+	assert.Equal(t, `Confira os links:
+http://localhost:4000/properties/REF123?chat=Yq3u.Xk9_
+http://localhost:4000/properties/REF978?lead=01J&exp=1&sig=abc`, attributeLinks(response, "Yq3u.Xk9_"))
+	assert.Equal(t, response, attributeLinks(response, ""))
+}
+
+func TestFormatBRL(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "R$ 3.850.000,00", formatBRL(3850000))
+	assert.Equal(t, "R$ 890.000,50", formatBRL(890000.5))
+	assert.Equal(t, "R$ 950,00", formatBRL(950))
+	assert.Equal(t, "R$ 0,99", formatBRL(0.99))
+}
@@ -0,0 +1,39 @@
+package whatsapp
+
+import (
+	"time"
+
+	"encore.dev/metrics"
+	"encore.dev/rlog"
+)
+
+var (
+	messagesPending          = metrics.NewGauge[int64]("whatsapp_messages_pending", metrics.GaugeConfig{})
+	messagesRejected         = metrics.NewCounter[uint64]("whatsapp_messages_rejected", metrics.CounterConfig{})
+	messagesProcessed        = metrics.NewCounter[uint64]("whatsapp_messages_processed", metrics.CounterConfig{})
+	messageProcessingSeconds = metrics.NewCounter[float64]("whatsapp_message_processing_seconds_total", metrics.CounterConfig{})
+)
+
+// dispatchMetrics reports the message dispatcher activity as metrics. The
+// processing time of the messages is summed, so the mean latency is the rate
+// of whatsapp_message_processing_seconds_total divided by the rate of
+// whatsapp_messages_processed.
+type dispatchMetrics struct{}
+
+func (dispatchMetrics) Enqueued(pending int) {
+	messagesPending.Set(int64(pending))
+}
+
+func (dispatchMetrics) Rejected() {
+	messagesRejected.Increment()
+}
+
+func (dispatchMetrics) Completed(elapsed time.Duration, pending int) {
+	messagesProcessed.Increment()
+	messageProcessingSeconds.Add(elapsed.Seconds())
+	messagesPending.Set(int64(pending))
+}
+
+func (dispatchMetrics) Panicked(chat string, recovered any) {
+	rlog.Error("message handler panicked", "chat", chat, "panic", recovered)
+}
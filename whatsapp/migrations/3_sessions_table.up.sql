@@ -0,0 +1,11 @@
+CREATE TABLE sessions (
+    user_id VARCHAR(255) PRIMARY KEY,
+    thread_id VARCHAR(255) NOT NULL,
+    last_accessed_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    name_collected BOOLEAN NOT NULL DEFAULT FALSE,
+    collected_name VARCHAR(255) NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_sessions_thread_id ON sessions (thread_id);
+CREATE INDEX idx_sessions_last_accessed_at ON sessions (last_accessed_at);
@@ -0,0 +1,23 @@
+CREATE TABLE devices (
+    id TEXT PRIMARY KEY,
+    label TEXT NOT NULL,
+    -- jid is the whatsmeow device linked to the number, once paired.
+    jid TEXT UNIQUE REFERENCES whatsmeow_device(jid) ON DELETE SET NULL,
+    -- assistant_id overrides the imolink assistant when set.
+    assistant_id TEXT NOT NULL DEFAULT '',
+    -- agent is assigned the leads of the number.
+    agent TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- The number linked before devices existed becomes the default device.
+INSERT INTO devices (id, label, jid)
+VALUES ('default', 'Default', (SELECT jid FROM whatsmeow_device LIMIT 1));
+
+-- The same contact has a separate conversation with each number.
+ALTER TABLE sessions ADD COLUMN device_id TEXT NOT NULL DEFAULT 'default'
+    REFERENCES devices(id) ON DELETE CASCADE;
+ALTER TABLE sessions ALTER COLUMN device_id DROP DEFAULT;
+ALTER TABLE sessions DROP CONSTRAINT sessions_pkey;
+ALTER TABLE sessions ADD PRIMARY KEY (device_id, user_id);
@@ -0,0 +1,68 @@
+package whatsapp
+
+import "time"
+
+// Device is a WhatsApp number of the agency. Each number answers with its
+// own assistant and assigns its leads to its own agent.
+type Device struct {
+	// ID identifies the device in the endpoints, such as "sales".
+	ID    string `json:"id"`
+	Label string `json:"label"`
+	// JID is the linked WhatsApp device, once a QR code was scanned.
+	JID string `json:"jid,omitempty"`
+	// AssistantID is the OpenAI assistant answering the number. The
+	// imolink assistant answers when it is empty.
+	AssistantID string `json:"assistantId,omitempty"`
+	// Agent is assigned the leads of the number that have no agent yet.
+	Agent     string    `json:"agent,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Devices is a list of devices.
+type Devices struct {
+	Devices []*Device `json:"devices"`
+}
+
+// CreateDeviceInput is a new device. The ID is made of lowercase letters,
+// digits and dashes.
+type CreateDeviceInput struct {
+	ID          string `json:"id"`
+	Label       string `json:"label"`
+	AssistantID string `json:"assistantId,omitempty"`
+	Agent       string `json:"agent,omitempty"`
+}
+
+// UpdateDeviceInput holds a partial update of a device. Only non-nil
+// fields are written, and empty AssistantID and Agent unset them.
+type UpdateDeviceInput struct {
+	Label       *string `json:"label,omitempty"`
+	AssistantID *string `json:"assistantId,omitempty"`
+	Agent       *string `json:"agent,omitempty"`
+}
+
+// ConnectionStatus is the state of the WhatsApp connection of a device.
+type ConnectionStatus struct {
+	Device string `json:"device"`
+	Label  string `json:"label"`
+	// Connected reports whether the websocket to WhatsApp is open.
+	Connected bool `json:"connected"`
+	// LoggedIn reports whether the device is linked and authenticated.
+	LoggedIn bool `json:"loggedIn"`
+	// State is the last state published on ConnectionStates, such as
+	// "reconnecting".
+	State string `json:"state,omitempty"`
+	// Pairing is set while a QR code is waiting to be scanned.
+	Pairing  bool   `json:"pairing"`
+	JID      string `json:"jid,omitempty"`
+	PushName string `json:"pushName,omitempty"`
+	// LastEventAt is when the client last received an event from WhatsApp.
+	LastEventAt *time.Time `json:"lastEventAt,omitempty"`
+}
+
+// QRCode is the QR code to scan to link a device, as a PNG data URL.
+type QRCode struct {
+	DataURL string `json:"dataUrl"`
+	// ExpiresAt is when WhatsApp replaces the code with a new one.
+	ExpiresAt time.Time `json:"expiresAt"`
+}
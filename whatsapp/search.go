@@ -0,0 +1,51 @@
+package whatsapp
+
+import (
+	"context"
+	"fmt"
+
+	"encore.app/properties"
+	"encore.app/session"
+)
+
+// propertySearcher answers the assistant's search_properties
+// tool calls using the properties service.
+type propertySearcher struct{}
+
+func (propertySearcher) SearchProperties(ctx context.Context, criteria session.SearchCriteria, limit int) ([]session.PropertySummary, error) {
+	props, err := properties.List(ctx, properties.ListInput{
+		PropertyType:   criteria.PropertyType,
+		District:       criteria.District,
+		City:           criteria.City,
+		MinPrice:       criteria.MinPrice,
+		MaxPrice:       criteria.MaxPrice,
+		MinBedrooms:    criteria.MinBedrooms,
+		MinBathrooms:   criteria.MinBathrooms,
+		MinGarageSpots: criteria.MinGarageSpots,
+		Features:       criteria.Features,
+		Text:           criteria.Text,
+		SortBy:         "price",
+		Order:          "asc",
+		Limit:          limit,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not list properties: %w", err)
+	}
+
+	summaries := make([]session.PropertySummary, 0, len(props.Properties))
+	for _, p := range props.Properties {
+		summaries = append(summaries, session.PropertySummary{
+			Reference:    p.Reference,
+			Name:         p.Name,
+			PropertyType: p.PropertyType,
+			Price:        p.Price,
+			District:     p.District,
+			City:         p.City,
+			Area:         p.Area,
+			NumBedrooms:  p.NumBedrooms,
+			NumBathrooms: p.NumBathrooms,
+			Features:     p.Features,
+		})
+	}
+	return summaries, nil
+}
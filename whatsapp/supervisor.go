@@ -0,0 +1,275 @@
+package whatsapp
+
+import (
+	"context"
+	"time"
+
+	"encore.dev/rlog"
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/store"
+	"go.mau.fi/whatsmeow/types/events"
+	walog "go.mau.fi/whatsmeow/util/log"
+)
+
+const (
+	minReconnectDelay = time.Second
+	maxReconnectDelay = 5 * time.Minute
+
+	// maxKeepAliveTimeouts is how many keepalive pings in a row may time
+	// out before the connection is considered dead.
+	maxKeepAliveTimeouts = 3
+
+	publishTimeout = 10 * time.Second
+)
+
+// connectionEvent is a connection event of a client, for the supervisor.
+type connectionEvent struct {
+	client *whatsmeow.Client
+	evt    any
+}
+
+// newClient returns a client of the whatsmeow device, supervised by the
+// supervisor of d.
+func (s *Service) newClient(d *device, deviceStore *store.Device, log walog.Logger) *whatsmeow.Client {
+	client := whatsmeow.NewClient(deviceStore, log)
+	// The supervisor reconnects with backoff instead.
+	client.EnableAutoReconnect = false
+
+	client.AddEventHandler(func(evt any) {
+		s.whatsappEventHandler(d, evt)
+	})
+	client.AddEventHandler(func(evt any) {
+		d.notifySupervisor(client, evt)
+	})
+	return client
+}
+
+// notifySupervisor hands the connection events of a client to the
+// supervisor. It runs in whatsmeow's event loop, so it never blocks.
+func (d *device) notifySupervisor(client *whatsmeow.Client, evt any) {
+	switch evt.(type) {
+	case *events.Connected, *events.Disconnected, *events.KeepAliveTimeout,
+		*events.KeepAliveRestored, *events.StreamReplaced, *events.LoggedOut:
+	default:
+		return
+	}
+
+	select {
+	case d.connEvents <- connectionEvent{client: client, evt: evt}:
+	default:
+		rlog.Error("WhatsApp supervisor is falling behind, dropping event", "device", d.id, "event", evt)
+	}
+}
+
+// supervise keeps the WhatsApp connection of the device up until the
+// service stops or the device is deleted. It reconnects with backoff when
+// the connection drops or stops answering, gives up when the device is
+// logged out or replaced, and publishes every change of state on
+// ConnectionStates.
+func (d *device) supervise() {
+	defer close(d.supervisorDone)
+
+	sv := newSupervisor(d.id, d)
+	for {
+		select {
+		case <-d.supervisorStop:
+			return
+		case e := <-d.connEvents:
+			sv.handle(e)
+		case <-sv.retry:
+			sv.reconnect()
+		}
+	}
+}
+
+// supervised is the device as seen by its supervisor, so the transitions
+// of the supervisor can be tested without a WhatsApp connection.
+type supervised interface {
+	isCurrent(client *whatsmeow.Client) bool
+	reconnectClient(client *whatsmeow.Client) error
+	disconnectClient(client *whatsmeow.Client)
+	invalidateDevice(client *whatsmeow.Client)
+	setState(evt *ConnectionStateEvent)
+}
+
+// supervisor holds the reconnection state of a device. Its methods run in
+// the supervise loop only.
+type supervisor struct {
+	device string
+	dev    supervised
+	after  func(time.Duration) <-chan time.Time
+
+	// retry fires when the pending reconnection of target is due, and is
+	// nil when none is pending.
+	retry   <-chan time.Time
+	attempt int
+	target  *whatsmeow.Client
+}
+
+func newSupervisor(device string, dev supervised) *supervisor {
+	return &supervisor{device: device, dev: dev, after: time.After}
+}
+
+// handle applies a connection event. Events of clients that were replaced
+// since are ignored.
+func (sv *supervisor) handle(e connectionEvent) {
+	if !sv.dev.isCurrent(e.client) {
+		return
+	}
+
+	switch v := e.evt.(type) {
+	case *events.Connected:
+		sv.reset()
+		sv.dev.setState(&ConnectionStateEvent{State: StateConnected, JID: clientJID(e.client)})
+
+	case *events.Disconnected:
+		sv.dev.setState(&ConnectionStateEvent{State: StateDisconnected, Reason: "connection closed", JID: clientJID(e.client)})
+		sv.schedule(e.client, "connection closed")
+
+	case *events.KeepAliveTimeout:
+		if v.ErrorCount < maxKeepAliveTimeouts {
+			rlog.Warn("WhatsApp keepalive timed out", "device", sv.device, "errors", v.ErrorCount, "last_success", v.LastSuccess)
+			return
+		}
+		// Disconnect emits no event, so the reconnection is scheduled here.
+		sv.dev.disconnectClient(e.client)
+		sv.dev.setState(&ConnectionStateEvent{State: StateDisconnected, Reason: "keepalive timed out", JID: clientJID(e.client)})
+		sv.schedule(e.client, "keepalive timed out")
+
+	case *events.KeepAliveRestored:
+		rlog.Info("WhatsApp keepalive restored", "device", sv.device)
+
+	case *events.StreamReplaced:
+		sv.reset()
+		sv.dev.setState(&ConnectionStateEvent{State: StateReplaced, Reason: "another client connected with the same device", JID: clientJID(e.client)})
+
+	case *events.LoggedOut:
+		sv.reset()
+		// whatsmeow deletes the device before the event, so the JID is
+		// already gone.
+		sv.dev.invalidateDevice(e.client)
+		sv.dev.setState(&ConnectionStateEvent{State: StateLoggedOut, Reason: v.Reason.String()})
+	}
+}
+
+// reconnect runs the pending reconnection, and schedules another one if it
+// fails.
+func (sv *supervisor) reconnect() {
+	sv.retry = nil
+	if err := sv.dev.reconnectClient(sv.target); err != nil {
+		rlog.Warn("could not reconnect to WhatsApp", "device", sv.device, "attempt", sv.attempt, "error", err)
+		sv.schedule(sv.target, err.Error())
+	}
+}
+
+// schedule reconnects the client after the backoff delay of the next
+// attempt, unless its reconnection is already pending.
+func (sv *supervisor) schedule(client *whatsmeow.Client, reason string) {
+	if sv.retry != nil && sv.target == client {
+		return
+	}
+	sv.attempt++
+	sv.target = client
+	sv.retry = sv.after(reconnectDelay(sv.attempt))
+	sv.dev.setState(&ConnectionStateEvent{State: StateReconnecting, Reason: reason, JID: clientJID(client), Attempt: sv.attempt})
+}
+
+// reset cancels the pending reconnection and the backoff.
+func (sv *supervisor) reset() {
+	sv.retry, sv.attempt, sv.target = nil, 0, nil
+}
+
+// reconnectDelay returns the delay before a reconnection attempt, doubling
+// from minReconnectDelay up to maxReconnectDelay.
+func reconnectDelay(attempt int) time.Duration {
+	delay := minReconnectDelay
+	for i := 1; i < attempt && delay < maxReconnectDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxReconnectDelay)
+}
+
+func (d *device) isCurrent(client *whatsmeow.Client) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.whatsappCli == client
+}
+
+// reconnectClient connects the client again, unless it was replaced, for
+// instance by the reconnect endpoint, or logged out in the meantime.
+func (d *device) reconnectClient(client *whatsmeow.Client) error {
+	if !d.isCurrent(client) || client.Store.ID == nil || client.IsConnected() {
+		return nil
+	}
+	return d.connectClient(client)
+}
+
+// connectClient connects the client without holding d.mu, since connecting
+// waits for WhatsApp. A client replaced while it was connecting is
+// disconnected again, so it doesn't stay connected next to its successor.
+func (d *device) connectClient(client *whatsmeow.Client) error {
+	if err := client.Connect(); err != nil {
+		return err
+	}
+
+	if !d.isCurrent(client) {
+		client.Disconnect()
+	}
+	return nil
+}
+
+// disconnectClient closes the connection of the client.
+func (d *device) disconnectClient(client *whatsmeow.Client) {
+	client.Disconnect()
+}
+
+// invalidateDevice forgets a whatsmeow device that was logged out, which
+// unlinks it from d. whatsmeow already deletes it from the store, which is
+// done again in case that failed.
+func (d *device) invalidateDevice(client *whatsmeow.Client) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if client.Store.ID != nil {
+		if err := client.Store.Delete(); err != nil {
+			rlog.Error("could not delete logged out WhatsApp device", "device", d.id, "error", err)
+		}
+	}
+
+	if d.whatsappCli == client {
+		d.deviceStore = nil
+		d.pairing = nil
+	}
+}
+
+// setState records the state of the connection and publishes it when it
+// changed. Every reconnection attempt is published.
+func (d *device) setState(evt *ConnectionStateEvent) {
+	d.stateLock.Lock()
+	changed := d.state != evt.State || evt.State == StateReconnecting
+	d.state = evt.State
+	d.stateLock.Unlock()
+
+	if !changed {
+		return
+	}
+	evt.Device = d.id
+	evt.ChangedAt = time.Now()
+
+	rlog.Info("WhatsApp connection state changed", "device", d.id, "state", evt.State, "reason", evt.Reason, "jid", evt.JID, "attempt", evt.Attempt)
+
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+
+	if err := publishState(ctx, evt); err != nil {
+		rlog.Error("could not publish WhatsApp connection state", "device", d.id, "state", evt.State, "error", err)
+	}
+}
+
+// clientJID returns the JID of the device of the client, if it is linked.
+func clientJID(client *whatsmeow.Client) string {
+	if client.Store.ID == nil {
+		return ""
+	}
+	return client.Store.ID.String()
+}
@@ -0,0 +1,195 @@
+package whatsapp
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/store"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+func TestReconnectDelay(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, time.Second, reconnectDelay(1))
+	assert.Equal(t, 2*time.Second, reconnectDelay(2))
+	assert.Equal(t, 4*time.Second, reconnectDelay(3))
+	assert.Equal(t, 256*time.Second, reconnectDelay(9))
+	assert.Equal(t, maxReconnectDelay, reconnectDelay(10))
+	assert.Equal(t, maxReconnectDelay, reconnectDelay(1000))
+}
+
+// fakeDevice records what the supervisor does to the device.
+type fakeDevice struct {
+	current      *whatsmeow.Client
+	reconnectErr error
+
+	states       []string
+	reconnected  []*whatsmeow.Client
+	disconnected []*whatsmeow.Client
+	invalidated  []*whatsmeow.Client
+}
+
+func (f *fakeDevice) isCurrent(client *whatsmeow.Client) bool {
+	return f.current == client
+}
+
+func (f *fakeDevice) reconnectClient(client *whatsmeow.Client) error {
+	f.reconnected = append(f.reconnected, client)
+	return f.reconnectErr
+}
+
+func (f *fakeDevice) disconnectClient(client *whatsmeow.Client) {
+	f.disconnected = append(f.disconnected, client)
+}
+
+func (f *fakeDevice) invalidateDevice(client *whatsmeow.Client) {
+	f.invalidated = append(f.invalidated, client)
+}
+
+func (f *fakeDevice) setState(evt *ConnectionStateEvent) {
+	f.states = append(f.states, evt.State)
+}
+
+// testSupervisor returns a supervisor of a fake device connected with a
+// new client, and the delays of the reconnections it schedules.
+func testSupervisor() (*supervisor, *fakeDevice, *[]time.Duration) {
+	jid := types.NewJID("5579999999999", types.DefaultUserServer)
+	dev := &fakeDevice{current: &whatsmeow.Client{Store: &store.Device{ID: &jid}}}
+
+	var delays []time.Duration
+	sv := newSupervisor("default", dev)
+	sv.after = func(d time.Duration) <-chan time.Time {
+		delays = append(delays, d)
+		return make(chan time.Time)
+	}
+	return sv, dev, &delays
+}
+
+func TestSupervisor(t *testing.T) {
+	t.Parallel()
+
+	t.Run("ignores events from a stale client", func(t *testing.T) {
+		t.Parallel()
+
+		sv, dev, delays := testSupervisor()
+		stale := &whatsmeow.Client{Store: &store.Device{}}
+
+		for _, evt := range []any{
+			&events.Disconnected{},
+			&events.KeepAliveTimeout{ErrorCount: maxKeepAliveTimeouts},
+			&events.StreamReplaced{},
+			&events.LoggedOut{},
+		} {
+			sv.handle(connectionEvent{client: stale, evt: evt})
+		}
+
+		assert.Empty(t, dev.states)
+		assert.Empty(t, dev.disconnected)
+		assert.Empty(t, dev.invalidated)
+		assert.Empty(t, *delays)
+		assert.Nil(t, sv.retry)
+	})
+
+	t.Run("reconnects with backoff until connected", func(t *testing.T) {
+		t.Parallel()
+
+		sv, dev, delays := testSupervisor()
+
+		sv.handle(connectionEvent{client: dev.current, evt: &events.Disconnected{}})
+		assert.Equal(t, []string{StateDisconnected, StateReconnecting}, dev.states)
+		assert.Equal(t, []time.Duration{time.Second}, *delays)
+
+		dev.reconnectErr = errors.New("dial failed")
+		sv.reconnect()
+		assert.Equal(t, []*whatsmeow.Client{dev.current}, dev.reconnected)
+		assert.Equal(t, []time.Duration{time.Second, 2 * time.Second}, *delays)
+		assert.Equal(t, 2, sv.attempt)
+		assert.NotNil(t, sv.retry)
+
+		dev.reconnectErr = nil
+		sv.reconnect()
+		assert.Nil(t, sv.retry)
+
+		sv.handle(connectionEvent{client: dev.current, evt: &events.Connected{}})
+		assert.Equal(t, StateConnected, dev.states[len(dev.states)-1])
+		assert.Zero(t, sv.attempt)
+
+		// The backoff starts over after a successful connection.
+		sv.handle(connectionEvent{client: dev.current, evt: &events.Disconnected{}})
+		assert.Equal(t, time.Second, (*delays)[len(*delays)-1])
+	})
+
+	t.Run("disconnects after too many keepalive timeouts", func(t *testing.T) {
+		t.Parallel()
+
+		sv, dev, delays := testSupervisor()
+
+		for count := 1; count < maxKeepAliveTimeouts; count++ {
+			sv.handle(connectionEvent{client: dev.current, evt: &events.KeepAliveTimeout{ErrorCount: count}})
+		}
+		assert.Empty(t, dev.states)
+		assert.Empty(t, dev.disconnected)
+		assert.Empty(t, *delays)
+
+		sv.handle(connectionEvent{client: dev.current, evt: &events.KeepAliveTimeout{ErrorCount: maxKeepAliveTimeouts}})
+		assert.Equal(t, []*whatsmeow.Client{dev.current}, dev.disconnected)
+		assert.Equal(t, []string{StateDisconnected, StateReconnecting}, dev.states)
+		assert.Len(t, *delays, 1)
+	})
+
+	t.Run("deduplicates the pending retry", func(t *testing.T) {
+		t.Parallel()
+
+		sv, dev, delays := testSupervisor()
+
+		sv.handle(connectionEvent{client: dev.current, evt: &events.Disconnected{}})
+		retry := sv.retry
+		sv.handle(connectionEvent{client: dev.current, evt: &events.Disconnected{}})
+		sv.handle(connectionEvent{client: dev.current, evt: &events.KeepAliveTimeout{ErrorCount: maxKeepAliveTimeouts}})
+
+		assert.Len(t, *delays, 1)
+		assert.Equal(t, 1, sv.attempt)
+		assert.Equal(t, retry, sv.retry)
+
+		// A new client gets its own reconnection.
+		dev.current = &whatsmeow.Client{Store: &store.Device{}}
+		sv.handle(connectionEvent{client: dev.current, evt: &events.Disconnected{}})
+		assert.Len(t, *delays, 2)
+		assert.Equal(t, dev.current, sv.target)
+	})
+
+	for name, evt := range map[string]any{
+		"stream replaced": &events.StreamReplaced{},
+		"logged out":      &events.LoggedOut{},
+	} {
+		t.Run("does not reconnect once "+name, func(t *testing.T) {
+			t.Parallel()
+
+			sv, dev, delays := testSupervisor()
+
+			sv.handle(connectionEvent{client: dev.current, evt: &events.Disconnected{}})
+			require.NotNil(t, sv.retry)
+
+			sv.handle(connectionEvent{client: dev.current, evt: evt})
+			assert.Nil(t, sv.retry)
+			assert.Nil(t, sv.target)
+			assert.Zero(t, sv.attempt)
+
+			if _, ok := evt.(*events.LoggedOut); ok {
+				assert.Equal(t, []*whatsmeow.Client{dev.current}, dev.invalidated)
+				assert.Equal(t, StateLoggedOut, dev.states[len(dev.states)-1])
+			} else {
+				assert.Empty(t, dev.invalidated)
+				assert.Equal(t, StateReplaced, dev.states[len(dev.states)-1])
+			}
+			assert.Len(t, *delays, 1)
+			assert.Empty(t, dev.reconnected)
+		})
+	}
+}
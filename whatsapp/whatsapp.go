@@ -3,6 +3,7 @@ package whatsapp
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
@@ -11,13 +12,14 @@ import (
 	"time"
 
 	"encore.app/imolink"
+	"encore.app/internal/pkg/authz"
+	"encore.app/internal/pkg/chatqueue"
 	"encore.app/internal/pkg/openaicli"
-	"encore.app/internal/pkg/trello"
-	"encore.app/session"
+	"encore.dev"
+	"encore.dev/beta/errs"
 	"encore.dev/rlog"
 	"encore.dev/storage/sqldb"
 	"github.com/mdp/qrterminal/v3"
-	"go.mau.fi/whatsmeow"
 	"go.mau.fi/whatsmeow/proto/waE2E"
 	"go.mau.fi/whatsmeow/store"
 	"go.mau.fi/whatsmeow/store/sqlstore"
@@ -29,6 +31,8 @@ import (
 const (
 	assistantInitTimeout = 30 * time.Second
 	messageTimeout       = 120 * time.Second
+
+	busyMessage = "Estamos recebendo muitas mensagens neste momento. Por favor, envie sua mensagem novamente em alguns instantes."
 )
 
 var (
@@ -37,33 +41,41 @@ var (
 	})
 
 	secrets struct {
-		OpenAIKey    string
-		TrelloAPIKey string
-		TrelloToken  string
+		OpenAIKey string
 	}
 )
 
-// Service is the main service for the WhatsApp API.
+// Service is the main service for the WhatsApp API. It manages several
+// devices, one per WhatsApp number of the agency.
 //
 //encore:service
 type Service struct {
-	whatsappCli *whatsmeow.Client
-	deviceStore *store.Device
-	clientLock  sync.Mutex
-	sessionMgr  *session.SessionManager
-	openAICli   *openaicli.Client
-	trelloAPI   *trello.TrelloAPI
+	container   *sqlstore.Container
+	devicesLock sync.RWMutex
+	devices     map[string]*device
+
+	openAICli  *openaicli.Client
+	dispatcher *chatqueue.Dispatcher
+	debouncer  *chatqueue.Debouncer[incomingText]
 }
 
 func initService() (*Service, error) {
-	s := new(Service)
+	s := &Service{devices: make(map[string]*device)}
+
+	s.dispatcher = chatqueue.New(
+		chatqueue.WithMaxWorkers(cfg.MaxWorkers),
+		chatqueue.WithMaxPending(cfg.MaxPendingMessages),
+		chatqueue.WithObserver(dispatchMetrics{}),
+	)
+	s.debouncer = chatqueue.NewDebouncer(
+		time.Duration(cfg.MessageQuietWindowMillis)*time.Millisecond,
+		s.flushBurst,
+	)
 
 	if err := imolink.InitializeAssistant(context.Background()); err != nil {
 		return nil, fmt.Errorf("failed to initialize assistant: %w", err)
 	}
 
-	s.trelloAPI = trello.NewTrelloAPI(secrets.TrelloAPIKey, secrets.TrelloToken)
-
 	s.openAICli = openaicli.New(
 		secrets.OpenAIKey,
 		&http.Client{
@@ -71,214 +83,295 @@ func initService() (*Service, error) {
 		},
 	)
 
-	s.sessionMgr = session.NewSessionManager(imolink.Assistant, s.openAICli)
-
 	dbLog := walog.Stdout("whatsapp-database", "INFO", true)
-	container := sqlstore.NewWithDB(db.Stdlib(), "postgres", dbLog)
+	s.container = sqlstore.NewWithDB(db.Stdlib(), "postgres", dbLog)
+
+	ctx := context.Background()
 
-	deviceStore, err := container.GetFirstDevice()
+	devices, err := loadDevices(ctx)
 	if err != nil {
-		if strings.Contains(err.Error(), "no devices found") {
-			return s, nil
-		}
-		return nil, fmt.Errorf("could not get WhatsApp device: %w", err)
+		return nil, fmt.Errorf("could not load devices: %w", err)
 	}
 
-	if deviceStore != nil {
-		if err := s.connectToWhatsApp(deviceStore); err != nil {
-			return nil, fmt.Errorf("could not connect to WhatsApp: %w", err)
+	for _, cfg := range devices {
+		d := s.startDevice(cfg)
+		s.devices[cfg.ID] = d
+
+		deviceStore, err := s.storedDevice(ctx, cfg.ID)
+		if err != nil {
+			return nil, fmt.Errorf("could not get WhatsApp device of %s: %w", cfg.ID, err)
+		}
+
+		// Devices must be paired with a QR code first.
+		if deviceStore == nil {
+			continue
+		}
+
+		// WhatsApp being unreachable must not keep the service from
+		// starting: the supervisor retries in the background.
+		if err := s.connect(d, deviceStore); err != nil {
+			rlog.Error("could not connect to WhatsApp", "device", cfg.ID, "error", err)
+			d.notifySupervisor(d.whatsappCli, &events.Disconnected{})
 		}
 	}
-	s.deviceStore = deviceStore
 	return s, nil
 }
 
-//encore:api public raw path=/whatsapp/connect
+// WhatsappConnect links a device to a number, showing the QR code to scan
+// drawn in text.
+//
+//encore:api auth raw path=/whatsapp/devices/:id/connect
 func (s *Service) WhatsappConnect(w http.ResponseWriter, req *http.Request) {
-	s.clientLock.Lock()
-	defer s.clientLock.Unlock()
-
-	// If we have a client, check if it's really connected
-	if s.whatsappCli != nil {
-		if s.whatsappCli.IsConnected() {
-			if s.whatsappCli.IsLoggedIn() {
-				fmt.Fprintf(w, "Already connected and logged in to WhatsApp")
-				return
-			}
-			// If connected but not logged in, disconnect and reconnect
-			s.whatsappCli.Disconnect()
-		}
+	if err := authz.Require(authz.ScopeWhatsApp, authz.RoleAdmin); err != nil {
+		errs.HTTPError(w, err)
+		return
 	}
 
-	clientLog := walog.Stdout("Client", "DEBUG", true)
-	container := sqlstore.NewWithDB(db.Stdlib(), "postgres", clientLog)
-	deviceStore := container.NewDevice()
-
-	client := whatsmeow.NewClient(deviceStore, clientLog)
-	client.AddEventHandler(s.whatsappEventHandler)
-
-	s.whatsappCli = client
-	s.deviceStore = deviceStore
-
-	qrChan, err := client.GetQRChannel(context.Background())
+	d, err := s.device(encore.CurrentRequest().PathParams.Get("id"))
 	if err != nil {
-		http.Error(w, fmt.Sprintf("failed to get QR channel: %v", err), http.StatusInternalServerError)
+		errs.HTTPError(w, err)
 		return
 	}
 
-	if err := client.Connect(); err != nil {
+	p, err := s.pair(d)
+	if err != nil {
+		if errors.Is(err, errAlreadyLoggedIn) {
+			fmt.Fprintf(w, "A WhatsApp number is already linked to this device; log out first to pair it again")
+			return
+		}
 		http.Error(w, fmt.Sprintf("failed to connect: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	for evt := range qrChan {
-		if evt.Event == "code" {
-			fmt.Fprintf(w, "Scan this QR code with WhatsApp:\n\n")
-			qrterminal.GenerateHalfBlock(evt.Code, qrterminal.L, w)
-			return
-		} else {
-			fmt.Fprintf(w, "Login event: %s\n", evt.Event)
-		}
+	fmt.Fprintf(w, "Scan this QR code with WhatsApp:\n\n")
+	qrterminal.GenerateHalfBlock(p.code, qrterminal.L, w)
+}
+
+// Shutdown replies to the messages still waiting for their quiet window
+// and waits for the messages being processed before the service stops.
+func (s *Service) Shutdown(force context.Context) {
+	s.devicesLock.RLock()
+	for _, d := range s.devices {
+		close(d.supervisorStop)
+		<-d.supervisorDone
 	}
+	s.devicesLock.RUnlock()
 
-	if client.IsLoggedIn() {
-		fmt.Fprintf(w, "Successfully connected to WhatsApp")
-	} else {
-		fmt.Fprintf(w, "Failed to connect to WhatsApp")
+	s.debouncer.Flush()
+	if err := s.dispatcher.Close(force); err != nil {
+		rlog.Error("could not drain message queue", "error", err)
 	}
 }
 
-func (s *Service) whatsappEventHandler(evt any) {
+// incomingText is a message, or an audio transcription, waiting to be
+// merged with the rest of its burst into a single assistant turn.
+type incomingText struct {
+	device *device
+	chat   types.JID
+	sender types.JID
+	text   string
+}
+
+// whatsappEventHandler runs inside whatsmeow's event loop, so messages are
+// handed off to the dispatcher instead of being processed inline. The
+// dispatcher keeps each chat's messages in order.
+func (s *Service) whatsappEventHandler(d *device, evt any) {
+	d.lastEventAt.Store(time.Now().UnixNano())
+
 	switch v := evt.(type) {
+	case *events.PairSuccess:
+		s.linkDevice(d, v)
+
 	case *events.Message:
 		rlog.Debug(
 			"Message received",
+			"device", d.id,
 			"message", v.Message.GetConversation(),
 			"sender", v.Info.Sender,
 			"target", v.Info.Sender.User,
 		)
 
-		if s.sessionMgr == nil {
-			rlog.Error("Session manager not initialized")
-			return
+		chat := stripDeviceSuffix(v.Info.Chat)
+		if err := s.dispatcher.Enqueue(chatKey(d, chat), func() {
+			s.ingestMessage(d, v)
+		}); err != nil {
+			s.handleEnqueueError(d, chat, v.Info.Sender, err)
 		}
+	}
+}
 
-		cleanJID := stripDeviceSuffix(v.Info.Chat)
-		err := s.whatsappCli.SendChatPresence(cleanJID, types.ChatPresenceComposing, types.ChatPresenceMediaText)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "error setting chat presence: %v\n", err)
-		}
+// chatKey identifies a chat with one of the numbers, since a contact may
+// write to several of them.
+func chatKey(d *device, chat types.JID) string {
+	return d.id + "/" + chat.String()
+}
+
+// ingestMessage extracts the text of a message, transcribing audio if needed,
+// and adds it to the chat's burst. The burst is answered once the chat has
+// been quiet for the configured window.
+func (s *Service) ingestMessage(d *device, v *events.Message) {
+	text := v.Message.GetConversation()
 
-		ctx, cancel := context.WithTimeout(context.Background(), messageTimeout)
-		defer cancel()
-
-		if v.Message.GetAudioMessage() != nil {
-			audioMsg := v.Message.GetAudioMessage()
-
-			audioData, err := s.whatsappCli.DownloadAny(&waE2E.Message{
-				AudioMessage: audioMsg,
-			})
-			if err != nil {
-				rlog.Error("Failed to download audio", "error", err)
-				return
-			}
-
-			transcription, err := s.openAICli.TranscribeAudio(
-				openaicli.TranscribeAudioInput{
-					Name: "audio.ogg",
-					Data: bytes.NewReader(audioData),
-				},
-			)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "could not transcribe audio: %v\n", err)
-				return
-			}
-
-			fmt.Println("Transcription:", string(transcription))
-
-			// Process transcription as a regular message
-			response, err := s.sessionMgr.SendMessage(
-				ctx,
-				db,
-				s.trelloAPI,
-				v.Info.Sender.String(),
-				string(transcription),
-			)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "error processing transcription: %v\n", err)
-				return
-			}
-
-			cleanSenderJID := stripDeviceSuffix(v.Info.Sender)
-			if _, err := s.whatsappCli.SendMessage(
-				context.Background(),
-				cleanSenderJID,
-				&waE2E.Message{
-					Conversation: &response,
-				},
-			); err != nil {
-				fmt.Fprintf(os.Stderr, "could not send message: %v\n", err)
-				return
-			}
+	if v.Message.GetAudioMessage() != nil {
+		audioMsg := v.Message.GetAudioMessage()
+
+		client, _ := d.conversation()
+		if client == nil {
+			rlog.Warn("device logged out before downloading audio", "device", d.id)
 			return
 		}
 
-		response, err := s.sessionMgr.SendMessage(
-			ctx,
-			db,
-			s.trelloAPI,
-			v.Info.Sender.String(),
-			v.Message.GetConversation(),
-		)
+		audioData, err := client.DownloadAny(&waE2E.Message{
+			AudioMessage: audioMsg,
+		})
 		if err != nil {
-			// Clear typing indicator before returning on error
-			if err := s.whatsappCli.SendChatPresence(
-				cleanJID,
-				types.ChatPresencePaused,
-				types.ChatPresenceMediaText,
-			); err != nil {
-				fmt.Fprintf(os.Stderr, "could not clear chat presence: %v\n", err)
-			}
-			fmt.Fprintf(os.Stderr, "error processing message: %v\n", err)
+			rlog.Error("Failed to download audio", "error", err)
 			return
 		}
 
-		// Clear typing indicator
-		if err := s.whatsappCli.SendChatPresence(
-			cleanJID,
-			types.ChatPresencePaused,
-			types.ChatPresenceMediaText,
-		); err != nil {
-			fmt.Fprintf(os.Stderr, "could not clear chat presence: %v\n", err)
-		}
-
-		cleanSenderJID := stripDeviceSuffix(v.Info.Sender)
-		if _, err := s.whatsappCli.SendMessage(
-			context.Background(),
-			cleanSenderJID,
-			&waE2E.Message{
-				Conversation: &response,
+		transcription, err := s.openAICli.TranscribeAudio(
+			openaicli.TranscribeAudioInput{
+				Name: "audio.ogg",
+				Data: bytes.NewReader(audioData),
 			},
-		); err != nil {
-			fmt.Fprintf(os.Stderr, "could not send message: %v\n", err)
+		)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "could not transcribe audio: %v\n", err)
 			return
 		}
+
+		fmt.Println("Transcription:", string(transcription))
+		text = string(transcription)
+	}
+
+	if strings.TrimSpace(text) == "" {
+		return
+	}
+
+	chat := stripDeviceSuffix(v.Info.Chat)
+	s.debouncer.Add(chatKey(d, chat), incomingText{
+		device: d,
+		chat:   chat,
+		sender: v.Info.Sender,
+		text:   text,
+	})
+}
+
+// flushBurst is called by the debouncer with every message of a burst.
+func (s *Service) flushBurst(key string, burst []incomingText) {
+	texts := make([]string, 0, len(burst))
+	for _, in := range burst {
+		texts = append(texts, in.text)
+	}
+
+	// Replies go to whoever sent the last message of the burst.
+	last := burst[len(burst)-1]
+	message := strings.Join(texts, "\n")
+
+	if err := s.dispatcher.Enqueue(key, func() {
+		s.reply(last.device, last.chat, last.sender, message)
+	}); err != nil {
+		s.handleEnqueueError(last.device, last.chat, last.sender, err)
 	}
 }
 
-func (s *Service) connectToWhatsApp(deviceStore *store.Device) error {
-	s.clientLock.Lock()
-	defer s.clientLock.Unlock()
+// reply runs the OpenAI round trip for a message with the assistant of the
+// device and sends the response from its number.
+func (s *Service) reply(d *device, chat, sender types.JID, message string) {
+	client, sessionMgr := d.conversation()
+	if client == nil {
+		rlog.Warn("device logged out before replying", "device", d.id, "chat", chat)
+		return
+	}
 
-	clientLog := walog.Stdout("whatsapp-client", "INFO", true)
-	client := whatsmeow.NewClient(deviceStore, clientLog)
+	if err := client.SendChatPresence(chat, types.ChatPresenceComposing, types.ChatPresenceMediaText); err != nil {
+		fmt.Fprintf(os.Stderr, "error setting chat presence: %v\n", err)
+	}
 
-	client.AddEventHandler(s.whatsappEventHandler)
+	ctx, cancel := context.WithTimeout(context.Background(), messageTimeout)
+	defer cancel()
 
-	s.whatsappCli = client
-	s.deviceStore = deviceStore
+	response, err := sessionMgr.SendMessage(
+		ctx,
+		sender.String(),
+		message,
+	)
+
+	// Clear typing indicator
+	if err := client.SendChatPresence(
+		chat,
+		types.ChatPresencePaused,
+		types.ChatPresenceMediaText,
+	); err != nil {
+		fmt.Fprintf(os.Stderr, "could not clear chat presence: %v\n", err)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error processing message: %v\n", err)
+		return
+	}
+
+	refs := referencedProperties(response)
+	if len(refs) > 0 {
+		response = attributeLinks(response, s.chatToken(ctx, sender))
+	}
+
+	if _, err := client.SendMessage(
+		context.Background(),
+		stripDeviceSuffix(sender),
+		&waE2E.Message{
+			Conversation: &response,
+		},
+	); err != nil {
+		fmt.Fprintf(os.Stderr, "could not send message: %v\n", err)
+		return
+	}
+
+	s.sendPropertyImages(ctx, client, stripDeviceSuffix(sender), refs)
+	s.recordShownProperties(ctx, sender, refs)
+	s.recordRecommendations(ctx, sender, refs)
+}
+
+func (s *Service) handleEnqueueError(d *device, chat, sender types.JID, err error) {
+	rlog.Warn("could not enqueue message", "device", d.id, "chat", chat, "error", err)
+
+	if !errors.Is(err, chatqueue.ErrQueueFull) {
+		return
+	}
+
+	client, _ := d.conversation()
+	if client == nil {
+		return
+	}
+
+	msg := busyMessage
+	if _, err := client.SendMessage(
+		context.Background(),
+		stripDeviceSuffix(sender),
+		&waE2E.Message{Conversation: &msg},
+	); err != nil {
+		fmt.Fprintf(os.Stderr, "could not send busy message: %v\n", err)
+	}
+}
+
+// connect connects a device with its stored whatsmeow device, replacing
+// and disconnecting its client. The client is swapped under d.mu and
+// connects outside of it, so the device can be used meanwhile.
+func (s *Service) connect(d *device, deviceStore *store.Device) error {
+	client := s.newClient(d, deviceStore, walog.Stdout("whatsapp-client", "INFO", true))
+
+	d.mu.Lock()
+	old := d.whatsappCli
+	d.whatsappCli = client
+	d.deviceStore = deviceStore
+	d.pairing = nil
+	d.mu.Unlock()
+
+	if old != nil {
+		old.Disconnect()
+	}
 
-	if err := client.Connect(); err != nil {
+	if err := d.connectClient(client); err != nil {
 		return fmt.Errorf("could not connect to WhatsApp: %w", err)
 	}
 	return nil